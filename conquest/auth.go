@@ -0,0 +1,280 @@
+package conquest
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// tokens are refreshed when they expire in less than this
+const tokenRefreshMargin = 30 * time.Second
+
+// per user authentication state
+type authState struct {
+	token   string
+	expiry  time.Time
+	digests map[string]*digestChallenge
+}
+
+type digestChallenge struct {
+	realm, nonce, opaque, algorithm, qop string
+	nc                                   uint32
+}
+
+// returns what sets the Authorization header of t, or an empty string if
+// nothing does
+func authorizedBy(conquest *Conquest, t *Transaction) string {
+	switch {
+	case t.Auth != nil && t.Auth.Type == AUTH_BASIC:
+		return "Auth.Basic"
+	case t.Auth != nil && t.Auth.Type == AUTH_DIGEST:
+		return "Auth.Digest"
+	case t.Auth != nil:
+		return "Auth.Bearer"
+	case conquest.OAuth2 != nil && t.ReqOptions&CLEAR_HEADERS == 0:
+		return "OAuth2"
+	}
+
+	for _, p := range mergedPairs(conquest.Initials["Headers"], t.Headers,
+		t.ReqOptions&CLEAR_HEADERS != 0) {
+		if strings.EqualFold(p.Name, "Authorization") {
+			return "header " + p.Name
+		}
+	}
+	return ""
+}
+
+// adds authorization header to req. transaction authentication takes
+// precedence over conquest oauth2, which is dropped with initial headers.
+func authorize(ctx context.Context, c *http.Client, conquest *Conquest,
+	t *Transaction, u *mUser, req *http.Request) error {
+
+	if t.Auth == nil {
+		if conquest.OAuth2 == nil || t.ReqOptions&CLEAR_HEADERS != 0 {
+			return nil
+		}
+
+		token, err := oauth2Token(ctx, c, conquest, u)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+
+	switch t.Auth.Type {
+	case AUTH_BASIC:
+		req.SetBasicAuth(t.Auth.Args[0], t.Auth.Args[1])
+	case AUTH_BEARER:
+		token := t.Auth.Args[0]
+		if t.Auth.Fetch != nil {
+			val, err := FetchFrom(t.Auth.Fetch, conquest.label(req.URL), u)
+			if err != nil {
+				return err
+			}
+			token = string(val)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case AUTH_DIGEST:
+		// the first request goes without credentials, the challenge comes
+		// with its 401 response.
+		ch, ok := u.auth.digests[req.URL.Host]
+		if !ok {
+			return nil
+		}
+		req.Header.Set("Authorization",
+			digestAuthorization(ch, t.Auth.Args[0], t.Auth.Args[1], req))
+	}
+	return nil
+}
+
+// returns the oauth2 client credentials token of u. a new token is
+// requested when u has none or it is about to expire.
+func oauth2Token(ctx context.Context, c *http.Client, conquest *Conquest,
+	u *mUser) (string, error) {
+
+	if u.auth.token != "" && (u.auth.expiry.IsZero() ||
+		time.Now().Add(tokenRefreshMargin).Before(u.auth.expiry)) {
+		return u.auth.token, nil
+	}
+
+	o := conquest.OAuth2
+	tokenUrl, err := conquest.resolve(o.TokenUrl)
+	if err != nil {
+		return "", err
+	}
+
+	v := url.Values{}
+	v.Set("grant_type", "client_credentials")
+	if len(o.Scopes) > 0 {
+		v.Set("scope", strings.Join(o.Scopes, " "))
+	}
+
+	req, err := http.NewRequest("POST", tokenUrl.String(),
+		strings.NewReader(v.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(o.ClientId), url.QueryEscape(o.ClientSecret))
+
+	res, err := c.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return "", errors.New(fmt.Sprintf(
+			"OAuth2 token request returned as %d: %s", res.StatusCode, body))
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("OAuth2 token response has no access_token")
+	}
+
+	u.auth.token = token.AccessToken
+	u.auth.expiry = time.Time{}
+	if token.ExpiresIn > 0 {
+		u.auth.expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return u.auth.token, nil
+}
+
+// stores the digest challenge of res for the host of req. returns false if
+// res has no new challenge, so the request should not be retried.
+func (u *mUser) challenge(req *http.Request, res *http.Response) bool {
+	for _, h := range res.Header["Www-Authenticate"] {
+		if len(h) < 7 || !strings.EqualFold(h[:7], "Digest ") {
+			continue
+		}
+
+		params := parseAuthParams(h[7:])
+		// rejected credentials are only retried with a stale nonce
+		if req.Header.Get("Authorization") != "" &&
+			!strings.EqualFold(params["stale"], "true") {
+			return false
+		}
+
+		u.auth.digests[req.URL.Host] = &digestChallenge{
+			realm:     params["realm"],
+			nonce:     params["nonce"],
+			opaque:    params["opaque"],
+			algorithm: params["algorithm"],
+			qop:       params["qop"],
+		}
+		return true
+	}
+	return false
+}
+
+// parses comma separated key=value pairs of an authentication challenge,
+// values can be quoted.
+func parseAuthParams(s string) map[string]string {
+	params := map[string]string{}
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		eq := strings.Index(s, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimSpace(s[eq+1:])
+
+		var val string
+		if strings.HasPrefix(s, "\"") {
+			end := strings.Index(s[1:], "\"")
+			if end < 0 {
+				val, s = s[1:], ""
+			} else {
+				val, s = s[1:end+1], s[end+2:]
+			}
+		} else if comma := strings.Index(s, ","); comma >= 0 {
+			val, s = strings.TrimSpace(s[:comma]), s[comma:]
+		} else {
+			val, s = strings.TrimSpace(s), ""
+		}
+		params[key] = val
+		s = strings.TrimPrefix(strings.TrimSpace(s), ",")
+	}
+	return params
+}
+
+// computes digest authorization header value as described in rfc 7616.
+func digestAuthorization(ch *digestChallenge, user, pass string,
+	req *http.Request) string {
+
+	var h func() hash.Hash = md5.New
+	if strings.HasPrefix(strings.ToUpper(ch.algorithm), "SHA-256") {
+		h = sha256.New
+	}
+	digest := func(s string) string {
+		w := h()
+		w.Write([]byte(s))
+		return hex.EncodeToString(w.Sum(nil))
+	}
+
+	ch.nc++
+	nc := fmt.Sprintf("%08x", ch.nc)
+	cnonceBytes := make([]byte, 8)
+	rand.Read(cnonceBytes)
+	cnonce := hex.EncodeToString(cnonceBytes)
+
+	uri := req.URL.RequestURI()
+	ha1 := digest(user + ":" + ch.realm + ":" + pass)
+	if strings.HasSuffix(strings.ToLower(ch.algorithm), "-sess") {
+		ha1 = digest(ha1 + ":" + ch.nonce + ":" + cnonce)
+	}
+	ha2 := digest(req.Method + ":" + uri)
+
+	qop := ""
+	for _, q := range strings.Split(ch.qop, ",") {
+		if strings.TrimSpace(q) == "auth" {
+			qop = "auth"
+		}
+	}
+
+	var response string
+	if qop == "" {
+		response = digest(ha1 + ":" + ch.nonce + ":" + ha2)
+	} else {
+		response = digest(ha1 + ":" + ch.nonce + ":" + nc + ":" + cnonce +
+			":" + qop + ":" + ha2)
+	}
+
+	v := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`,
+		user, ch.realm, ch.nonce, uri, response)
+	if ch.algorithm != "" {
+		v += ", algorithm=" + ch.algorithm
+	}
+	if qop != "" {
+		v += fmt.Sprintf(`, qop=%s, nc=%s, cnonce="%s"`, qop, nc, cnonce)
+	}
+	if ch.opaque != "" {
+		v += fmt.Sprintf(`, opaque="%s"`, ch.opaque)
+	}
+	return v
+}
@@ -0,0 +1,263 @@
+package conquest
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseAuthParams(t *testing.T) {
+	tests := []struct {
+		in   string
+		want map[string]string
+	}{
+		{
+			`realm="testrealm@host.com", qop="auth,auth-int", nonce="dcd98b"`,
+			map[string]string{"realm": "testrealm@host.com",
+				"qop": "auth,auth-int", "nonce": "dcd98b"},
+		},
+		{
+			`Realm=api, stale=TRUE,algorithm=MD5`,
+			map[string]string{"realm": "api", "stale": "TRUE", "algorithm": "MD5"},
+		},
+		{
+			`  realm = "a b" ,  opaque="",nonce=n  `,
+			map[string]string{"realm": "a b", "opaque": "", "nonce": "n"},
+		},
+		{
+			`realm="unterminated`,
+			map[string]string{"realm": "unterminated"},
+		},
+		{"", map[string]string{}},
+		{"no pairs", map[string]string{}},
+	}
+
+	for _, tt := range tests {
+		if got := parseAuthParams(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseAuthParams(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDigestAuthorization(t *testing.T) {
+	tests := []struct {
+		name string
+		ch   digestChallenge
+		h    func() hash.Hash
+		qop  string
+	}{
+		{"md5 auth", digestChallenge{realm: "testrealm@host.com",
+			nonce: "dcd98b7102dd2f0e8b11d0f600bfb0c093", qop: "auth,auth-int",
+			opaque: "5ccc069c403ebaf9f0171e9517f40e41"}, md5.New, "auth"},
+		{"md5 without qop", digestChallenge{realm: "r", nonce: "n"},
+			md5.New, ""},
+		{"sha-256", digestChallenge{realm: "r", nonce: "n",
+			algorithm: "SHA-256", qop: "auth"}, sha256.New, "auth"},
+		{"md5-sess", digestChallenge{realm: "r", nonce: "n",
+			algorithm: "MD5-sess", qop: "auth"}, md5.New, "auth"},
+		{"auth-int only", digestChallenge{realm: "r", nonce: "n",
+			qop: "auth-int"}, md5.New, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "http://host/dir/index.html?a=1", nil)
+			ch := tt.ch
+			v := digestAuthorization(&ch, "Mufasa", "Circle Of Life", req)
+			if !strings.HasPrefix(v, "Digest ") {
+				t.Fatalf("value does not start with Digest: %s", v)
+			}
+			params := parseAuthParams(v[7:])
+
+			digest := func(s string) string {
+				w := tt.h()
+				w.Write([]byte(s))
+				return hex.EncodeToString(w.Sum(nil))
+			}
+			ha1 := digest("Mufasa:" + ch.realm + ":Circle Of Life")
+			if strings.HasSuffix(ch.algorithm, "-sess") {
+				ha1 = digest(ha1 + ":" + ch.nonce + ":" + params["cnonce"])
+			}
+			ha2 := digest("GET:/dir/index.html?a=1")
+			want := digest(ha1 + ":" + ch.nonce + ":" + ha2)
+			if tt.qop != "" {
+				want = digest(ha1 + ":" + ch.nonce + ":00000001:" +
+					params["cnonce"] + ":" + tt.qop + ":" + ha2)
+			}
+
+			if params["response"] != want {
+				t.Errorf("response = %s, want %s", params["response"], want)
+			}
+			if params["uri"] != "/dir/index.html?a=1" {
+				t.Errorf("uri = %s", params["uri"])
+			}
+			if params["qop"] != tt.qop {
+				t.Errorf("qop = %q, want %q", params["qop"], tt.qop)
+			}
+			if tt.qop != "" && params["nc"] != "00000001" {
+				t.Errorf("nc = %s, want 00000001", params["nc"])
+			}
+			if params["opaque"] != ch.opaque {
+				t.Errorf("opaque = %q, want %q", params["opaque"], ch.opaque)
+			}
+		})
+	}
+}
+
+func TestDigestAuthorizationCountsNonce(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://host/", nil)
+	ch := &digestChallenge{realm: "r", nonce: "n", qop: "auth"}
+
+	digestAuthorization(ch, "u", "p", req)
+	v := digestAuthorization(ch, "u", "p", req)
+	if nc := parseAuthParams(v[7:])["nc"]; nc != "00000002" {
+		t.Errorf("nc of the second request = %s, want 00000002", nc)
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorization string
+		challenges    []string
+		want          bool
+	}{
+		{"no challenge", "", nil, false},
+		{"basic only", "", []string{`Basic realm="r"`}, false},
+		{"first digest", "", []string{`Basic realm="r"`,
+			`Digest realm="r", nonce="n"`}, true},
+		{"rejected credentials", `Digest username="u"`,
+			[]string{`Digest realm="r", nonce="n2"`}, false},
+		{"stale nonce", `Digest username="u"`,
+			[]string{`Digest realm="r", nonce="n2", stale=true`}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := newUser(0, "")
+			req, _ := http.NewRequest("GET", "http://host/", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			res := &http.Response{Header: http.Header{
+				"Www-Authenticate": tt.challenges}}
+
+			if got := u.challenge(req, res); got != tt.want {
+				t.Fatalf("challenge = %v, want %v", got, tt.want)
+			}
+			if _, stored := u.auth.digests["host"]; stored != tt.want {
+				t.Errorf("challenge is stored = %v, want %v", stored, tt.want)
+			}
+		})
+	}
+}
+
+// users answer the digest challenge once, following requests reuse it
+func TestPerformDigest(t *testing.T) {
+	md5Hex := func(s string) string {
+		h := md5.Sum([]byte(s))
+		return hex.EncodeToString(h[:])
+	}
+
+	var m sync.Mutex
+	challenges, rejected := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			m.Lock()
+			defer m.Unlock()
+			h := req.Header.Get("Authorization")
+			if !strings.HasPrefix(h, "Digest ") {
+				challenges++
+				w.Header().Set("WWW-Authenticate",
+					`Digest realm="api", nonce="n0nce", qop="auth"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			p := parseAuthParams(h[7:])
+			ha1 := md5Hex("u:api:p")
+			ha2 := md5Hex(req.Method + ":" + req.URL.RequestURI())
+			want := md5Hex(ha1 + ":n0nce:" + p["nc"] + ":" + p["cnonce"] +
+				":auth:" + ha2)
+			if p["username"] != "u" || p["response"] != want {
+				rejected++
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		}))
+	defer srv.Close()
+
+	c := runTestScript(t, `conquest.Host("`+srv.URL+`")
+.Iterations(3)
+.Users(2, function(users){
+users.Every(function(user){
+user.Do("GET", "/secret?a=1").Auth.Digest("u", "p").Response.StatusCode(200);
+});
+});`)
+	r := performTest(t, c)
+
+	if r.Fails != 0 || r.Success != 6 {
+		t.Errorf("%d of %d transactions failed: %v", r.Fails, r.Hits, r.Failed)
+	}
+	if challenges != 2 || rejected != 0 {
+		t.Errorf("%d challenges, %d rejected answers; want 2 and 0",
+			challenges, rejected)
+	}
+}
+
+// every user gets its own token once and sends it as bearer, unless the
+// transaction clears initial headers
+func TestPerformOAuth2(t *testing.T) {
+	var m sync.Mutex
+	tokens := 0
+	bearers := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			m.Lock()
+			defer m.Unlock()
+			if req.URL.Path == "/token" {
+				id, secret, _ := req.BasicAuth()
+				req.ParseForm()
+				if id != "id" || secret != "s3cret" ||
+					req.PostForm.Get("grant_type") != "client_credentials" ||
+					req.PostForm.Get("scope") != "read write" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				tokens++
+				w.Write([]byte(`{"access_token": "tok-` + strconv.Itoa(tokens) +
+					`", "expires_in": 3600}`))
+				return
+			}
+			bearers[req.URL.Path+" "+req.Header.Get("Authorization")]++
+		}))
+	defer srv.Close()
+
+	c := runTestScript(t, `conquest.Host("`+srv.URL+`")
+.OAuth2({"tokenUrl": "/token", "clientId": "id", "clientSecret": "s3cret",
+"scopes": ["read", "write"]})
+.Iterations(3)
+.Users(2, function(users){
+users.Every(function(user){
+user.Do("GET", "/api");
+user.Do("GET", "/public").ClearHeaders();
+});
+});`)
+	r := performTest(t, c)
+
+	if r.Fails != 0 || r.Success != 12 {
+		t.Errorf("%d of %d transactions failed: %v", r.Fails, r.Hits, r.Failed)
+	}
+	want := map[string]int{"/api Bearer tok-1": 3, "/api Bearer tok-2": 3,
+		"/public ": 6}
+	if tokens != 2 || !reflect.DeepEqual(bearers, want) {
+		t.Errorf("%d tokens, requests = %v; want 2 and %v", tokens, bearers, want)
+	}
+}
@@ -0,0 +1,176 @@
+package conquest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// response which conditions and checks are applied to
+type checkedResponse struct {
+	*http.Response
+	Body    []byte
+	Cookies []*http.Cookie
+	// status of a grpc call
+	GRPC *status.Status
+}
+
+// a response condition returns nil if res satisfies expected
+type condition func(expected interface{}, res *checkedResponse) error
+
+// response conditions by their ResConditions keys
+var conditions = map[string]condition{
+	"StatusCode": statusCodeCondition,
+	"Header":     headerCondition,
+	"Cookie":     cookieCondition,
+	"Contains":   containsCondition,
+	"GRPCStatus": grpcStatusCondition,
+	"Data":       dataCondition,
+}
+
+func statusCodeCondition(expected interface{}, res *checkedResponse) error {
+	if int64(res.StatusCode) != expected.(int64) {
+		return errors.New(fmt.Sprintf(
+			"Expected status code is %d but it returned as %d.",
+			expected.(int64), res.StatusCode))
+	}
+	return nil
+}
+
+func headerCondition(expected interface{}, res *checkedResponse) error {
+	for name, val := range expected.(map[string]string) {
+		h := res.Header.Get(name)
+		if h != val {
+			return errors.New(fmt.Sprintf(
+				"Expected %s header value is %s but it returned as %s.",
+				name, val, h))
+		}
+	}
+	return nil
+}
+
+func cookieCondition(expected interface{}, res *checkedResponse) error {
+	// copied, matched cookies are crossed off per response
+	eCookies := map[string]string{}
+	for n, val := range expected.(map[string]string) {
+		eCookies[n] = val
+	}
+
+	for _, cookie := range res.Cookies {
+		if _, ok := eCookies[cookie.Name]; !ok {
+			continue
+		}
+
+		if eCookies[cookie.Name] != cookie.Value {
+			return errors.New(fmt.Sprintf(
+				"Expected %s cookie value is %s but it returned as %s",
+				cookie.Name, eCookies[cookie.Name], cookie.Value))
+		}
+		delete(eCookies, cookie.Name)
+	}
+
+	for n := range eCookies {
+		return errors.New(fmt.Sprintf("No cookie named as %s", n))
+	}
+	return nil
+}
+
+func containsCondition(expected interface{}, res *checkedResponse) error {
+	if !strings.Contains(string(res.Body), expected.(string)) {
+		return errors.New(fmt.Sprintf("Response does not contain %s.",
+			expected.(string)))
+	}
+	return nil
+}
+
+func dataCondition(expected interface{}, res *checkedResponse) error {
+	result := &graphQLResult{}
+	if err := json.Unmarshal(res.Body, result); err != nil {
+		return errors.New("Response is not a GraphQL result: " + err.Error())
+	}
+
+	for p, val := range expected.(map[string]string) {
+		v, ok := lookupPath(result.Data, p)
+		if !ok {
+			return errors.New("No data at " + p)
+		}
+
+		got, ok := v.(string)
+		if !ok {
+			b, _ := json.Marshal(v)
+			got = string(b)
+		}
+		if got != val {
+			return errors.New(fmt.Sprintf(
+				"Expected data at %s is %s but it returned as %s.", p, val, got))
+		}
+	}
+	return nil
+}
+
+func grpcStatusCondition(expected interface{}, res *checkedResponse) error {
+	if code := res.GRPC.Code(); code != expected.(codes.Code) {
+		return errors.New(fmt.Sprintf(
+			"Expected gRPC status is %s but it returned as %s: %s",
+			expected.(codes.Code), code, res.GRPC.Message()))
+	}
+	return nil
+}
+
+// checks response conditions of t, returns the first unsatisfied one.
+func checkConditions(t *Transaction, res *checkedResponse) error {
+	for k, v := range t.ResConditions {
+		cond, ok := conditions[k]
+		if !ok {
+			return errors.New("Unknown response condition: " + k)
+		}
+		if err := cond(v, res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// a named check which is written in script
+type Check struct {
+	Name string
+	// index of the registered script function
+	Fn int
+}
+
+type checkResult struct {
+	Name   string
+	Passed bool
+}
+
+// runs every named check of t in the vm of u. a check passes if its
+// function returns a truthy value. returns results of all checks and an
+// error for the first failed one.
+func runChecks(c *Conquest, t *Transaction, u *mUser,
+	res *hookResponse) ([]checkResult, error) {
+
+	var failed error
+	results := make([]checkResult, 0, len(t.Checks))
+	for _, check := range t.Checks {
+		ret, _, err := u.call(c, check.Fn, res)
+		passed := err == nil && ret.IsDefined() && !ret.IsNull()
+		if passed {
+			passed, _ = ret.ToBoolean()
+		}
+		results = append(results, checkResult{Name: check.Name, Passed: passed})
+
+		if !passed && failed == nil {
+			failed = errors.New("Check " + check.Name + " failed.")
+			if err != nil {
+				failed = errors.New("Check " + check.Name + " failed: " +
+					err.Error())
+			}
+		}
+	}
+	return results, failed
+}
@@ -0,0 +1,60 @@
+package conquest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// passes and fails of every check are counted, a failed or throwing check
+// fails its transaction
+func TestPerformChecks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			switch req.URL.Path {
+			case "/full":
+				w.Write([]byte(`{"items": [1, 2]}`))
+			case "/empty":
+				w.Write([]byte(`{"items": []}`))
+			default:
+				w.Write([]byte(`not json`))
+			}
+		}))
+	defer srv.Close()
+
+	c := runTestScript(t, `conquest.Host("`+srv.URL+`")
+.Iterations(1)
+.Users(1, function(users){
+users.Every(function(user){
+var hasItems = function(res){ return JSON.parse(res.body).items.length > 0; };
+user.Do("GET", "/full").Response.Check("has items", hasItems)
+.Check("ok", function(res){ return res.status == 200 && res.time >= 0; });
+user.Do("GET", "/empty").Response.Check("has items", hasItems);
+user.Do("GET", "/broken").Response.Check("has items", hasItems);
+});
+});`)
+	r := performTest(t, c)
+
+	if r.Success != 1 || r.Fails != 2 {
+		t.Errorf("%d succeeded, %d failed: %v", r.Success, r.Fails, r.Failed)
+	}
+	want := map[string]*checkStat{
+		"has items": {Pass: 1, Fail: 2},
+		"ok":        {Pass: 1},
+	}
+	if !reflect.DeepEqual(r.Checks, want) {
+		for name, stat := range r.Checks {
+			t.Logf("%s: %+v", name, stat)
+		}
+		t.Error("check stats differ")
+	}
+	if e := r.Failed["/empty"][0].Error.Error(); e != "Check has items failed." {
+		t.Errorf("failure of /empty = %s", e)
+	}
+	if e := r.Failed["/broken"][0].Error.Error(); !strings.HasPrefix(e,
+		"Check has items failed: SyntaxError") {
+		t.Errorf("failure of /broken = %s", e)
+	}
+}
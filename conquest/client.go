@@ -5,14 +5,15 @@ import (
 	"net/http"
 )
 
-// creates specified http.Client with provided parameters
-func buildHttpClient(scheme string) (*http.Client, error) {
-	c := &http.Client{}
-	if scheme == "https" {
-		c.Transport = &http.Transport{
+// creates the http.Client which is shared by crew members. transactions
+// may target several origins, so tls settings are always in place and only
+// take effect for https urls.
+func buildHttpClient() (*http.Client, error) {
+	c := &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
 			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
-		return c, nil
+		},
 	}
 	return c, nil
 }
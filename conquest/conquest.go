@@ -2,7 +2,14 @@ package conquest
 
 import (
 	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/robertkrimen/otto"
 )
 
 const (
@@ -20,48 +27,352 @@ const (
 	CTX_FINALLY
 )
 
+const (
+	AUTH_BASIC uint8 = 1 << iota
+	AUTH_DIGEST
+	AUTH_BEARER
+)
+
+// what a user does when a transaction fails
+const (
+	ON_FAILURE_CONTINUE uint8 = iota
+	ON_FAILURE_ABORT_USER
+	ON_FAILURE_RESTART
+)
+
+const (
+	BLOCK_REPEAT uint8 = 1 << iota
+	BLOCK_IF
+)
+
 const (
 	FETCH_HEADER uint8 = 1 << iota
 	FETCH_COOKIE
 	FETCH_DISK
+	FETCH_SHARED
 	//FETCH_HTML
 )
 
 type Conquest struct {
 	Proto, Host, scheme string
-	Sequential          bool
-	TotalUsers          uint64
-	Initials            map[string]map[string]interface{}
-	Duration            time.Duration
-	Track               *TransactionContext
+	// named origins, referenced in transaction paths as "alias:/path"
+	Hosts      map[string]*url.URL
+	Sequential bool
+	// populations of users, each with its own flow
+	Groups []*Group
+	// performed once before users start and once after all of them stop
+	Setup, Teardown []*Transaction
+	// upper limit of the teardown phase, it is not bounded by Duration
+	TeardownTimeout time.Duration
+	// journeys per user, Duration is not applied when it is set
+	Iterations uint64
+	// upper limit of transactions for the whole run
+	TotalTransactions uint64
+	Initials          map[string]map[string]interface{}
+	// client credentials which every user gets its own token with
+	OAuth2 *OAuth2Config
+	// request signing of every transaction
+	Sign     *SignConfig
+	Duration time.Duration
+	// script vm, users copy it to run hooks
+	vm  *otto.Otto
+	vmM *sync.Mutex
+	// grpc connections and descriptors
+	grpc *grpcPool
+}
+
+// a population of users which follows its own flow. users of every group
+// run at the same time.
+type Group struct {
+	Name       string
+	TotalUsers uint64
+	Track      *TransactionContext
+	// pause of a user after each transaction
+	ThinkTime time.Duration
+	// users are started evenly over this duration
+	RampUp time.Duration
+}
+
+// returns the group named as name, creates it if it does not exist
+func (c *Conquest) group(name string) *Group {
+	for _, g := range c.Groups {
+		if g.Name == name {
+			return g
+		}
+	}
+
+	g := &Group{Name: name}
+	c.Groups = append(c.Groups, g)
+	return g
+}
+
+type OAuth2Config struct {
+	TokenUrl, ClientId, ClientSecret string
+	Scopes                           []string
 }
 
 func NewConquest() *Conquest {
 	c := &Conquest{
 		Proto:    "HTTP/1.1",
 		Initials: map[string]map[string]interface{}{},
+		Hosts:    map[string]*url.URL{},
+		vmM:      &sync.Mutex{},
+		grpc:     newGRPCPool(),
 		Duration: time.Duration(time.Minute * 1),
+
+		TeardownTimeout: 30 * time.Second,
 	}
 	return c
 }
 
+// Sets the host which transaction paths are on, with its scheme
+func (c *Conquest) SetHost(host string) error {
+	hostUrl, err := url.Parse(host)
+	if err != nil {
+		return err
+	}
+	if hostUrl.Scheme == "" || hostUrl.Host == "" {
+		return errors.New("Host must be an absolute url like https://host: " + host)
+	}
+	c.Host = hostUrl.Host
+	c.scheme = hostUrl.Scheme
+	return nil
+}
+
+// Sets a named origin which transactions reach by an alias prefixed path
+func (c *Conquest) SetAlias(name, host string) error {
+	hostUrl, err := url.Parse(host)
+	if err != nil {
+		return err
+	}
+	if hostUrl.Scheme == "" || hostUrl.Host == "" {
+		return errors.New("Host of " + name + " must be an absolute url.")
+	}
+	c.Hosts[name] = hostUrl
+	return nil
+}
+
+// Sets HTTP protocol of requests
+func (c *Conquest) SetProto(proto string) error {
+	if proto != "HTTP/1.1" && proto != "HTTP/1.0" {
+		return errors.New("Only HTTP/1.1 and HTTP/1.0 protocols are available.")
+	}
+	c.Proto = proto
+	return nil
+}
+
+// Sets an initial value of kind, Headers or Cookies, which every request
+// is sent with
+func (c *Conquest) SetInitial(kind, name, value string) {
+	if _, exists := c.Initials[kind]; !exists {
+		c.Initials[kind] = map[string]interface{}{}
+	}
+	c.Initials[kind][name] = value
+}
+
+// Sets users of group name, the group which is defined without a name is
+// named as ""
+func (c *Conquest) SetUsers(name string, n uint64) error {
+	if n == 0 {
+		return errors.New("Total users can not be equal zero.")
+	}
+	for _, g := range c.Groups {
+		if g.Name == name {
+			g.TotalUsers = n
+			return nil
+		}
+	}
+	return errors.New("Users group " + name + " is not defined.")
+}
+
+// Shares n users among groups in proportion to their users, every group
+// keeps at least one user
+func (c *Conquest) ScaleUsers(n uint64) error {
+	if n == 0 {
+		return errors.New("Total users can not be equal zero.")
+	}
+	if n < uint64(len(c.Groups)) {
+		return errors.New("Total users can not be lesser than the number of groups.")
+	}
+
+	total := uint64(0)
+	for _, g := range c.Groups {
+		total += g.TotalUsers
+	}
+	if total == 0 {
+		return nil
+	}
+
+	// largest remainder method, so shares add up to n
+	users := make([]uint64, len(c.Groups))
+	order := make([]int, len(c.Groups))
+	remainders := make([]uint64, len(c.Groups))
+	left := n
+	for i, g := range c.Groups {
+		users[i] = n * g.TotalUsers / total
+		remainders[i] = n * g.TotalUsers % total
+		order[i] = i
+		left -= users[i]
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return remainders[order[i]] > remainders[order[j]]
+	})
+	for _, i := range order[:left] {
+		users[i]++
+	}
+
+	// groups which have no share take a user of the largest one
+	for i := range users {
+		if users[i] > 0 {
+			continue
+		}
+		largest := 0
+		for j := range users {
+			if users[j] > users[largest] {
+				largest = j
+			}
+		}
+		users[largest]--
+		users[i] = 1
+	}
+
+	for i, g := range c.Groups {
+		g.TotalUsers = users[i]
+	}
+	return nil
+}
+
+// resolves path of a transaction to an absolute url. p can be a path on
+// conquest host, a path prefixed by a host alias like "auth:/token" or an
+// absolute url. an url in the query of a path does not make it absolute.
+func (c *Conquest) resolve(p string) (*url.URL, error) {
+	u, err := url.Parse(p)
+	if err != nil {
+		return nil, err
+	}
+	if u.IsAbs() && u.Host != "" {
+		return u, nil
+	}
+
+	if i := strings.Index(p, ":/"); i > 0 && !strings.Contains(p[:i], "/") {
+		host, ok := c.Hosts[p[:i]]
+		if !ok {
+			return nil, errors.New("Unknown host alias: " + p[:i])
+		}
+		return url.Parse(host.Scheme + "://" + host.Host +
+			strings.TrimSuffix(host.Path, "/") + p[i+1:])
+	}
+
+	if c.Host == "" {
+		return nil, errors.New("Host is not set for " + p)
+	}
+	return url.Parse(c.scheme + "://" + c.Host + p)
+}
+
+// returns the name of u in reports and caches. urls on conquest host are
+// named by their path only.
+func (c *Conquest) label(u *url.URL) string {
+	if u.Host == c.Host {
+		return u.Path
+	}
+	return u.Host + u.Path
+}
+
 type Transaction struct {
 	conquest                              *Conquest
+	pos                                   Position
 	ReqOptions                            uint8
 	isMultiPart, Skip                     bool
 	Verb, Path                            string
 	Headers, Cookies, Body, ResConditions map[string]interface{}
+	Auth                                  *AuthNotation
+	// indexes of registered script functions
+	Before, After []int
+	Checks        []*Check
+	// overrides conquest signing
+	Sign *SignConfig
+	// relative pick rate in random mode, zero means 1
+	Weight uint64
+	// one of ON_FAILURE_* constants
+	OnFailure uint8
+	// control block, transaction sends no request of its own when it is set
+	Block *Block
+	// users wait each other here, no request is sent when it is set
+	Rendezvous *Rendezvous
+	// websocket session which is opened with the request
+	WS *WSNotation
+	// response is read as a stream of events
+	Stream *StreamNotation
+	// unary grpc call instead of an http request
+	GRPC *GRPCNotation
+	// graphql operation which makes the body
+	GraphQL *GraphQLNotation
+	// body which is sent as it is instead of Body values
+	Raw *RawBody
+}
+
+// a literal request body, like json or xml
+type RawBody struct {
+	Data        string
+	ContentType string
+}
+
+// transactions which are repeated or performed on a condition
+type Block struct {
+	Type uint8
+	// repeat count of BLOCK_REPEAT
+	Times uint64
+	// index of the registered condition function of BLOCK_IF
+	Fn           int
+	Transactions []*Transaction
+}
+
+// returns the name of b in reports
+func (b *Block) name() string {
+	if b.Type == BLOCK_REPEAT {
+		return "Repeat " + strconv.FormatUint(b.Times, 10)
+	}
+	return "If"
+}
+
+// authentication of a transaction. Args are username and password for
+// basic and digest, Fetch or Args[0] is the token for bearer.
+type AuthNotation struct {
+	Type  uint8
+	Args  []string
+	Fetch *FetchNotation
 }
 
 type TransactionContext struct {
 	CtxType      uint8
 	Transactions []*Transaction
 	Next         *TransactionContext
+	// relative pick rate among then contexts, zero means 1
+	Weight uint64
+}
+
+// returns w as a pick weight, unset weights count as 1
+func weightOf(w uint64) float64 {
+	if w == 0 {
+		return 1
+	}
+	return float64(w)
 }
 
 type FetchNotation struct {
 	Type uint8
 	Args []string
+	pos  Position
+}
+
+// where something is declared in the script
+type Position struct {
+	File         string
+	Line, Column int
+}
+
+func (p Position) String() string {
+	return p.File + ":" + strconv.Itoa(p.Line) + ":" + strconv.Itoa(p.Column)
 }
 
 func mapToFetchNotation(src map[string]interface{}) (*FetchNotation, error) {
@@ -72,8 +383,10 @@ func mapToFetchNotation(src map[string]interface{}) (*FetchNotation, error) {
 		return nil, errors.New("map can not be converted to FetchNotation")
 	}
 
+	pos, _ := src["pos"].(Position)
 	return &FetchNotation{
 		Type: src["type"].(uint8),
 		Args: src["args"].([]string),
+		pos:  pos,
 	}, nil
 }
@@ -0,0 +1,115 @@
+package conquest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScaleUsers(t *testing.T) {
+	tests := []struct {
+		users []uint64
+		n     uint64
+		want  []uint64
+	}{
+		{[]uint64{80, 20}, 10, []uint64{8, 2}},
+		{[]uint64{80, 20}, 100, []uint64{80, 20}},
+		{[]uint64{80, 20, 1}, 10, []uint64{7, 2, 1}},
+		{[]uint64{80, 20, 1}, 100, []uint64{79, 20, 1}},
+		{[]uint64{1, 1, 1}, 10, []uint64{4, 3, 3}},
+		{[]uint64{99, 1}, 2, []uint64{1, 1}},
+		{[]uint64{5}, 1, []uint64{1}},
+	}
+
+	for _, tt := range tests {
+		c := NewConquest()
+		for _, u := range tt.users {
+			c.Groups = append(c.Groups, &Group{TotalUsers: u})
+		}
+		if err := c.ScaleUsers(tt.n); err != nil {
+			t.Fatalf("ScaleUsers(%d) of %v: %s", tt.n, tt.users, err)
+		}
+
+		got := []uint64{}
+		for _, g := range c.Groups {
+			got = append(got, g.TotalUsers)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ScaleUsers(%d) of %v = %v, want %v", tt.n, tt.users, got, tt.want)
+		}
+	}
+}
+
+func TestScaleUsersFails(t *testing.T) {
+	c := NewConquest()
+	c.Groups = []*Group{{TotalUsers: 1}, {TotalUsers: 1}}
+	for _, n := range []uint64{0, 1} {
+		if err := c.ScaleUsers(n); err == nil {
+			t.Errorf("ScaleUsers(%d) of 2 groups did not fail", n)
+		}
+	}
+}
+
+func TestSetHost(t *testing.T) {
+	tests := []struct {
+		host, want string
+		fails      bool
+	}{
+		{"https://staging.local", "staging.local", false},
+		{"http://127.0.0.1:8080", "127.0.0.1:8080", false},
+		{"staging.example.com", "", true},
+		{"/path", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		c := NewConquest()
+		err := c.SetHost(tt.host)
+		if (err != nil) != tt.fails {
+			t.Errorf("SetHost(%q) error = %v", tt.host, err)
+		}
+		if c.Host != tt.want {
+			t.Errorf("SetHost(%q) host = %q, want %q", tt.host, c.Host, tt.want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	c := NewConquest()
+	c.SetHost("https://api.local:8443")
+	c.SetAlias("auth", "https://auth.local/realm/")
+	c.SetAlias("cdn", "http://cdn.local")
+
+	tests := []struct {
+		path, want string
+	}{
+		{"/login", "https://api.local:8443/login"},
+		{"/login?next=https://x/home", "https://api.local:8443/login?next=https://x/home"},
+		{"/a/b://c", "https://api.local:8443/a/b://c"},
+		{"auth:/token", "https://auth.local/realm/token"},
+		{"auth:/token?redirect=http://api.local/", "https://auth.local/realm/token?redirect=http://api.local/"},
+		{"cdn:/logo.png", "http://cdn.local/logo.png"},
+		{"http://other.local/x?y=1", "http://other.local/x?y=1"},
+		{"wss://api.local/live", "wss://api.local/live"},
+	}
+
+	for _, tt := range tests {
+		u, err := c.resolve(tt.path)
+		if err != nil {
+			t.Errorf("resolve(%q): %s", tt.path, err)
+			continue
+		}
+		if u.String() != tt.want {
+			t.Errorf("resolve(%q) = %s, want %s", tt.path, u, tt.want)
+		}
+	}
+}
+
+func TestResolveFails(t *testing.T) {
+	c := NewConquest()
+	c.SetAlias("auth", "https://auth.local")
+	for _, p := range []string{"/login", "billing:/invoices", "/a?b=%zz"} {
+		if u, err := c.resolve(p); err == nil {
+			t.Errorf("resolve(%q) = %s, want an error", p, u)
+		}
+	}
+}
@@ -1,125 +1,627 @@
 package conquest
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"math/rand"
 	"net/http"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-var (
-	timerC <-chan time.Time
-)
-
 // transaction getter func builder
-// returns a func that it can return a transaction as randomly
-// sequential.
-func transactionGetter(s bool, t []*Transaction) func() *Transaction {
+// returns a func that it can return transactions of t in declared order.
+func transactionGetter(t []*Transaction) func() *Transaction {
 	c := len(t)
+	i := 0
+
+	return func() *Transaction {
+		if i == c {
+			return nil
+		}
+		r := t[i]
+		i++
+		return r
+	}
+}
+
+// weighted random selection over transactions of then contexts. the
+// chance of a transaction is the share of its context among then contexts
+// times its own share in the context. rendezvous points are not picked,
+// they are bound to the transaction which follows them, so released users
+// perform it together.
+type picker struct {
+	t []*Transaction
+	// cumulative chances
+	cum    []float64
+	names  []string
+	counts []uint64
+	// index of transactions in t
+	index map[*Transaction]int
+	// rendezvous points which are waited at before transactions of t
+	rendezvous map[*Transaction][]*Transaction
+}
+
+func newPicker(c *Conquest, g *Group) *picker {
+	p := &picker{
+		index:      map[*Transaction]int{},
+		rendezvous: map[*Transaction][]*Transaction{},
+	}
+
+	ctxTotal := 0.0
+	for track := g.Track; track != nil; track = track.Next {
+		if track.CtxType == CTX_THEN {
+			ctxTotal += weightOf(track.Weight)
+		}
+	}
+
+	sum := 0.0
+	for track := g.Track; track != nil; track = track.Next {
+		if track.CtxType != CTX_THEN {
+			continue
+		}
+
+		tTotal := 0.0
+		for _, t := range track.Transactions {
+			if !t.Skip && t.Rendezvous == nil {
+				tTotal += weightOf(t.Weight)
+			}
+		}
+
+		// points which are not followed by a transaction are never waited at
+		points := []*Transaction{}
+		for _, t := range track.Transactions {
+			if t.Skip {
+				continue
+			}
+			if t.Rendezvous != nil {
+				points = append(points, t)
+				continue
+			}
+			if len(points) > 0 {
+				p.rendezvous[t] = points
+				points = []*Transaction{}
+			}
+			sum += weightOf(track.Weight) / ctxTotal * weightOf(t.Weight) / tTotal
+			p.index[t] = len(p.t)
+			p.t = append(p.t, t)
+			p.cum = append(p.cum, sum)
 
-	if s {
-		i := 0
-		return func() *Transaction {
-			if i == c {
-				return nil
+			name := t.Verb + " " + t.Path
+			if t.Block != nil {
+				name = t.Block.name()
+			} else if u, err := c.resolve(t.Path); err == nil {
+				name = t.Verb + " " + c.label(u)
 			}
-			r := t[i]
-			i++
-			return r
+			if g.Name != "" {
+				name = g.Name + ": " + name
+			}
+			p.names = append(p.names, name)
 		}
 	}
-	rand.Seed(time.Now().Unix())
+	p.counts = make([]uint64, len(p.t))
+	return p
+}
+
+// returns a getter which picks as many transactions as then contexts have.
+// rendezvous points of a picked transaction are returned ahead of it.
+func (p *picker) getter(rnd *rand.Rand) func() *Transaction {
+	i := 0
+	queued := []*Transaction{}
 	return func() *Transaction {
-		r := rand.Intn(c)
-		return t[r]
+		if len(queued) > 0 {
+			t := queued[0]
+			queued = queued[1:]
+			return t
+		}
+		if i == len(p.t) {
+			return nil
+		}
+		i++
+
+		n := sort.SearchFloat64s(p.cum, rnd.Float64()*p.cum[len(p.cum)-1])
+		if n == len(p.t) {
+			n--
+		}
+		t := p.t[n]
+		if points := p.rendezvous[t]; len(points) > 0 {
+			queued = append(queued, points[1:]...)
+			queued = append(queued, t)
+			return points[0]
+		}
+		return t
 	}
 }
 
-func chargeCrew(c *[]dutyRoutine, C *reportChannels) {
-	var done sync.WaitGroup
-	done.Add(len(*c))
+// returns true if t is one of the transactions of p
+func (p *picker) picks(t *Transaction) bool {
+	if p == nil {
+		return false
+	}
+	_, ok := p.index[t]
+	return ok
+}
 
-	for _, r := range *c {
-		go r(C.Success, C.Fail, &done)
+// counts t as performed if it is one of the transactions of p, picks
+// which are never performed are not part of the distribution
+func (p *picker) count(t *Transaction) {
+	if p == nil {
+		return
+	}
+	if n, ok := p.index[t]; ok {
+		atomic.AddUint64(&p.counts[n], 1)
 	}
+}
 
-	done.Wait()
-	*c = nil
+// returns expected and actual pick rates of transactions by their names
+func (p *picker) distribution() []*distributionStat {
+	total := uint64(0)
+	for i := range p.counts {
+		total += atomic.LoadUint64(&p.counts[i])
+	}
+
+	stats := []*distributionStat{}
+	byName := map[string]*distributionStat{}
+	prev := 0.0
+	for i, name := range p.names {
+		stat, ok := byName[name]
+		if !ok {
+			stat = &distributionStat{Name: name}
+			byName[name] = stat
+			stats = append(stats, stat)
+		}
+		stat.Expected += p.cum[i] - prev
+		prev = p.cum[i]
+		if total > 0 {
+			stat.Actual += float64(atomic.LoadUint64(&p.counts[i])) /
+				float64(total)
+		}
+	}
+	return stats
+}
+
+const (
+	// pause of a restarted user, doubled by every consecutive restart
+	restartBackoff    = 500 * time.Millisecond
+	maxRestartBackoff = 30 * time.Second
+)
+
+// state of a single Perform call. every run has its own deadline, so
+// performing a conquest more than once in a process starts a fresh timer.
+type run struct {
+	// cancelled by the caller of Perform, e.g. on SIGINT
+	ctx context.Context
+	// ctx bounded by Conquest.Duration, same as ctx in iteration mode
+	deadline context.Context
+	client   *http.Client
+	conquest *Conquest
+	C        *reportChannels
+	// dispatched transactions and their upper limit, zero means no limit
+	dispatched, budget uint64
+	// values captured by setup
+	shared map[string]string
+	// rendezvous points which users wait at
+	barriers barriers
+	// random selection of then transactions per group
+	pickers map[*Group]*picker
+}
+
+func newRun(ctx context.Context, c *Conquest, client *http.Client,
+	C *reportChannels) (*run, context.CancelFunc) {
+
+	r := &run{
+		ctx:      ctx,
+		deadline: ctx,
+		client:   client,
+		conquest: c,
+		C:        C,
+		budget:   c.TotalTransactions,
+		pickers:  map[*Group]*picker{},
+	}
+	for _, g := range c.Groups {
+		r.pickers[g] = newPicker(c, g)
+	}
+
+	if c.Iterations > 0 {
+		return r, func() {}
+	}
+
+	var cancel context.CancelFunc
+	r.deadline, cancel = context.WithTimeout(ctx, c.Duration)
+	return r, cancel
+}
+
+// returns the context which bounds transactions of given context type.
+// finally contexts are clean-up steps, they are still performed after the
+// duration is over and only stop when the run itself is cancelled.
+func (r *run) ctxFor(ctxType uint8) context.Context {
+	if ctxType == CTX_FINALLY {
+		return r.ctx
+	}
+	return r.deadline
 }
 
-// adds a duty assigned member to crew and performs it with using
-// chargeCrew func
-func createCrew(client *http.Client, s bool, c *Conquest,
-	t []*Transaction, C *reportChannels) error {
+// reserves a transaction from the budget of the run, returns false if the
+// budget is exhausted.
+func (r *run) take() bool {
+	if r.budget == 0 {
+		return true
+	}
+	return atomic.AddUint64(&r.dispatched, 1) <= r.budget
+}
+
+// what a user does next
+type flow uint8
+
+const (
+	flowNext flow = iota
+	// ctx is done or the budget is exhausted
+	flowStop
+	// a failed transaction stopped the user, finally contexts are skipped
+	flowAbort
+	// a failed transaction sends the user back to the start of its journey
+	flowRestart
+)
 
-	var routines []dutyRoutine
-	getTransaction := transactionGetter(s, t)
+// performs transactions one by one on behalf of user u until they run out
+// or the user has to stop, e.g. ctx is done or a transaction failed with
+// an abort or restart setting.
+func (r *run) perform(ctx context.Context, g *Group, u *mUser,
+	getTransaction func() *Transaction) flow {
 
-DUTY_LOOP:
+	p := r.pickers[g]
 	for d := getTransaction(); d != nil; d = getTransaction() {
 		if d.Skip {
 			continue
 		}
 
-		if routines == nil {
-			routines = []dutyRoutine{}
+		if d.Block != nil {
+			f, performed := r.block(ctx, g, u, d.Block)
+			p.count(d)
+			if f != flowNext {
+				return f
+			}
+			// a block which is picked and skipped is thought after like a
+			// transaction, users would spin on a false condition otherwise
+			if !performed && r.random() && p.picks(d) {
+				r.think(ctx, g)
+			}
+			continue
+		}
+
+		if d.Rendezvous != nil {
+			r.barriers.get(d.Rendezvous.Name).wait(ctx, d.Rendezvous)
+			p.count(d)
+			continue
+		}
+
+		if ctx.Err() != nil || !r.take() {
+			return flowStop
+		}
+
+		ok := false
+		routine, err := buildDutyRoutine(r.client, r.conquest, d, u)
+		if err != nil {
+			f := NewFail(REASON_TRANSACTION, d.Path, err, 0, nil)
+			f.Group = g.Name
+			r.C.Fail <- f
+			u.last.Status = 0
+		} else {
+			ok = routine(ctx, r.C.Success, r.C.Fail)
+		}
+		p.count(d)
+		u.last.Ok = ok
+		if !ok {
+			u.failures++
+		}
+
+		if ctx.Err() != nil {
+			return flowStop
+		}
+		if !ok {
+			switch d.OnFailure {
+			case ON_FAILURE_ABORT_USER:
+				return flowAbort
+			case ON_FAILURE_RESTART:
+				return flowRestart
+			}
 		}
 
-		routine, err := buildDutyRoutine(client, c, d)
+		r.think(ctx, g)
+	}
+
+	if ctx.Err() != nil {
+		return flowStop
+	}
+	return flowNext
+}
+
+// waits for the think time of g after a transaction of a user, or until
+// ctx is done.
+func (r *run) think(ctx context.Context, g *Group) {
+	if g.ThinkTime > 0 {
+		select {
+		case <-ctx.Done():
+		case <-time.After(g.ThinkTime):
+		}
+	}
+}
+
+// returns true if then contexts are picked by their weights
+func (r *run) random() bool {
+	return r.conquest.Iterations == 0 && !r.conquest.Sequential
+}
+
+// performs transactions of b, as many times as it is repeated or once if
+// its condition holds for u. returns false if none of them are performed.
+func (r *run) block(ctx context.Context, g *Group, u *mUser, b *Block) (flow, bool) {
+	switch b.Type {
+	case BLOCK_IF:
+		ret, _, err := u.call(r.conquest, b.Fn, u.last)
 		if err != nil {
-			return err
+			f := NewFail(REASON_TRANSACTION, b.name(), err, 0, nil)
+			f.Group = g.Name
+			r.C.Fail <- f
+			return flowNext, false
 		}
+		if holds, _ := ret.ToBoolean(); !holds {
+			return flowNext, false
+		}
+		return r.perform(ctx, g, u, transactionGetter(b.Transactions)), true
 
-		if s {
-			for i := uint64(0); i < c.TotalUsers; i++ {
-				routines = append(routines, routine)
+	case BLOCK_REPEAT:
+		for i := uint64(0); i < b.Times; i++ {
+			f := r.perform(ctx, g, u, transactionGetter(b.Transactions))
+			if f != flowNext {
+				return f, true
 			}
-			chargeCrew(&routines, C)
+		}
+	}
+	return flowNext, b.Times > 0
+}
+
+// walks the track of g once and performs contexts whose type is in kinds
+// in declared order.
+func (r *run) journey(g *Group, u *mUser, kinds uint8) flow {
+	for track := g.Track; track != nil; track = track.Next {
+		if track.CtxType&kinds == 0 {
 			continue
 		}
 
-		routines = append(routines, routine)
-		if timerC == nil {
-			timerC = time.After(c.Duration)
+		f := r.perform(r.ctxFor(track.CtxType), g, u,
+			transactionGetter(track.Transactions))
+		if f != flowNext {
+			return f
 		}
+	}
+	return flowNext
+}
 
-		select {
-		case <-timerC:
-			chargeCrew(&routines, C)
-			break DUTY_LOOP
-		default:
-			if uint64(len(routines)) < c.TotalUsers {
-				continue DUTY_LOOP
+// drops the session of u and pauses it before it starts over, so a
+// journey which keeps failing does not flood the server. returns false if
+// ctx is done during the pause.
+func (r *run) restart(ctx context.Context, u *mUser) bool {
+	u.reset()
+
+	d := restartBackoff
+	for i := uint(0); i < u.restarts && d < maxRestartBackoff; i++ {
+		d *= 2
+	}
+	if d > maxRestartBackoff {
+		d = maxRestartBackoff
+	}
+	u.restarts++
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// routine of a crew member.
+// in iteration mode every journey goes through every, then and finally
+// contexts in declared order. otherwise every contexts are performed once,
+// then contexts are repeated until the duration is over, either in
+// declared order or picked by their weights, and finally contexts close
+// the journey.
+// a restarted user starts over with a fresh session after a pause which
+// grows until a journey goes through, an aborted one stops without its
+// finally contexts.
+func (r *run) member(g *Group, u *mUser) {
+	if n := r.conquest.Iterations; n > 0 {
+		for i := uint64(0); i < n; i++ {
+			switch r.journey(g, u, CTX_EVERY|CTX_THEN|CTX_FINALLY) {
+			case flowStop, flowAbort:
+				return
+			case flowRestart:
+				if !r.restart(r.deadline, u) {
+					return
+				}
+			default:
+				u.restarts = 0
 			}
-			chargeCrew(&routines, C)
 		}
+		return
+	}
 
+	p := r.pickers[g]
+	var f flow
+	for {
+		f = r.journey(g, u, CTX_EVERY)
+		for f == flowNext && len(p.t) > 0 {
+			if r.conquest.Sequential {
+				f = r.journey(g, u, CTX_THEN)
+			} else {
+				f = r.perform(r.deadline, g, u, p.getter(u.rand))
+			}
+			if f == flowNext {
+				u.restarts = 0
+			}
+		}
+		if f != flowRestart {
+			break
+		}
+		if !r.restart(r.deadline, u) {
+			f = flowStop
+			break
+		}
 	}
 
-	return nil
+	if f != flowAbort && r.ctx.Err() == nil {
+		r.journey(g, u, CTX_FINALLY)
+	}
+}
+
+func (r *run) newUser(id uint64, g *Group) *mUser {
+	u := newUser(id, g.Name)
+	u.shared = r.shared
+	return u
+}
+
+// performs transactions of a setup or teardown phase as u, returns false
+// if any of them failed or ctx is done.
+func (r *run) phase(ctx context.Context, name string, u *mUser,
+	ts []*Transaction) bool {
+
+	u.Group = name
+	failures := u.failures
+	f := r.perform(ctx, &Group{Name: name}, u, transactionGetter(ts))
+	return f == flowNext && u.failures == failures
+}
+
+// returns values which hooks of u captured into vars as strings, objects
+// are kept as json.
+func capturedVars(u *mUser) map[string]string {
+	captured := map[string]string{}
+	if u.vm == nil {
+		return captured
+	}
+
+	exp, err := u.vm.vars.Export()
+	if err != nil {
+		return captured
+	}
+	vars, _ := exp.(map[string]interface{})
+	for k, v := range vars {
+		if str, ok := v.(string); ok {
+			captured[k] = str
+			continue
+		}
+		if b, err := json.Marshal(v); err == nil {
+			captured[k] = string(b)
+		}
+	}
+	return captured
+}
+
+// creates a crew which contains a member per user of every group and
+// waits until everyone has finished. users of a group with ramp-up are
+// started evenly over it.
+func (r *run) createCrew() {
+	var done sync.WaitGroup
+
+	id := uint64(0)
+	for _, g := range r.conquest.Groups {
+		if g.Track == nil {
+			continue
+		}
+
+		var gap time.Duration
+		if g.RampUp > 0 {
+			gap = g.RampUp / time.Duration(g.TotalUsers)
+		}
+
+		for i := uint64(0); i < g.TotalUsers; i++ {
+			done.Add(1)
+			go func(g *Group, u *mUser, delay time.Duration) {
+				defer done.Done()
+
+				if delay > 0 {
+					select {
+					case <-r.deadline.Done():
+						return
+					case <-time.After(delay):
+					}
+				}
+				r.member(g, u)
+			}(g, r.newUser(id, g), gap*time.Duration(i))
+			id++
+		}
+	}
+
+	done.Wait()
 }
 
 // creates a crew which contains members with assigned routines
-// and runs all.
-func Perform(conquest *Conquest, reporter *report) error {
-	if conquest.Track == nil {
+// and runs all. cancelling ctx stops new transactions, aborts in-flight
+// ones and lets the reporter print what has been collected so far.
+func Perform(ctx context.Context, conquest *Conquest, reporter *report) error {
+	empty := true
+	for _, g := range conquest.Groups {
+		if g.Track != nil {
+			empty = false
+		}
+	}
+	if empty {
 		return errors.New("Empty transaction stack.")
 	}
 
-	httpClient, err := buildHttpClient(conquest.scheme)
+	httpClient, err := buildHttpClient()
 
 	if err != nil {
 		return err
 	}
+	defer conquest.grpc.close()
 
-	for track := conquest.Track; track != nil; track = track.Next {
-		seq := conquest.Sequential || track.CtxType&(CTX_FINALLY|CTX_EVERY) > 0
+	// setup and teardown are performed by a user of their own, which is
+	// not bounded by the duration or the transaction budget.
+	admin := newUser(0, "")
+	phases := &run{
+		ctx:      ctx,
+		deadline: ctx,
+		client:   httpClient,
+		conquest: conquest,
+		C:        reporter.C,
+	}
 
-		if err := createCrew(httpClient, seq,
-			conquest, track.Transactions, reporter.C); err != nil {
-			return err
+	// teardown fetches shared values like users do
+	ok := phases.phase(ctx, "setup", admin, conquest.Setup)
+	admin.shared = capturedVars(admin)
+	if ok {
+		r, cancel := newRun(ctx, conquest, httpClient, reporter.C)
+		r.shared = admin.shared
+		r.createCrew()
+		cancel()
+		reporter.Rendezvous = r.barriers.stats()
+
+		if !conquest.Sequential && conquest.Iterations == 0 {
+			for _, g := range conquest.Groups {
+				reporter.Distribution = append(reporter.Distribution,
+					r.pickers[g].distribution()...)
+			}
 		}
+	} else if ctx.Err() == nil {
+		reporter.SetupFailed = true
+	}
+
+	// teardown cleans up even after an interrupt, a second signal kills.
+	// it is bounded by its own timeout, so a hanging endpoint does not
+	// keep the process running.
+	if len(conquest.Teardown) > 0 {
+		tctx, cancel := context.WithTimeout(context.Background(),
+			conquest.TeardownTimeout)
+		phases.phase(tctx, "teardown", admin, conquest.Teardown)
+		cancel()
+	}
+
+	if ctx.Err() != nil {
+		reporter.C.Interrupt <- true
+		return nil
 	}
 	reporter.C.Done <- true
 	return nil
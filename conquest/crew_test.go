@@ -0,0 +1,552 @@
+package conquest
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func pickerGroup() (*Conquest, *Group, map[string]*Transaction) {
+	c := NewConquest()
+	c.SetHost("http://api.local")
+
+	ts := map[string]*Transaction{}
+	tr := func(path string, weight uint64, skip bool) *Transaction {
+		t := &Transaction{Verb: "GET", Path: path, Weight: weight, Skip: skip}
+		ts[path] = t
+		return t
+	}
+
+	g := &Group{Name: "browsers"}
+	g.Track = &TransactionContext{
+		CtxType:      CTX_EVERY,
+		Transactions: []*Transaction{tr("/login", 0, false)},
+		Next: &TransactionContext{
+			CtxType: CTX_THEN,
+			Weight:  3,
+			Transactions: []*Transaction{tr("/a", 0, false),
+				tr("/b", 3, false), tr("/skipped", 5, true)},
+			Next: &TransactionContext{
+				CtxType:      CTX_THEN,
+				Transactions: []*Transaction{tr("/c", 0, false)},
+			},
+		},
+	}
+	c.Groups = []*Group{g}
+	return c, g, ts
+}
+
+func TestPickerExpected(t *testing.T) {
+	c, g, _ := pickerGroup()
+	p := newPicker(c, g)
+
+	want := map[string]float64{
+		"browsers: GET /a": 0.75 * 0.25,
+		"browsers: GET /b": 0.75 * 0.75,
+		"browsers: GET /c": 0.25,
+	}
+	stats := p.distribution()
+	if len(stats) != len(want) {
+		t.Fatalf("%d stats, want %d", len(stats), len(want))
+	}
+	for _, s := range stats {
+		if math.Abs(s.Expected-want[s.Name]) > 1e-9 {
+			t.Errorf("%s expected = %f, want %f", s.Name, s.Expected, want[s.Name])
+		}
+		if s.Actual != 0 {
+			t.Errorf("%s actual = %f before anything is performed", s.Name, s.Actual)
+		}
+	}
+}
+
+func TestPickerActual(t *testing.T) {
+	c, g, _ := pickerGroup()
+	p := newPicker(c, g)
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		get := p.getter(rnd)
+		picks := 0
+		for tr := get(); tr != nil; tr = get() {
+			p.count(tr)
+			picks++
+		}
+		if picks != 3 {
+			t.Fatalf("getter picked %d transactions, want 3", picks)
+		}
+	}
+
+	for _, s := range p.distribution() {
+		if math.Abs(s.Actual-s.Expected) > 0.02 {
+			t.Errorf("%s actual = %f, expected %f", s.Name, s.Actual, s.Expected)
+		}
+	}
+}
+
+func TestPickerCountsPerformedOnly(t *testing.T) {
+	c, g, ts := pickerGroup()
+	p := newPicker(c, g)
+
+	// picks which are not performed are not counted
+	get := p.getter(rand.New(rand.NewSource(1)))
+	get()
+	get()
+
+	p.count(ts["/c"])
+	p.count(ts["/login"])
+	p.count(ts["/skipped"])
+	var none *picker
+	none.count(ts["/c"])
+
+	for _, s := range p.distribution() {
+		want := 0.0
+		if s.Name == "browsers: GET /c" {
+			want = 1
+		}
+		if s.Actual != want {
+			t.Errorf("%s actual = %f, want %f", s.Name, s.Actual, want)
+		}
+	}
+}
+
+func TestPickerRendezvous(t *testing.T) {
+	c := NewConquest()
+	c.SetHost("http://api.local")
+	point := func(name string) *Transaction {
+		return &Transaction{Rendezvous: &Rendezvous{Name: name, Users: 2}}
+	}
+	r1, r2, r3, trailing := point("a"), point("b"), point("c"), point("end")
+	a := &Transaction{Verb: "GET", Path: "/a"}
+	b := &Transaction{Verb: "GET", Path: "/b"}
+	skipped := &Transaction{Verb: "GET", Path: "/skipped", Skip: true}
+
+	g := &Group{Track: &TransactionContext{
+		CtxType:      CTX_THEN,
+		Transactions: []*Transaction{r1, a, r2, skipped, r3, b, trailing},
+	}}
+	p := newPicker(c, g)
+
+	want := map[*Transaction][]*Transaction{a: {r1, a}, b: {r2, r3, b}}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		get := p.getter(rnd)
+		seq := []*Transaction{}
+		picks := 0
+		for tr := get(); tr != nil; tr = get() {
+			seq = append(seq, tr)
+			if tr.Rendezvous != nil {
+				continue
+			}
+			picks++
+			if !reflect.DeepEqual(seq, want[tr]) {
+				t.Fatalf("pick of %s is %d transactions, want %d", tr.Path,
+					len(seq), len(want[tr]))
+			}
+			seq = seq[:0]
+		}
+		if picks != 2 || len(seq) != 0 {
+			t.Fatalf("getter picked %d transactions and left %d points", picks, len(seq))
+		}
+	}
+
+	for _, s := range p.distribution() {
+		if s.Name != "GET /a" && s.Name != "GET /b" {
+			t.Errorf("%s is in the distribution", s.Name)
+		}
+		if math.Abs(s.Expected-0.5) > 1e-9 {
+			t.Errorf("%s expected = %f, want 0.5", s.Name, s.Expected)
+		}
+	}
+}
+
+// performs c and returns its report, the summary is not written anywhere
+func performTest(t *testing.T, c *Conquest) *report {
+	t.Helper()
+	devnull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devnull.Close()
+
+	r := NewReporter(devnull, false)
+	if err := Perform(context.Background(), c, r); err != nil {
+		t.Fatal(err)
+	}
+	<-r.C.Done
+	return r
+}
+
+// released users of random mode perform the transaction which follows the
+// rendezvous together
+func TestPerformRendezvous(t *testing.T) {
+	var m sync.Mutex
+	checkouts := []time.Time{}
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == "/checkout" {
+				m.Lock()
+				checkouts = append(checkouts, time.Now())
+				m.Unlock()
+			}
+		}))
+	defer srv.Close()
+
+	c := runTestScript(t, `conquest.Host("`+srv.URL+`")
+.Duration("5s")
+.TotalTransactions(40)
+.Users(3, function(users){
+users.ThinkTime("50ms");
+users.Then(function(user){
+user.Rendezvous("checkout", 3, "1s");
+user.Do("POST", "/checkout");
+user.Do("GET", "/browse").Weight(3);
+});
+});`)
+	r := performTest(t, c)
+
+	if r.Fails != 0 {
+		t.Errorf("%d transactions failed: %v", r.Fails, r.Failed)
+	}
+	if len(r.Rendezvous) != 1 || r.Rendezvous[0].Full == 0 {
+		t.Fatalf("rendezvous is never released by full arrival: %+v", r.Rendezvous)
+	}
+	if len(checkouts) < 3 {
+		t.Fatalf("%d checkouts, want at least 3", len(checkouts))
+	}
+
+	// users think between transactions, so only released ones arrive
+	// within a few milliseconds. the last round may time out short.
+	sort.Slice(checkouts, func(i, j int) bool {
+		return checkouts[i].Before(checkouts[j])
+	})
+	rounds := []int{1}
+	for i := 1; i < len(checkouts); i++ {
+		if checkouts[i].Sub(checkouts[i-1]) > 25*time.Millisecond {
+			rounds = append(rounds, 0)
+		}
+		rounds[len(rounds)-1]++
+	}
+	for i, n := range rounds[:len(rounds)-1] {
+		if n != 3 {
+			t.Errorf("%d users checked out together in round %d, want 3: %v",
+				n, i, rounds)
+		}
+	}
+}
+
+// a false condition which is picked is thought after like a transaction,
+// users of random mode do not spin on it
+func TestMemberThinksAfterFalseIf(t *testing.T) {
+	c := runTestScript(t, `conquest.Host("http://api.local")
+.Duration("300ms")
+.Users(1, function(users){
+users.ThinkTime("50ms");
+users.Then(function(user){
+user.If(function(last, vars){ vars.checks = (vars.checks || 0) + 1; return false; },
+function(user){ user.Do("GET", "/never"); });
+});
+});`)
+	r, cancel := newRun(context.Background(), c, http.DefaultClient, nil)
+	defer cancel()
+
+	g := c.Groups[0]
+	u := r.newUser(1, g)
+	r.member(g, u)
+
+	checks := capturedVars(u)["checks"]
+	if n, err := strconv.Atoi(checks); err != nil || n < 1 || n > 7 {
+		t.Errorf("condition is checked %s times in 300ms, want at most 7", checks)
+	}
+}
+
+// values which setup captures are fetched by users and by teardown
+func TestPerformSetupTeardown(t *testing.T) {
+	var m sync.Mutex
+	tenants := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == "/tenants" && req.Method == "POST" {
+				w.Write([]byte(`{"id": "t-42"}`))
+				return
+			}
+			m.Lock()
+			tenants[req.Method+" "+req.URL.Path] = req.Header.Get("X-Tenant")
+			m.Unlock()
+		}))
+	defer srv.Close()
+
+	c := runTestScript(t, `conquest.Host("`+srv.URL+`")
+.Iterations(1)
+.Setup(function(user){
+user.Do("POST", "/tenants").After(function(res, vars){
+vars.tenant = JSON.parse(res.body).id;
+});
+})
+.Teardown(function(user){
+user.Do("DELETE", "/tenants").SetHeader("X-Tenant", function(fetch){ return fetch.FromShared("tenant"); });
+})
+.Users(2, function(users){
+users.Every(function(user){
+user.Do("GET", "/items").SetHeader("X-Tenant", function(fetch){ return fetch.FromShared("tenant"); });
+});
+});`)
+	if problems := Validate(c); len(problems) != 0 {
+		t.Errorf("problems: %v", problems)
+	}
+	r := performTest(t, c)
+
+	if r.Fails != 0 || r.Success != 4 {
+		t.Errorf("%d of %d transactions failed: %v", r.Fails, r.Hits, r.Failed)
+	}
+	want := map[string]string{"GET /items": "t-42", "DELETE /tenants": "t-42"}
+	if !reflect.DeepEqual(tenants, want) {
+		t.Errorf("tenants = %v, want %v", tenants, want)
+	}
+}
+
+// counts requests by method and path, then answers them by handler if it
+// is given
+type countingServer struct {
+	*httptest.Server
+	m      sync.Mutex
+	counts map[string]int
+}
+
+func newCountingServer(handler func(http.ResponseWriter, *http.Request, int)) *countingServer {
+	cs := &countingServer{counts: map[string]int{}}
+	cs.Server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			cs.m.Lock()
+			name := req.Method + " " + req.URL.Path
+			cs.counts[name]++
+			n := cs.counts[name]
+			cs.m.Unlock()
+			if handler != nil {
+				handler(w, req, n)
+			}
+		}))
+	return cs
+}
+
+func (cs *countingServer) count(name string) int {
+	cs.m.Lock()
+	defer cs.m.Unlock()
+	return cs.counts[name]
+}
+
+// every run has its own timer which bounds sequential mode too, finally
+// contexts close journeys after it is over
+func TestPerformDuration(t *testing.T) {
+	srv := newCountingServer(nil)
+	defer srv.Close()
+
+	c := runTestScript(t, `conquest.Host("`+srv.URL+`")
+.Duration("300ms")
+.Sequential()
+.Users(2, function(users){
+users.ThinkTime("20ms");
+users.Then(function(user){ user.Do("GET", "/a"); });
+users.Finally(function(user){ user.Do("GET", "/bye"); });
+});`)
+
+	for run := 0; run < 2; run++ {
+		before := srv.count("GET /a")
+		start := time.Now()
+		r := performTest(t, c)
+		elapsed := time.Since(start)
+
+		if elapsed < 300*time.Millisecond || elapsed > 2*time.Second {
+			t.Errorf("run %d took %s, duration is 300ms", run, elapsed)
+		}
+		if r.Fails != 0 || srv.count("GET /a") == before {
+			t.Errorf("run %d: %d transactions failed of %d", run, r.Fails, r.Hits)
+		}
+		if n := srv.count("GET /bye"); n != 2*(run+1) {
+			t.Errorf("run %d: finally contexts are performed %d times", run, n)
+		}
+	}
+}
+
+// cancelling a run stops users at once, in-flight transactions are not
+// counted as hits and finally contexts are skipped
+func TestPerformCancelled(t *testing.T) {
+	srv := newCountingServer(nil)
+	defer srv.Close()
+
+	c := runTestScript(t, `conquest.Host("`+srv.URL+`")
+.Duration("1m")
+.Users(2, function(users){
+users.ThinkTime("20ms");
+users.Then(function(user){ user.Do("GET", "/a"); });
+users.Finally(function(user){ user.Do("GET", "/bye"); });
+});`)
+
+	devnull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devnull.Close()
+	r := NewReporter(devnull, false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := Perform(ctx, c, r); err != nil {
+		t.Fatal(err)
+	}
+	<-r.C.Done
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("cancelled run took %s", elapsed)
+	}
+	if !r.Interrupted {
+		t.Error("report is not interrupted")
+	}
+	if srv.count("GET /a") == 0 || srv.count("GET /bye") != 0 {
+		t.Errorf("requests = %v", srv.counts)
+	}
+}
+
+// journeys of iteration mode go through every, then and finally contexts
+// in declared order
+func TestPerformIterations(t *testing.T) {
+	srv := newCountingServer(nil)
+	defer srv.Close()
+
+	c := runTestScript(t, `conquest.Host("`+srv.URL+`")
+.Iterations(3)
+.Users(2, function(users){
+users.Every(function(user){ user.Do("GET", "/every"); });
+users.Then(function(user){ user.Do("GET", "/a"); user.Do("GET", "/b").Weight(5); });
+users.Finally(function(user){ user.Do("GET", "/finally"); });
+});`)
+	r := performTest(t, c)
+
+	if r.Fails != 0 || r.Success != 24 {
+		t.Errorf("%d of %d transactions failed: %v", r.Fails, r.Hits, r.Failed)
+	}
+	for _, name := range []string{"GET /every", "GET /a", "GET /b", "GET /finally"} {
+		if n := srv.count(name); n != 6 {
+			t.Errorf("%s is performed %d times, want 6", name, n)
+		}
+	}
+	if len(r.Distribution) != 0 {
+		t.Errorf("iteration mode has a distribution: %v", r.Distribution)
+	}
+}
+
+// users stop once the transactions of the budget are dispatched
+func TestPerformTotalTransactions(t *testing.T) {
+	srv := newCountingServer(nil)
+	defer srv.Close()
+
+	c := runTestScript(t, `conquest.Host("`+srv.URL+`")
+.Duration("1m")
+.TotalTransactions(7)
+.Users(3, function(users){
+users.Then(function(user){ user.Do("GET", "/a"); });
+});`)
+	start := time.Now()
+	r := performTest(t, c)
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("run took %s after its budget is over", elapsed)
+	}
+	if r.Success != 7 || srv.count("GET /a") != 7 {
+		t.Errorf("%d transactions succeeded, server got %d, want 7",
+			r.Success, srv.count("GET /a"))
+	}
+}
+
+// fails requests whose paths start with /fail, and /flaky the first time
+func failingHandler(w http.ResponseWriter, req *http.Request, n int) {
+	if strings.HasPrefix(req.URL.Path, "/fail") ||
+		(req.URL.Path == "/flaky" && n == 1) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func TestPerformBlocks(t *testing.T) {
+	srv := newCountingServer(failingHandler)
+	defer srv.Close()
+
+	c := runTestScript(t, `conquest.Host("`+srv.URL+`")
+.Iterations(2)
+.Users(1, function(users){
+users.Every(function(user){
+user.Do("GET", "/status");
+user.If(function(last, vars){ return last.status == 200 && last.ok; }, function(user){
+user.Do("GET", "/if-true");
+});
+user.If(function(last, vars){ return last.status == 500; }, function(user){
+user.Do("GET", "/if-false");
+});
+user.Repeat(3, function(user){ user.Do("GET", "/repeat"); });
+user.Do("GET", "/fail").Response.StatusCode(200);
+user.If(function(last, vars){ return !last.ok; }, function(user){
+user.Do("GET", "/after-fail");
+});
+});
+users.Finally(function(user){ user.Do("GET", "/finally"); });
+});`)
+	r := performTest(t, c)
+
+	if r.Fails != 2 {
+		t.Errorf("%d transactions failed: %v", r.Fails, r.Failed)
+	}
+	want := map[string]int{"GET /status": 2, "GET /if-true": 2, "GET /repeat": 6,
+		"GET /fail": 2, "GET /after-fail": 2, "GET /finally": 2}
+	if !reflect.DeepEqual(srv.counts, want) {
+		t.Errorf("requests = %v, want %v", srv.counts, want)
+	}
+}
+
+func TestPerformOnFailure(t *testing.T) {
+	tests := []struct {
+		name, action string
+		want         map[string]int
+	}{
+		{"continue", "continue", map[string]int{"GET /start": 2, "GET /flaky": 2,
+			"GET /end": 2, "GET /finally": 2}},
+		{"abort", "abort-user", map[string]int{"GET /start": 1, "GET /flaky": 1}},
+		// the failed journey counts as an iteration
+		{"restart", "restart", map[string]int{"GET /start": 2, "GET /flaky": 2,
+			"GET /end": 1, "GET /finally": 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newCountingServer(failingHandler)
+			defer srv.Close()
+
+			c := runTestScript(t, `conquest.Host("`+srv.URL+`")
+.Iterations(2)
+.Users(1, function(users){
+users.Every(function(user){
+user.Do("GET", "/start");
+user.Do("GET", "/flaky").OnFailure("`+tt.action+`").Response.StatusCode(200);
+user.Do("GET", "/end");
+});
+users.Finally(function(user){ user.Do("GET", "/finally"); });
+});`)
+			r := performTest(t, c)
+
+			if r.Fails != 1 {
+				t.Errorf("%d transactions failed: %v", r.Fails, r.Failed)
+			}
+			if !reflect.DeepEqual(srv.counts, tt.want) {
+				t.Errorf("requests = %v, want %v", srv.counts, tt.want)
+			}
+		})
+	}
+}
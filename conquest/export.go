@@ -0,0 +1,291 @@
+package conquest
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+const (
+	EXPORT_CURL    = "curl"
+	EXPORT_K6      = "k6"
+	EXPORT_POSTMAN = "postman"
+)
+
+// a value of an exported request, either a string or a *FetchNotation
+type exportPair struct {
+	Name  string
+	Value interface{}
+}
+
+// a transaction as it is sent, without the state of a user
+type exportRequest struct {
+	Verb, URL string
+	// label of the transaction which FromHeader reads the headers of
+	Label            string
+	Headers, Cookies []exportPair
+	// values which go to the query string, or a form body
+	Query, Form []exportPair
+	Multipart   bool
+	// literal body and its content type
+	Raw, RawType  string
+	Auth          *AuthNotation
+	OAuth2        bool
+	RejectCookies bool
+	// expected status code, zero for none
+	Status int64
+	// substring which the body is expected to contain
+	Contains string
+	// what conquest does but the export does not
+	Notes []string
+}
+
+// a step of an exported flow, one of its fields is set
+type exportStep struct {
+	Request *exportRequest
+	Note    string
+	// steps of a repeat block and its count
+	Times uint64
+	Steps []*exportStep
+}
+
+// a flow of exported steps which users of a group perform
+type exportFlow struct {
+	Name       string
+	Users      uint64
+	ThinkTime  float64
+	RampUp     float64
+	Iterations uint64
+	Duration   float64
+	Steps      []*exportStep
+}
+
+// the whole scenario to export
+type exportPlan struct {
+	Setup, Teardown []*exportStep
+	Flows           []*exportFlow
+	OAuth2          *OAuth2Config
+	Sign            bool
+}
+
+// returns the pairs of m sorted by their names
+func sortedPairs(m map[string]interface{}) []exportPair {
+	pairs := make([]exportPair, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, exportPair{k, v})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Name < pairs[j].Name
+	})
+	return pairs
+}
+
+// returns the initial values of kind which t sends and the ones of its
+// own, in that order. values of t override the initial ones.
+func mergedPairs(initials, own map[string]interface{}, clear bool) []exportPair {
+	merged := map[string]interface{}{}
+	if !clear {
+		for k, v := range initials {
+			merged[k] = v
+		}
+	}
+	for k, v := range own {
+		merged[k] = v
+	}
+	return sortedPairs(merged)
+}
+
+// returns t as it is sent by users
+func (c *Conquest) exportRequest(t *Transaction) (*exportRequest, error) {
+	u, err := c.resolve(t.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &exportRequest{
+		Verb:          t.Verb,
+		URL:           u.String(),
+		Label:         c.label(u),
+		Headers:       mergedPairs(c.Initials["Headers"], t.Headers, t.ReqOptions&CLEAR_HEADERS != 0),
+		Cookies:       mergedPairs(c.Initials["Cookies"], t.Cookies, t.ReqOptions&CLEAR_COOKIES != 0),
+		Auth:          t.Auth,
+		OAuth2:        t.Auth == nil && c.OAuth2 != nil && t.ReqOptions&CLEAR_HEADERS == 0,
+		RejectCookies: t.ReqOptions&REJECT_COOKIES != 0,
+	}
+	if code, ok := t.ResConditions["StatusCode"].(int64); ok {
+		r.Status = code
+	}
+	if substr, ok := t.ResConditions["Contains"].(string); ok {
+		r.Contains = substr
+	}
+
+	switch {
+	case t.GraphQL != nil:
+		b, err := t.GraphQL.body()
+		if err != nil {
+			return nil, err
+		}
+		r.Raw, r.RawType = string(b), "application/json"
+	case t.Raw != nil:
+		r.Raw, r.RawType = t.Raw.Data, t.Raw.ContentType
+	case t.Verb == "GET" || t.Verb == "HEAD" || t.Verb == "OPTIONS":
+		r.Query = sortedPairs(t.Body)
+	default:
+		r.Form = sortedPairs(t.Body)
+		r.Multipart = t.isMultiPart
+	}
+
+	if len(t.Before) > 0 || len(t.After) > 0 || len(t.Checks) > 0 {
+		r.Notes = append(r.Notes, "script hooks and checks are not exported")
+	}
+	if t.Sign != nil || c.Sign != nil {
+		r.Notes = append(r.Notes, "request signing is not exported")
+	}
+	if t.Stream != nil {
+		r.Notes = append(r.Notes, "conquest reads the response as a stream for "+
+			t.Stream.window().String())
+	}
+	return r, nil
+}
+
+// flattens transactions into exported steps
+func (c *Conquest) exportSteps(ts []*Transaction) ([]*exportStep, error) {
+	steps := []*exportStep{}
+	for _, t := range ts {
+		switch {
+		case t.Block != nil && t.Block.Type == BLOCK_REPEAT:
+			inner, err := c.exportSteps(t.Block.Transactions)
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, &exportStep{Times: t.Block.Times, Steps: inner})
+
+		case t.Block != nil:
+			inner, err := c.exportSteps(t.Block.Transactions)
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, &exportStep{
+				Note: "the condition of If is a script function, its transactions are performed unconditionally"})
+			steps = append(steps, inner...)
+
+		case t.Rendezvous != nil:
+			steps = append(steps, &exportStep{
+				Note: "rendezvous " + t.Rendezvous.Name + " of " +
+					strconv.FormatUint(t.Rendezvous.Users, 10) + " users is not exported"})
+
+		case t.WS != nil || t.GRPC != nil:
+			kind := "websocket session"
+			if t.GRPC != nil {
+				kind = "gRPC call"
+			}
+			steps = append(steps, &exportStep{
+				Note: kind + " " + t.Path + " is not exported"})
+
+		default:
+			r, err := c.exportRequest(t)
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, &exportStep{Request: r})
+		}
+	}
+	return steps, nil
+}
+
+// returns the scenario of c as exported steps. every context of a track
+// is performed once in order, random picks of then contexts included. the
+// token url of OAuth2 is resolved like the urls of transactions.
+func (c *Conquest) exportPlan() (*exportPlan, error) {
+	p := &exportPlan{Sign: c.Sign != nil}
+	if c.OAuth2 != nil {
+		u, err := c.resolve(c.OAuth2.TokenUrl)
+		if err != nil {
+			return nil, err
+		}
+		o := *c.OAuth2
+		o.TokenUrl = u.String()
+		p.OAuth2 = &o
+	}
+
+	var err error
+	if p.Setup, err = c.exportSteps(c.Setup); err != nil {
+		return nil, err
+	}
+	if p.Teardown, err = c.exportSteps(c.Teardown); err != nil {
+		return nil, err
+	}
+
+	for _, g := range c.Groups {
+		name := g.Name
+		if name == "" {
+			name = "users"
+		}
+		flow := &exportFlow{
+			Name:       name,
+			Users:      g.TotalUsers,
+			ThinkTime:  g.ThinkTime.Seconds(),
+			RampUp:     g.RampUp.Seconds(),
+			Iterations: c.Iterations,
+			Duration:   c.Duration.Seconds(),
+		}
+		for ctx := g.Track; ctx != nil; ctx = ctx.Next {
+			steps, err := c.exportSteps(ctx.Transactions)
+			if err != nil {
+				return nil, err
+			}
+			flow.Steps = append(flow.Steps, steps...)
+		}
+		p.Flows = append(p.Flows, flow)
+	}
+	return p, nil
+}
+
+// writes the scenario of c as a curl shell script, a k6 script or a
+// Postman v2.1 collection
+func Export(w io.Writer, c *Conquest, format string) error {
+	p, err := c.exportPlan()
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case EXPORT_CURL:
+		return exportCurl(w, p)
+	case EXPORT_K6:
+		return exportK6(w, p)
+	case EXPORT_POSTMAN:
+		return exportPostman(w, p)
+	}
+	return errors.New("Unknown export format: " + format)
+}
+
+// returns the value of a basic authorization header
+func basicAuthorization(user, pass string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+}
+
+// returns the full url of r with its query values, dynamic values are
+// written by value
+func (r *exportRequest) fullURL(value func(interface{}) string,
+	escape func(string) string) string {
+
+	if len(r.Query) == 0 {
+		return r.URL
+	}
+	u, _ := url.Parse(r.URL)
+	sep := "?"
+	if u != nil && u.RawQuery != "" {
+		sep = "&"
+	}
+
+	s := r.URL
+	for _, q := range r.Query {
+		s += sep + escape(q.Name) + "=" + value(q.Value)
+		sep = "&"
+	}
+	return s
+}
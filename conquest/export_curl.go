@@ -0,0 +1,242 @@
+package conquest
+
+import (
+	"bufio"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// helpers of exported curl scripts
+const curlPrelude = `set -u
+
+JAR="$(mktemp)"
+HEADERS="$(mktemp -d)"
+trap 'rm -rf "$JAR" "$HEADERS"' EXIT
+
+# request STATUS CONTAINS CURL_ARGS...
+# performs a request with the cookie jar, reports unexpected responses
+request() {
+  want="$1"; contains="$2"; shift 2
+  body="$(mktemp)"
+  got="$(curl -sS -o "$body" -w '%{http_code}' "$@")"
+  if [ -n "$want" ] && [ "$got" != "$want" ]; then
+    echo "expected status $want, got $got: $*" >&2
+  fi
+  if [ -n "$contains" ] && ! grep -qF -- "$contains" "$body"; then
+    echo "response does not contain $contains: $*" >&2
+  fi
+  rm -f "$body"
+}
+
+# cookie NAME: value of a cookie in the jar
+cookie() {
+  awk -v name="$1" '$6 == name { value = $7 } END { print value }' "$JAR"
+}
+
+# header FILE NAME: cached header of the last response of a transaction
+header() {
+  grep -i "^$2:" "$HEADERS/$1" 2>/dev/null | tail -n 1 | cut -d' ' -f2- | tr -d '\r'
+}
+`
+
+// returns s in single quotes for sh
+func shQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// returns the name of the file which headers of label are written to
+func headerFile(label string) string {
+	name := strings.Trim(unsafeFileChars.ReplaceAllString(label, "_"), "_")
+	if name == "" {
+		return "root"
+	}
+	return name
+}
+
+// returns an sh word which gives v, label is the transaction of
+// FromHeader values
+func curlValue(v interface{}, label string) string {
+	f, ok := v.(*FetchNotation)
+	if !ok {
+		s, _ := v.(string)
+		return shQuote(s)
+	}
+
+	switch f.Type {
+	case FETCH_COOKIE:
+		return `"$(cookie ` + shQuote(f.Args[0]) + `)"`
+	case FETCH_HEADER:
+		return `"$(header ` + shQuote(headerFile(label)) + " " +
+			shQuote(f.Args[0]) + `)"`
+	case FETCH_DISK:
+		return `"$(cat ` + shQuote(f.Args[0]) + `)"`
+	case FETCH_SHARED:
+		return `"${` + shellName(f.Args[0]) + `:-}"`
+	}
+	return "''"
+}
+
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+// returns name as a shell variable name of a shared value
+func shellName(name string) string {
+	return "SHARED_" + unsafeNameChars.ReplaceAllString(name, "_")
+}
+
+// returns an sh word which gives prefix followed by v
+func curlWord(prefix string, v interface{}, label string) string {
+	if s, ok := v.(string); ok {
+		return shQuote(prefix + s)
+	}
+	return shQuote(prefix) + curlValue(v, label)
+}
+
+// writes the request command of r, an option and its value per line
+func writeCurlRequest(w *bufio.Writer, indent string, r *exportRequest) {
+	for _, note := range r.Notes {
+		w.WriteString(indent + "# " + note + "\n")
+	}
+
+	status := "''"
+	if r.Status != 0 {
+		status = strconv.FormatInt(r.Status, 10)
+	}
+	// -X HEAD would wait for a body which never comes
+	method := "-X " + r.Verb
+	if r.Verb == "HEAD" {
+		method = "-I"
+	}
+	lines := []string{"request " + status + " " + shQuote(r.Contains) + " " + method}
+	if r.RejectCookies {
+		lines = append(lines, `-b "$JAR"`)
+	} else {
+		lines = append(lines, `-b "$JAR" -c "$JAR"`)
+	}
+	lines = append(lines, `-D "$HEADERS/`+headerFile(r.Label)+`"`)
+
+	for _, h := range r.Headers {
+		lines = append(lines, "-H "+curlWord(h.Name+": ", h.Value, r.Label))
+	}
+	if len(r.Cookies) > 0 {
+		cookies := []string{}
+		for _, c := range r.Cookies {
+			cookies = append(cookies, curlWord(c.Name+"=", c.Value, r.Label))
+		}
+		lines = append(lines, "-b "+strings.Join(cookies, "'; '"))
+	}
+
+	if r.Auth != nil {
+		switch r.Auth.Type {
+		case AUTH_BASIC:
+			lines = append(lines, "-u "+shQuote(r.Auth.Args[0]+":"+r.Auth.Args[1]))
+		case AUTH_DIGEST:
+			lines = append(lines, "--digest -u "+shQuote(r.Auth.Args[0]+":"+r.Auth.Args[1]))
+		case AUTH_BEARER:
+			var token interface{} = r.Auth.Args[0]
+			if r.Auth.Fetch != nil {
+				token = r.Auth.Fetch
+			}
+			lines = append(lines, "-H "+curlWord("Authorization: Bearer ", token, r.Label))
+		}
+	}
+	if r.OAuth2 {
+		lines = append(lines, `-H "Authorization: Bearer $TOKEN"`)
+	}
+
+	if len(r.Query) > 0 {
+		lines = append(lines, "-G")
+	}
+	for _, q := range r.Query {
+		lines = append(lines, "--data-urlencode "+curlWord(q.Name+"=", q.Value, r.Label))
+	}
+	for _, f := range r.Form {
+		if n, ok := f.Value.(*FetchNotation); ok && n.Type == FETCH_DISK && r.Multipart {
+			file := "@" + n.Args[0] + ";filename=" + filepath.Base(n.Args[0])
+			if len(n.Args) > 1 {
+				file += ";type=" + n.Args[1]
+			}
+			lines = append(lines, "-F "+shQuote(f.Name+"="+file))
+			continue
+		}
+		if r.Multipart {
+			lines = append(lines, "--form-string "+curlWord(f.Name+"=", f.Value, r.Label))
+			continue
+		}
+		lines = append(lines, "--data-urlencode "+curlWord(f.Name+"=", f.Value, r.Label))
+	}
+	if r.Raw != "" || r.RawType != "" {
+		if r.RawType != "" {
+			lines = append(lines, "-H "+shQuote("Content-Type: "+r.RawType))
+		}
+		lines = append(lines, "--data-binary "+shQuote(r.Raw))
+	}
+	lines = append(lines, shQuote(r.URL))
+
+	w.WriteString(indent + strings.Join(lines, " \\\n"+indent+"  ") + "\n")
+}
+
+func writeCurlSteps(w *bufio.Writer, indent string, steps []*exportStep,
+	thinkTime float64) {
+
+	for _, s := range steps {
+		switch {
+		case s.Request != nil:
+			writeCurlRequest(w, indent, s.Request)
+			if thinkTime > 0 {
+				w.WriteString(indent + "sleep " +
+					strconv.FormatFloat(thinkTime, 'f', -1, 64) + "\n")
+			}
+		case s.Steps != nil:
+			w.WriteString(indent + "for i in $(seq " +
+				strconv.FormatUint(s.Times, 10) + "); do\n")
+			writeCurlSteps(w, indent+"  ", s.Steps, thinkTime)
+			w.WriteString(indent + "done\n")
+		default:
+			w.WriteString(indent + "# " + s.Note + "\n")
+		}
+	}
+}
+
+// writes p as a shell script which performs every flow once with curl
+func exportCurl(out io.Writer, p *exportPlan) error {
+	w := bufio.NewWriter(out)
+	w.WriteString("#!/bin/sh\n")
+	w.WriteString("# exported from conquest.js, every flow is performed once by one user.\n")
+	w.WriteString("# shared values are read from SHARED_<name> environment variables.\n")
+	w.WriteString(curlPrelude)
+
+	if p.Sign {
+		w.WriteString("\n# requests are signed by conquest, signing is not exported\n")
+	}
+	if o := p.OAuth2; o != nil {
+		w.WriteString("\n# client credentials token of requests\n")
+		args := []string{"TOKEN=\"$(curl -sS", "-u", shQuote(o.ClientId + ":" + o.ClientSecret),
+			"-d", "grant_type=client_credentials"}
+		if len(o.Scopes) > 0 {
+			args = append(args, "--data-urlencode", shQuote("scope="+strings.Join(o.Scopes, " ")))
+		}
+		args = append(args, shQuote(o.TokenUrl), "|",
+			`sed -n 's/.*"access_token" *: *"\([^"]*\)".*/\1/p')"`)
+		w.WriteString(strings.Join(args, " ") + "\n")
+	}
+
+	if len(p.Setup) > 0 {
+		w.WriteString("\n# setup\n")
+		writeCurlSteps(w, "", p.Setup, 0)
+	}
+	for _, f := range p.Flows {
+		w.WriteString("\n# users of " + f.Name + ", " +
+			strconv.FormatUint(f.Users, 10) + " in conquest\n")
+		writeCurlSteps(w, "", f.Steps, f.ThinkTime)
+	}
+	if len(p.Teardown) > 0 {
+		w.WriteString("\n# teardown\n")
+		writeCurlSteps(w, "", p.Teardown, 0)
+	}
+	return w.Flush()
+}
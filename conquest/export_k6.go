@@ -0,0 +1,296 @@
+package conquest
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// helpers of exported k6 scripts
+const k6Prelude = `
+// cached headers of the last responses by transactions
+const cached = {};
+
+function cookie(url, name) {
+  const values = http.cookieJar().cookiesForURL(url)[name];
+  return values && values.length > 0 ? values[values.length - 1] : "";
+}
+
+function header(label, name) {
+  const headers = cached[label] || {};
+  return headers[name] || "";
+}
+
+function query(url, values) {
+  const parts = Object.keys(values).map(
+    (k) => encodeURIComponent(k) + "=" + encodeURIComponent(values[k]));
+  if (parts.length === 0) {
+    return url;
+  }
+  return url + (url.indexOf("?") < 0 ? "?" : "&") + parts.join("&");
+}
+
+function expect(res, name, status, contains) {
+  const checks = {};
+  if (status) {
+    checks[name + " status is " + status] = (r) => r.status === status;
+  }
+  if (contains) {
+    checks[name + " contains " + contains] = (r) => String(r.body).indexOf(contains) >= 0;
+  }
+  check(res, checks);
+}
+`
+
+// returns s as a javascript string literal
+func jsString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+var unsafeIdentChars = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+// a k6 script which is being written
+type k6Script struct {
+	w *bufio.Writer
+	// files which FromDisk reads, they are opened in the init context
+	files map[string]string
+}
+
+// returns the variable of file path
+func (k *k6Script) file(path string) string {
+	if v, ok := k.files[path]; ok {
+		return v
+	}
+	v := "file" + strconv.Itoa(len(k.files))
+	k.files[path] = v
+	return v
+}
+
+// returns a javascript expression which gives v of request r
+func (k *k6Script) value(v interface{}, r *exportRequest) string {
+	f, ok := v.(*FetchNotation)
+	if !ok {
+		s, _ := v.(string)
+		return jsString(s)
+	}
+
+	switch f.Type {
+	case FETCH_COOKIE:
+		return "cookie(" + jsString(r.URL) + ", " + jsString(f.Args[0]) + ")"
+	case FETCH_HEADER:
+		return "header(" + jsString(r.Label) + ", " + jsString(f.Args[0]) + ")"
+	case FETCH_DISK:
+		file := k.file(f.Args[0])
+		if r.Multipart {
+			args := []string{file, jsString(filepath.Base(f.Args[0]))}
+			if len(f.Args) > 1 {
+				args = append(args, jsString(f.Args[1]))
+			}
+			return "http.file(" + strings.Join(args, ", ") + ")"
+		}
+		return "String.fromCharCode.apply(null, new Uint8Array(" + file + "))"
+	case FETCH_SHARED:
+		return "(__ENV[" + jsString(f.Args[0]) + "] || \"\")"
+	}
+	return `""`
+}
+
+// returns pairs as an object literal
+func (k *k6Script) object(pairs []exportPair, r *exportRequest) string {
+	fields := []string{}
+	for _, p := range pairs {
+		fields = append(fields, jsString(p.Name)+": "+k.value(p.Value, r))
+	}
+	return "{" + strings.Join(fields, ", ") + "}"
+}
+
+func (k *k6Script) request(indent string, r *exportRequest) {
+	w := k.w
+	for _, note := range r.Notes {
+		w.WriteString(indent + "// " + note + "\n")
+	}
+
+	target := jsString(r.URL)
+	if len(r.Query) > 0 {
+		target = "query(" + target + ", " + k.object(r.Query, r) + ")"
+	}
+
+	params := []string{}
+	headers := []string{}
+	for _, h := range r.Headers {
+		headers = append(headers, jsString(h.Name)+": "+k.value(h.Value, r))
+	}
+	if r.Auth != nil {
+		switch r.Auth.Type {
+		case AUTH_BASIC:
+			headers = append(headers, `"Authorization": `+
+				jsString(basicAuthorization(r.Auth.Args[0], r.Auth.Args[1])))
+		case AUTH_DIGEST:
+			params = append(params, `auth: "digest"`)
+			userinfo := url.UserPassword(r.Auth.Args[0], r.Auth.Args[1]).String()
+			target = strings.Replace(target, "://", "://"+userinfo+"@", 1)
+		case AUTH_BEARER:
+			token := jsString(r.Auth.Args[0])
+			if r.Auth.Fetch != nil {
+				token = k.value(r.Auth.Fetch, r)
+			}
+			headers = append(headers, `"Authorization": "Bearer " + `+token)
+		}
+	}
+	if r.OAuth2 {
+		headers = append(headers, `"Authorization": "Bearer " + oauth2()`)
+	}
+
+	body := "null"
+	switch {
+	case r.Raw != "" || r.RawType != "":
+		body = jsString(r.Raw)
+		if r.RawType != "" {
+			headers = append(headers, `"Content-Type": `+jsString(r.RawType))
+		}
+	case len(r.Form) > 0:
+		body = k.object(r.Form, r)
+	}
+
+	if len(headers) > 0 {
+		params = append(params, "headers: {"+strings.Join(headers, ", ")+"}")
+	}
+	if len(r.Cookies) > 0 {
+		params = append(params, "cookies: "+k.object(r.Cookies, r))
+	}
+	if r.RejectCookies {
+		params = append(params, "jar: new http.CookieJar()")
+	}
+
+	w.WriteString(indent + "res = http.request(" + jsString(r.Verb) + ", " +
+		target + ", " + body + ", {" + strings.Join(params, ", ") + "});\n")
+	w.WriteString(indent + "cached[" + jsString(r.Label) + "] = res.headers;\n")
+	w.WriteString(indent + "expect(res, " + jsString(r.Verb+" "+r.Label) + ", " +
+		strconv.FormatInt(r.Status, 10) + ", " + jsString(r.Contains) + ");\n")
+}
+
+func (k *k6Script) steps(indent string, steps []*exportStep,
+	thinkTime float64) {
+
+	for _, s := range steps {
+		switch {
+		case s.Request != nil:
+			k.request(indent, s.Request)
+			if thinkTime > 0 {
+				k.w.WriteString(indent + "sleep(" +
+					strconv.FormatFloat(thinkTime, 'f', -1, 64) + ");\n")
+			}
+		case s.Steps != nil:
+			k.w.WriteString(indent + "for (let i = 0; i < " +
+				strconv.FormatUint(s.Times, 10) + "; i++) {\n")
+			k.steps(indent+"  ", s.Steps, thinkTime)
+			k.w.WriteString(indent + "}\n")
+		default:
+			k.w.WriteString(indent + "// " + s.Note + "\n")
+		}
+	}
+}
+
+// returns the name of the exec function of flow f
+func k6Exec(f *exportFlow) string {
+	return "flow_" + strings.Trim(unsafeIdentChars.ReplaceAllString(f.Name, "_"), "_")
+}
+
+// returns the scenario options of flow f
+func k6Scenario(f *exportFlow) string {
+	users := strconv.FormatUint(f.Users, 10)
+	duration := strconv.FormatFloat(f.Duration, 'f', -1, 64) + "s"
+	exec := "exec: " + jsString(k6Exec(f))
+
+	switch {
+	case f.Iterations > 0:
+		return "{executor: \"per-vu-iterations\", vus: " + users +
+			", iterations: " + strconv.FormatUint(f.Iterations, 10) + ", " + exec + "}"
+	case f.RampUp > 0:
+		rampUp := strconv.FormatFloat(f.RampUp, 'f', -1, 64) + "s"
+		rest := f.Duration - f.RampUp
+		if rest < 0 {
+			rest = 0
+		}
+		return "{executor: \"ramping-vus\", startVUs: 0, stages: [" +
+			"{duration: " + jsString(rampUp) + ", target: " + users + "}, " +
+			"{duration: " + jsString(strconv.FormatFloat(rest, 'f', -1, 64)+"s") +
+			", target: " + users + "}], " + exec + "}"
+	}
+	return "{executor: \"constant-vus\", vus: " + users + ", duration: " +
+		jsString(duration) + ", " + exec + "}"
+}
+
+// writes p as a k6 script with a scenario for every flow
+func exportK6(out io.Writer, p *exportPlan) error {
+	// flows are written first, the files which they read are opened above
+	body := &strings.Builder{}
+	k := &k6Script{w: bufio.NewWriter(body), files: map[string]string{}}
+	if len(p.Setup) > 0 {
+		k.w.WriteString("\nexport function setup() {\n  let res;\n")
+		k.steps("  ", p.Setup, 0)
+		k.w.WriteString("}\n")
+	}
+	for _, f := range p.Flows {
+		k.w.WriteString("\nexport function " + k6Exec(f) + "() {\n  let res;\n")
+		k.steps("  ", f.Steps, f.ThinkTime)
+		k.w.WriteString("}\n")
+	}
+	if len(p.Teardown) > 0 {
+		k.w.WriteString("\nexport function teardown() {\n  let res;\n")
+		k.steps("  ", p.Teardown, 0)
+		k.w.WriteString("}\n")
+	}
+	k.w.Flush()
+
+	w := bufio.NewWriter(out)
+	w.WriteString("// exported from conquest.js\n")
+	w.WriteString("// shared values are read from environment variables.\n")
+	w.WriteString("import http from \"k6/http\";\n")
+	w.WriteString("import { check, sleep } from \"k6\";\n")
+
+	w.WriteString("\nexport const options = {\n  scenarios: {\n")
+	for _, f := range p.Flows {
+		w.WriteString("    " + jsString(f.Name) + ": " + k6Scenario(f) + ",\n")
+	}
+	w.WriteString("  },\n};\n")
+
+	paths := make([]string, 0, len(k.files))
+	for path := range k.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	if len(paths) > 0 {
+		w.WriteString("\n")
+	}
+	for _, path := range paths {
+		w.WriteString("const " + k.files[path] + " = open(" + jsString(path) + ", \"b\");\n")
+	}
+
+	w.WriteString(k6Prelude)
+	if p.Sign {
+		w.WriteString("\n// requests are signed by conquest, signing is not exported\n")
+	}
+	if o := p.OAuth2; o != nil {
+		form := []string{`grant_type: "client_credentials"`}
+		if len(o.Scopes) > 0 {
+			form = append(form, "scope: "+jsString(strings.Join(o.Scopes, " ")))
+		}
+		w.WriteString("\n// client credentials token of a user\nlet token = \"\";\n")
+		w.WriteString("function oauth2() {\n  if (token === \"\") {\n")
+		w.WriteString("    const res = http.post(" + jsString(o.TokenUrl) + ", {" +
+			strings.Join(form, ", ") + "}, {headers: {\"Authorization\": " +
+			jsString(basicAuthorization(o.ClientId, o.ClientSecret)) + "}});\n")
+		w.WriteString("    token = res.json(\"access_token\");\n  }\n  return token;\n}\n")
+	}
+
+	w.WriteString(body.String())
+	return w.Flush()
+}
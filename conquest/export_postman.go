@@ -0,0 +1,247 @@
+package conquest
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const postmanSchema = "https://schema.getpostman.com/json/collection/v2.1.0/collection.json"
+
+// copies cookies and headers of every response into variables which
+// fetched values are read from
+var postmanCollectionTest = []string{
+	`pm.cookies.each(function (c) { pm.collectionVariables.set("cookie_" + c.name, c.value); });`,
+	`pm.response.headers.each(function (h) { pm.collectionVariables.set("header_" + h.key, h.value); });`,
+}
+
+type postmanKV struct {
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+	Type  string `json:"type,omitempty"`
+	Src   string `json:"src,omitempty"`
+}
+
+type postmanEvent struct {
+	Listen string `json:"listen"`
+	Script struct {
+		Type string   `json:"type"`
+		Exec []string `json:"exec"`
+	} `json:"script"`
+}
+
+func newPostmanEvent(listen string, exec []string) *postmanEvent {
+	e := &postmanEvent{Listen: listen}
+	e.Script.Type = "text/javascript"
+	e.Script.Exec = exec
+	return e
+}
+
+type postmanBody struct {
+	Mode       string      `json:"mode"`
+	Raw        string      `json:"raw,omitempty"`
+	URLEncoded []postmanKV `json:"urlencoded,omitempty"`
+	FormData   []postmanKV `json:"formdata,omitempty"`
+}
+
+type postmanRequest struct {
+	Method string                 `json:"method"`
+	Header []postmanKV            `json:"header"`
+	URL    string                 `json:"url"`
+	Body   *postmanBody           `json:"body,omitempty"`
+	Auth   map[string]interface{} `json:"auth,omitempty"`
+}
+
+type postmanItem struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Item        []*postmanItem  `json:"item,omitempty"`
+	Request     *postmanRequest `json:"request,omitempty"`
+	Event       []*postmanEvent `json:"event,omitempty"`
+}
+
+// returns the postman form of v, fetched values are read from variables
+func postmanValue(v interface{}) string {
+	f, ok := v.(*FetchNotation)
+	if !ok {
+		s, _ := v.(string)
+		return s
+	}
+
+	switch f.Type {
+	case FETCH_COOKIE:
+		return "{{cookie_" + f.Args[0] + "}}"
+	case FETCH_HEADER:
+		return "{{header_" + f.Args[0] + "}}"
+	case FETCH_SHARED:
+		return "{{" + f.Args[0] + "}}"
+	}
+	return ""
+}
+
+func postmanAuth(kind string, params ...string) map[string]interface{} {
+	kvs := []postmanKV{}
+	for i := 0; i+1 < len(params); i += 2 {
+		kvs = append(kvs, postmanKV{Key: params[i], Value: params[i+1], Type: "string"})
+	}
+	return map[string]interface{}{"type": kind, kind: kvs}
+}
+
+func postmanRequestItem(r *exportRequest) *postmanItem {
+	req := &postmanRequest{
+		Method: r.Verb,
+		Header: []postmanKV{},
+		URL: r.fullURL(func(v interface{}) string {
+			if s, ok := v.(string); ok {
+				return url.QueryEscape(s)
+			}
+			return postmanValue(v)
+		}, url.QueryEscape),
+	}
+
+	for _, h := range r.Headers {
+		req.Header = append(req.Header, postmanKV{Key: h.Name, Value: postmanValue(h.Value)})
+	}
+	if len(r.Cookies) > 0 {
+		cookies := []string{}
+		for _, c := range r.Cookies {
+			cookies = append(cookies, c.Name+"="+postmanValue(c.Value))
+		}
+		req.Header = append(req.Header,
+			postmanKV{Key: "Cookie", Value: strings.Join(cookies, "; ")})
+	}
+
+	if r.Auth != nil {
+		switch r.Auth.Type {
+		case AUTH_BASIC:
+			req.Auth = postmanAuth("basic", "username", r.Auth.Args[0],
+				"password", r.Auth.Args[1])
+		case AUTH_DIGEST:
+			req.Auth = postmanAuth("digest", "username", r.Auth.Args[0],
+				"password", r.Auth.Args[1])
+		case AUTH_BEARER:
+			token := r.Auth.Args[0]
+			if r.Auth.Fetch != nil {
+				token = postmanValue(r.Auth.Fetch)
+			}
+			req.Auth = postmanAuth("bearer", "token", token)
+		}
+	} else if !r.OAuth2 {
+		req.Auth = map[string]interface{}{"type": "noauth"}
+	}
+
+	switch {
+	case r.Raw != "" || r.RawType != "":
+		req.Body = &postmanBody{Mode: "raw", Raw: r.Raw}
+		if r.RawType != "" {
+			req.Header = append(req.Header,
+				postmanKV{Key: "Content-Type", Value: r.RawType})
+		}
+	case r.Multipart:
+		req.Body = &postmanBody{Mode: "formdata", FormData: []postmanKV{}}
+		for _, f := range r.Form {
+			if n, ok := f.Value.(*FetchNotation); ok && n.Type == FETCH_DISK {
+				req.Body.FormData = append(req.Body.FormData,
+					postmanKV{Key: f.Name, Type: "file", Src: n.Args[0]})
+				continue
+			}
+			req.Body.FormData = append(req.Body.FormData,
+				postmanKV{Key: f.Name, Value: postmanValue(f.Value), Type: "text"})
+		}
+	case len(r.Form) > 0:
+		req.Body = &postmanBody{Mode: "urlencoded", URLEncoded: []postmanKV{}}
+		for _, f := range r.Form {
+			req.Body.URLEncoded = append(req.Body.URLEncoded,
+				postmanKV{Key: f.Name, Value: postmanValue(f.Value)})
+		}
+	}
+
+	item := &postmanItem{
+		Name:        r.Verb + " " + r.Label,
+		Description: strings.Join(r.Notes, "\n"),
+		Request:     req,
+	}
+	tests := []string{}
+	if r.Status != 0 {
+		tests = append(tests, `pm.test("status is `+strconv.FormatInt(r.Status, 10)+
+			`", function () { pm.response.to.have.status(`+
+			strconv.FormatInt(r.Status, 10)+`); });`)
+	}
+	if r.Contains != "" {
+		tests = append(tests, `pm.test("body contains", function () { pm.expect(pm.response.text()).to.include(`+
+			jsString(r.Contains)+`); });`)
+	}
+	if len(tests) > 0 {
+		item.Event = []*postmanEvent{newPostmanEvent("test", tests)}
+	}
+	return item
+}
+
+// returns steps as items, repeat blocks are unrolled since collections
+// have no loops
+func postmanItems(steps []*exportStep) []*postmanItem {
+	items := []*postmanItem{}
+	for _, s := range steps {
+		switch {
+		case s.Request != nil:
+			items = append(items, postmanRequestItem(s.Request))
+		case s.Steps != nil:
+			for i := uint64(0); i < s.Times; i++ {
+				items = append(items, postmanItems(s.Steps)...)
+			}
+		}
+	}
+	return items
+}
+
+// writes p as a Postman v2.1 collection with a folder for every flow
+func exportPostman(out io.Writer, p *exportPlan) error {
+	collection := struct {
+		Info struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+			Schema      string `json:"schema"`
+		} `json:"info"`
+		Item  []*postmanItem         `json:"item"`
+		Event []*postmanEvent        `json:"event"`
+		Auth  map[string]interface{} `json:"auth,omitempty"`
+	}{
+		Item:  []*postmanItem{},
+		Event: []*postmanEvent{newPostmanEvent("test", postmanCollectionTest)},
+	}
+	collection.Info.Name = "conquest"
+	collection.Info.Description = "exported from conquest.js, shared values are read from variables."
+	collection.Info.Schema = postmanSchema
+
+	if o := p.OAuth2; o != nil {
+		collection.Auth = postmanAuth("oauth2", "grant_type", "client_credentials",
+			"accessTokenUrl", o.TokenUrl, "clientId", o.ClientId,
+			"clientSecret", o.ClientSecret, "scope", strings.Join(o.Scopes, " "),
+			"client_authentication", "header")
+	}
+	if p.Sign {
+		collection.Info.Description += " request signing is not exported."
+	}
+
+	folder := func(name string, steps []*exportStep) {
+		if items := postmanItems(steps); len(items) > 0 {
+			collection.Item = append(collection.Item,
+				&postmanItem{Name: name, Item: items})
+		}
+	}
+	folder("setup", p.Setup)
+	for _, f := range p.Flows {
+		folder(f.Name, f.Steps)
+	}
+	folder("teardown", p.Teardown)
+
+	b, err := json.MarshalIndent(collection, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	_, err = out.Write(b)
+	return err
+}
@@ -0,0 +1,55 @@
+package conquest
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files of exports")
+
+// exports of testdata/export.js are compared with golden files, which
+// go test -run TestExport -update rewrites
+func TestExport(t *testing.T) {
+	c, err := RunScript(filepath.Join("testdata", "export.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for format, golden := range map[string]string{
+		EXPORT_CURL:    "export.sh",
+		EXPORT_K6:      "export.k6.js",
+		EXPORT_POSTMAN: "export.postman.json",
+	} {
+		out := &bytes.Buffer{}
+		if err := Export(out, c, format); err != nil {
+			t.Errorf("%s: %s", format, err)
+			continue
+		}
+
+		path := filepath.Join("testdata", golden)
+		if *update {
+			if err := ioutil.WriteFile(path, out.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), want) {
+			t.Errorf("%s export differs from %s:\n%s", format, path, out)
+		}
+	}
+}
+
+func TestExportUnknownFormat(t *testing.T) {
+	c := runTestScript(t, `conquest.Host("http://api.local")`)
+	if err := Export(&bytes.Buffer{}, c, "jmeter"); err == nil ||
+		err.Error() != "Unknown export format: jmeter" {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"errors"
 	"io/ioutil"
 	"os"
+	"sync"
 )
 
 var (
-	fcache = map[string][]byte{}
+	fcache  = map[string][]byte{}
+	fcacheM = &sync.Mutex{}
 )
 
 func fromCookie(args []string, p string, u *mUser) ([]byte, error) {
@@ -33,10 +35,21 @@ func fromHeader(args []string, p string, u *mUser) ([]byte, error) {
 	return nil, errors.New("No " + key + " cached header for " + p)
 }
 
+func fromShared(args []string, p string, u *mUser) ([]byte, error) {
+	key := args[0]
+	if val, ok := u.shared[key]; ok {
+		return []byte(val), nil
+	}
+	return nil, errors.New("Non-exists shared value: " + key)
+}
+
 /* FIXME: file caching */
 func fromDisk(args []string, p string, u *mUser) ([]byte, error) {
 	fpath := args[0]
 
+	fcacheM.Lock()
+	defer fcacheM.Unlock()
+
 	if data, ok := fcache[fpath]; ok {
 		return data, nil
 	}
@@ -67,6 +80,8 @@ func FetchFrom(f *FetchNotation, path string, u *mUser) (b []byte, e error) {
 		b, e = fromHeader(f.Args, path, u)
 	case FETCH_DISK:
 		b, e = fromDisk(f.Args, path, u)
+	case FETCH_SHARED:
+		b, e = fromShared(f.Args, path, u)
 	}
 	return
 }
@@ -80,6 +95,8 @@ func CorrectFetch(s uint8, f *FetchNotation) (string, bool) {
 		strKind = "Header"
 	case FETCH_DISK:
 		strKind = "Disk"
+	case FETCH_SHARED:
+		strKind = "Shared"
 	}
 	return strKind, s&f.Type != 0
 }
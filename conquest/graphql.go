@@ -0,0 +1,102 @@
+package conquest
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// a graphql operation which is sent as a json POST
+type GraphQLNotation struct {
+	Query         string
+	Variables     map[string]interface{}
+	OperationName string
+}
+
+// first named operation of a query document
+var graphQLOperation = regexp.MustCompile(
+	`^\s*(?:query|mutation|subscription)\s+([_A-Za-z][_0-9A-Za-z]*)`)
+
+// returns the operation name of g, which is set or named in its query
+func (g *GraphQLNotation) operation() string {
+	if g.OperationName != "" {
+		return g.OperationName
+	}
+	if m := graphQLOperation.FindStringSubmatch(g.Query); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// returns the name of g in reports, operations of an endpoint are counted
+// separately
+func (g *GraphQLNotation) label(p string) string {
+	if op := g.operation(); op != "" {
+		return p + " " + op
+	}
+	return p
+}
+
+func (g *GraphQLNotation) body() ([]byte, error) {
+	req := map[string]interface{}{
+		"query": g.Query,
+	}
+	if len(g.Variables) > 0 {
+		req["variables"] = g.Variables
+	}
+	if g.OperationName != "" {
+		req["operationName"] = g.OperationName
+	}
+	return json.Marshal(req)
+}
+
+type graphQLResult struct {
+	Data   interface{} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// parses a graphql response. servers answer failed operations with 200
+// too, so a non-empty errors array is returned as an error.
+func parseGraphQL(body []byte) (*graphQLResult, error) {
+	result := &graphQLResult{}
+	if err := json.Unmarshal(body, result); err != nil {
+		return nil, errors.New("Response is not a GraphQL result: " + err.Error())
+	}
+
+	if n := len(result.Errors); n > 0 {
+		msg := "GraphQL returned " + strconv.Itoa(n) + " errors: " +
+			result.Errors[0].Message
+		if n == 1 {
+			msg = "GraphQL returned an error: " + result.Errors[0].Message
+		}
+		return result, errors.New(msg)
+	}
+	return result, nil
+}
+
+// returns the value at a dotted path like "user.friends.0.name" in v
+func lookupPath(v interface{}, p string) (interface{}, bool) {
+	for _, key := range strings.Split(p, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			val, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			v = val
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
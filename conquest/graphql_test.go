@@ -0,0 +1,134 @@
+package conquest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// operations are posted as json, results with errors fail and data is
+// checked by Response.Data and seen by hooks
+func TestPerformGraphQL(t *testing.T) {
+	var m sync.Mutex
+	got := map[string]map[string]interface{}{}
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			if req.Method == "GET" {
+				m.Lock()
+				got["GET "+req.URL.Path] = map[string]interface{}{
+					"friend": req.Header.Get("X-Friend")}
+				m.Unlock()
+				return
+			}
+			op := map[string]interface{}{}
+			if req.Header.Get("Content-Type") != "application/json" ||
+				json.NewDecoder(req.Body).Decode(&op) != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			name, _ := op["operationName"].(string)
+			m.Lock()
+			got[req.Method+" "+name] = op
+			m.Unlock()
+
+			if name == "Broken" {
+				w.Write([]byte(`{"data": null, "errors": [{"message": "boom"}]}`))
+				return
+			}
+			w.Write([]byte(`{"data": {"user": {"name": "Ana", "age": 30,
+				"friends": [{"name": "Bo"}]}}}`))
+		}))
+	defer srv.Close()
+
+	c := runTestScript(t, `conquest.Host("`+srv.URL+`")
+.Iterations(1)
+.Users(1, function(users){
+users.Every(function(user){
+user.GraphQL("/graphql", "query User($id: ID!) { user(id: $id) { name } }",
+{"id": "1"}, "User")
+.After(function(res, vars){ vars.friend = res.data.user.friends[0].name; })
+.Response.Data("user.name", "Ana").Data("user.age", "30")
+.Check("has friends", function(res){ return res.data.user.friends.length == 1; });
+user.GraphQL("/graphql", "query Other { user { name } }", {}, "Other")
+.Response.Data("user.name", "Bob");
+user.GraphQL("/graphql", "query Missing { user { name } }", {}, "Missing")
+.Response.Data("user.email", "ana@example.com");
+user.GraphQL("/graphql", "query Broken { user { name } }", {}, "Broken");
+user.GraphQL("/graphql", "{ user { name } }");
+user.Do("GET", "/friend").Before(function(req, vars){
+req.headers["X-Friend"] = vars.friend;
+});
+});
+});`)
+	r := performTest(t, c)
+
+	if r.Success != 3 || r.Fails != 3 {
+		t.Errorf("%d succeeded, %d failed: %v", r.Success, r.Fails, r.Failed)
+	}
+	for label, want := range map[string]string{
+		"/graphql Other":   "Expected data at user.name is Bob but it returned as Ana.",
+		"/graphql Missing": "No data at user.email",
+		"/graphql Broken":  "GraphQL returned an error: boom",
+	} {
+		fails := r.Failed[label]
+		if len(fails) != 1 || fails[0].Error.Error() != want {
+			t.Errorf("failures of %s = %v", label, fails)
+		}
+	}
+	if stat := r.Checks["has friends"]; stat == nil || stat.Pass != 1 {
+		t.Errorf("check stats = %v", r.Checks)
+	}
+
+	want := map[string]interface{}{
+		"query":         "query User($id: ID!) { user(id: $id) { name } }",
+		"variables":     map[string]interface{}{"id": "1"},
+		"operationName": "User",
+	}
+	if !reflect.DeepEqual(got["POST User"], want) {
+		t.Errorf("operation = %v, want %v", got["POST User"], want)
+	}
+	if op := got["POST "]; op == nil || len(op) != 1 {
+		t.Errorf("anonymous operation = %v", op)
+	}
+	if f := got["GET /friend"]["friend"]; f != "Bo" {
+		t.Errorf("captured friend = %v", f)
+	}
+}
+
+func TestGraphQLLabel(t *testing.T) {
+	for _, c := range []struct {
+		g    GraphQLNotation
+		want string
+	}{
+		{GraphQLNotation{Query: "{ user { name } }"}, "/graphql"},
+		{GraphQLNotation{Query: " query User { user { name } }"}, "/graphql User"},
+		{GraphQLNotation{Query: "mutation Rename($n: String) { x }"}, "/graphql Rename"},
+		{GraphQLNotation{Query: "query A { a } query B { b }",
+			OperationName: "B"}, "/graphql B"},
+	} {
+		if got := c.g.label("/graphql"); got != c.want {
+			t.Errorf("label of %q = %q, want %q", c.g.Query, got, c.want)
+		}
+	}
+}
+
+func TestLookupPath(t *testing.T) {
+	var data interface{}
+	json.Unmarshal([]byte(`{"user": {"friends": [{"name": "Bo"}], "age": 30}}`), &data)
+
+	for p, want := range map[string]interface{}{
+		"user.friends.0.name": "Bo",
+		"user.age":            float64(30),
+		"user.friends.1.name": nil,
+		"user.friends.x":      nil,
+		"user.age.years":      nil,
+	} {
+		got, ok := lookupPath(data, p)
+		if ok != (want != nil) || got != want {
+			t.Errorf("%s = %v, %v", p, got, ok)
+		}
+	}
+}
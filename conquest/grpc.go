@@ -0,0 +1,409 @@
+package conquest
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// a unary grpc call, Path of its transaction is /package.Service/Method
+type GRPCNotation struct {
+	// request message as json
+	Message string
+}
+
+// connections and method descriptors by targets, shared by users like
+// the http client.
+type grpcPool struct {
+	m     sync.Mutex
+	conns map[string]*grpc.ClientConn
+	// method descriptors by target and path
+	methods map[string]protoreflect.MethodDescriptor
+	// descriptors of a protoset file, server reflection is used without it
+	files *protoregistry.Files
+}
+
+func newGRPCPool() *grpcPool {
+	return &grpcPool{
+		conns:   map[string]*grpc.ClientConn{},
+		methods: map[string]protoreflect.MethodDescriptor{},
+	}
+}
+
+// loads descriptors of a protoset file, which is a serialized
+// FileDescriptorSet as protoc --descriptor_set_out --include_imports
+// writes.
+func loadProtoset(path string) (*protoregistry.Files, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(b, set); err != nil {
+		return nil, err
+	}
+	return protodesc.NewFiles(set)
+}
+
+// returns the connection of target, dials it on first use
+func (p *grpcPool) conn(target *url.URL) (*grpc.ClientConn, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if conn, ok := p.conns[target.Host]; ok {
+		return conn, nil
+	}
+
+	creds := insecure.NewCredentials()
+	if target.Scheme == "https" {
+		creds = credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})
+	}
+
+	conn, err := grpc.NewClient(target.Host,
+		grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	p.conns[target.Host] = conn
+	return conn, nil
+}
+
+// returns the descriptor of the method which target points to
+func (p *grpcPool) method(ctx context.Context, conn *grpc.ClientConn,
+	target *url.URL) (protoreflect.MethodDescriptor, error) {
+
+	key := target.Host + target.Path
+	p.m.Lock()
+	md, ok := p.methods[key]
+	files := p.files
+	p.m.Unlock()
+	if ok {
+		return md, nil
+	}
+
+	i := strings.LastIndex(target.Path, "/")
+	if i <= 0 {
+		return nil, errors.New("Invalid gRPC method: " + target.Path)
+	}
+	service := protoreflect.FullName(target.Path[1:i])
+	name := protoreflect.Name(target.Path[i+1:])
+
+	if files == nil {
+		var err error
+		files, err = reflectFiles(ctx, conn, string(service))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	d, err := files.FindDescriptorByName(service)
+	if err != nil {
+		return nil, errors.New("Unknown gRPC service: " + string(service))
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, errors.New(string(service) + " is not a gRPC service")
+	}
+	md = sd.Methods().ByName(name)
+	if md == nil {
+		return nil, errors.New("Unknown gRPC method: " + target.Path)
+	}
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, errors.New("Only unary gRPC methods are supported: " +
+			target.Path)
+	}
+
+	p.m.Lock()
+	p.methods[key] = md
+	p.m.Unlock()
+	return md, nil
+}
+
+// asks the server for the file which defines symbol and its dependencies
+func reflectFiles(ctx context.Context, conn *grpc.ClientConn,
+	symbol string) (*protoregistry.Files, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CloseSend()
+
+	ask := func(req *rpb.ServerReflectionRequest) ([]*descriptorpb.FileDescriptorProto, error) {
+		if err := stream.Send(req); err != nil {
+			return nil, err
+		}
+		res, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		if e := res.GetErrorResponse(); e != nil {
+			return nil, errors.New("gRPC reflection: " + e.GetErrorMessage())
+		}
+
+		fds := []*descriptorpb.FileDescriptorProto{}
+		for _, b := range res.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			fd := &descriptorpb.FileDescriptorProto{}
+			if err := proto.Unmarshal(b, fd); err != nil {
+				return nil, err
+			}
+			fds = append(fds, fd)
+		}
+		return fds, nil
+	}
+
+	fds, err := ask(&rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{
+			FileContainingSymbol: symbol,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// servers may leave out dependencies which are asked by their names
+	set := &descriptorpb.FileDescriptorSet{}
+	known := map[string]bool{}
+	for len(fds) > 0 {
+		fd := fds[0]
+		fds = fds[1:]
+		if known[fd.GetName()] {
+			continue
+		}
+		known[fd.GetName()] = true
+		set.File = append(set.File, fd)
+
+		for _, dep := range fd.GetDependency() {
+			if known[dep] {
+				continue
+			}
+			if _, err := protoregistry.GlobalFiles.FindFileByPath(dep); err == nil {
+				continue
+			}
+			more, err := ask(&rpb.ServerReflectionRequest{
+				MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{
+					FileByFilename: dep,
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+			fds = append(fds, more...)
+		}
+	}
+
+	files := &protoregistry.Files{}
+	for len(set.File) > 0 {
+		// files are registered after their dependencies
+		rest := set.File[:0]
+		for _, fdp := range set.File {
+			fd, err := protodesc.NewFile(fdp, resolver{files})
+			if err != nil {
+				rest = append(rest, fdp)
+				continue
+			}
+			if err := files.RegisterFile(fd); err != nil {
+				return nil, err
+			}
+		}
+		if len(rest) == len(set.File) {
+			return nil, errors.New("gRPC reflection: unresolved dependencies of " +
+				rest[0].GetName())
+		}
+		set.File = rest
+	}
+	return files, nil
+}
+
+// finds descriptors in files first, then in well-known types
+type resolver struct {
+	files *protoregistry.Files
+}
+
+func (r resolver) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	if fd, err := r.files.FindFileByPath(path); err == nil {
+		return fd, nil
+	}
+	return protoregistry.GlobalFiles.FindFileByPath(path)
+}
+
+func (r resolver) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	if d, err := r.files.FindDescriptorByName(name); err == nil {
+		return d, nil
+	}
+	return protoregistry.GlobalFiles.FindDescriptorByName(name)
+}
+
+// closes connections of the pool
+func (p *grpcPool) close() {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	for host, conn := range p.conns {
+		conn.Close()
+		delete(p.conns, host)
+	}
+}
+
+// headers which are not sent as metadata, they are bound to an http/1
+// connection or grpc sets them itself
+var grpcSkippedHeaders = map[string]bool{
+	"connection":          true,
+	"keep-alive":          true,
+	"proxy-connection":    true,
+	"proxy-authorization": true,
+	"transfer-encoding":   true,
+	"upgrade":             true,
+	"te":                  true,
+	"trailer":             true,
+	"host":                true,
+	"content-type":        true,
+	"content-length":      true,
+}
+
+// returns headers of h as metadata of a grpc call. hop-by-hop headers,
+// the ones which Connection names and reserved grpc- keys are skipped.
+func grpcMetadata(h http.Header) metadata.MD {
+	skipped := map[string]bool{}
+	for _, v := range h.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			skipped[strings.ToLower(strings.TrimSpace(name))] = true
+		}
+	}
+
+	md := metadata.MD{}
+	for name, values := range h {
+		name = strings.ToLower(name)
+		if grpcSkippedHeaders[name] || skipped[name] ||
+			strings.HasPrefix(name, "grpc-") {
+			continue
+		}
+		md.Append(name, values...)
+	}
+	return md
+}
+
+// builds the routine of a grpc transaction. it is prepared like an http
+// request whose body is the message as json, then headers of manreq are
+// sent as metadata. response metadata and the response message as json are
+// judged like http responses, the grpc status code by GRPCStatus
+// condition which expects OK unless it is set.
+func grpcRoutine(c *http.Client, conquest *Conquest, t *Transaction, u *mUser,
+	manreq *http.Request, label string) dutyRoutine {
+
+	return func(ctx context.Context, s chan<- *Success,
+		f chan<- *Fail) bool {
+
+		req := manreq.Clone(ctx)
+		failWith := func(kind uint8, err error, elapsed time.Duration) bool {
+			fl := NewFail(kind, label, err, elapsed, req)
+			fl.Group = u.Group
+			f <- fl
+			return false
+		}
+		transactionFail := func(err error) bool {
+			return failWith(REASON_TRANSACTION, err, 0)
+		}
+
+		message, err := prepare(ctx, c, conquest, t, u, req,
+			[]byte(t.GRPC.Message))
+		if err == errSkipped {
+			return true
+		}
+		if err != nil {
+			return transactionFail(err)
+		}
+
+		conn, err := conquest.grpc.conn(req.URL)
+		if err != nil {
+			return transactionFail(err)
+		}
+		md, err := conquest.grpc.method(ctx, conn, req.URL)
+		if err != nil {
+			if ctx.Err() != nil {
+				return failWith(REASON_CANCELLED, ctx.Err(), 0)
+			}
+			return transactionFail(err)
+		}
+
+		in := dynamicpb.NewMessage(md.Input())
+		if err := protojson.Unmarshal(message, in); err != nil {
+			return transactionFail(err)
+		}
+		out := dynamicpb.NewMessage(md.Output())
+
+		outgoing := grpcMetadata(req.Header)
+
+		var header, trailer metadata.MD
+		start := time.Now()
+		err = conn.Invoke(metadata.NewOutgoingContext(ctx, outgoing),
+			req.URL.Path, in, out, grpc.Header(&header), grpc.Trailer(&trailer))
+		elapsed := time.Since(start)
+		if ctx.Err() != nil {
+			return failWith(REASON_CANCELLED, ctx.Err(), elapsed)
+		}
+
+		code := status.Code(err)
+		u.last.Status = 0
+		if code == codes.OK {
+			u.last.Status = http.StatusOK
+		}
+
+		var body []byte
+		if err == nil {
+			body, _ = protojson.Marshal(out)
+		}
+
+		res := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Request:    req,
+		}
+		for _, md := range []metadata.MD{header, trailer} {
+			for name, values := range md {
+				for _, v := range values {
+					res.Header.Add(name, v)
+				}
+			}
+		}
+
+		success, fail := conclude(conquest, t, u, label, req, &checkedResponse{
+			Response: res,
+			Body:     body,
+			GRPC:     status.Convert(err),
+		}, elapsed, nil)
+		if fail != nil {
+			fail.Group = u.Group
+			f <- fail
+			return false
+		}
+		success.Group = u.Group
+		s <- success
+		return true
+	}
+}
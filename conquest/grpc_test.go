@@ -0,0 +1,149 @@
+package conquest
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// serves the health service, with server reflection if reflect is set.
+// metadata of unary calls are passed to check
+func grpcHealthServer(t *testing.T, reflect bool,
+	check func(metadata.MD)) string {
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := grpc.NewServer(grpc.UnaryInterceptor(
+		func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler) (interface{}, error) {
+			md, _ := metadata.FromIncomingContext(ctx)
+			check(md)
+			return handler(ctx, req)
+		}))
+	hs := health.NewServer()
+	hs.SetServingStatus("shop", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(srv, hs)
+	if reflect {
+		reflection.Register(srv)
+	}
+
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+// calls are prepared like http requests, before hooks change the message
+// and metadata, and they are signed
+func TestGRPCPreparesCalls(t *testing.T) {
+	mds := make(chan metadata.MD, 1)
+	addr := grpcHealthServer(t, true, func(md metadata.MD) { mds <- md })
+
+	c := runTestScript(t, `conquest.Host("http://`+addr+`")
+.Iterations(1)
+.Sign({"scheme": "hmac-sha256", "keyId": "id", "secret": "s"})
+.Users(1, function(users){
+users.Every(function(user){
+user.GRPC("grpc.health.v1.Health/Check", {"service": "unknown"})
+.Before(function(req, vars){
+req.headers["X-Hook"] = "1";
+req.body = JSON.stringify({"service": "shop"});
+});
+});
+});`)
+	r := performTest(t, c)
+
+	if r.Fails != 0 || r.Success != 1 {
+		t.Errorf("%d of %d transactions failed: %v", r.Fails, r.Hits, r.Failed)
+	}
+	md := <-mds
+	if got := md.Get("x-hook"); len(got) != 1 || got[0] != "1" {
+		t.Errorf("x-hook metadata = %q", got)
+	}
+	if got := md.Get("authorization"); len(got) != 1 ||
+		!strings.HasPrefix(got[0], "HMAC-SHA256 ") {
+		t.Errorf("authorization metadata = %q", got)
+	}
+}
+
+// responses are judged by their grpc status, which is OK unless
+// GRPCStatus sets another one, and by their messages as json
+func TestPerformGRPC(t *testing.T) {
+	addr := grpcHealthServer(t, true, func(metadata.MD) {})
+
+	c := runTestScript(t, `conquest.Host("http://`+addr+`")
+.Iterations(1)
+.Users(1, function(users){
+users.Every(function(user){
+user.GRPC("grpc.health.v1.Health/Check", {"service": "shop"})
+.Response.Contains("SERVING");
+user.GRPC("grpc.health.v1.Health/Check", {"service": "gone"})
+.Response.GRPCStatus("NOT_FOUND");
+user.GRPC("grpc.health.v1.Health/Check", {"service": "lost"});
+user.GRPC("grpc.health.v1.Health/Watch", {"service": "shop"});
+user.GRPC("grpc.health.v1.Health/Nope", {});
+});
+});`)
+	r := performTest(t, c)
+
+	if r.Success != 2 || r.Fails != 3 {
+		t.Errorf("%d succeeded, %d failed: %v", r.Success, r.Fails, r.Failed)
+	}
+	for label, want := range map[string]string{
+		"/grpc.health.v1.Health/Check": "Expected gRPC status is OK but it returned as NotFound: ",
+		"/grpc.health.v1.Health/Watch": "Only unary gRPC methods are supported: ",
+		"/grpc.health.v1.Health/Nope":  "Unknown gRPC method: ",
+	} {
+		fails := r.Failed[label]
+		if len(fails) != 1 || !strings.HasPrefix(fails[0].Error.Error(), want) {
+			t.Errorf("failures of %s = %v", label, fails)
+		}
+	}
+}
+
+// methods are resolved by a protoset file when the server has no
+// reflection
+func TestPerformGRPCProtoset(t *testing.T) {
+	addr := grpcHealthServer(t, false, func(metadata.MD) {})
+
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		protodesc.ToFileDescriptorProto(healthpb.File_grpc_health_v1_health_proto),
+	}}
+	b, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	protoset := filepath.Join(t.TempDir(), "health.protoset")
+	if err := ioutil.WriteFile(protoset, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := runTestScript(t, `conquest.Host("http://`+addr+`")
+.Iterations(1)
+.Protoset("`+protoset+`")
+.Users(1, function(users){
+users.Every(function(user){
+user.GRPC("grpc.health.v1.Health/Check", {"service": "shop"})
+.Response.Contains("SERVING");
+});
+});`)
+	r := performTest(t, c)
+
+	if r.Fails != 0 || r.Success != 1 {
+		t.Errorf("%d of %d transactions failed: %v", r.Fails, r.Hits, r.Failed)
+	}
+}
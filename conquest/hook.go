@@ -0,0 +1,232 @@
+package conquest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/robertkrimen/otto"
+)
+
+// name of the script array which keeps user-defined functions that are
+// called during the run. functions are referred by their index, so they
+// can be found in the vm copy of every user.
+const scriptFns = "__conquestFns"
+
+// returned by routines whose before hook cancelled the transaction
+var errSkipped = errors.New("transaction is skipped by before hook")
+
+// keeps fn in the script vm and returns its index
+func registerFn(vm *otto.Otto, fn otto.Value) int {
+	fns, err := vm.Get(scriptFns)
+	if err != nil || !fns.IsObject() {
+		panic(errors.New("Script functions can not be registered."))
+	}
+
+	n, err := fns.Object().Call("push", fn)
+	if err != nil {
+		panic(err)
+	}
+
+	i, err := n.ToInteger()
+	if err != nil {
+		panic(err)
+	}
+	return int(i) - 1
+}
+
+// script state of a user. every user runs hooks in its own copy of the
+// script vm, vars object lives as long as the user.
+type userScript struct {
+	vm   *otto.Otto
+	fns  *otto.Object
+	vars otto.Value
+}
+
+// returns the script state of u, copies the vm of conquest on first use.
+func (u *mUser) script(c *Conquest) (*userScript, error) {
+	if u.vm != nil {
+		return u.vm, nil
+	}
+	if c.vm == nil {
+		return nil, errors.New("No script vm to run hooks")
+	}
+
+	c.vmM.Lock()
+	vm := c.vm.Copy()
+	c.vmM.Unlock()
+
+	fns, err := vm.Get(scriptFns)
+	if err != nil {
+		return nil, err
+	}
+
+	vars, err := vm.Object("({})")
+	if err != nil {
+		return nil, err
+	}
+
+	u.vm = &userScript{
+		vm:   vm,
+		fns:  fns.Object(),
+		vars: vars.Value(),
+	}
+	return u.vm, nil
+}
+
+// calls i. registered function in the vm of u. arguments are converted
+// from json, so they are plain script objects which hooks can modify.
+func (u *mUser) call(c *Conquest, i int, args ...interface{}) (otto.Value,
+	[]otto.Value, error) {
+
+	s, err := u.script(c)
+	if err != nil {
+		return otto.UndefinedValue(), nil, err
+	}
+
+	fn, err := s.fns.Get(strconv.Itoa(i))
+	if err != nil {
+		return otto.UndefinedValue(), nil, err
+	}
+
+	values := []interface{}{}
+	objects := []otto.Value{}
+	for _, arg := range args {
+		b, err := json.Marshal(arg)
+		if err != nil {
+			return otto.UndefinedValue(), nil, err
+		}
+
+		obj, err := s.vm.Object("(" + string(b) + ")")
+		if err != nil {
+			return otto.UndefinedValue(), nil, err
+		}
+		values = append(values, obj)
+		objects = append(objects, obj.Value())
+	}
+	values = append(values, s.vars)
+
+	ret, err := fn.Call(otto.UndefinedValue(), values...)
+	return ret, objects, err
+}
+
+// request object of hooks
+type hookRequest struct {
+	Method  string            `json:"method"`
+	Url     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// response object of hooks and checks
+type hookResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Cookies map[string]string `json:"cookies"`
+	Body    string            `json:"body"`
+	// elapsed time in milliseconds
+	Time float64 `json:"time"`
+	// data of a graphql result
+	Data interface{} `json:"data,omitempty"`
+}
+
+func newHookResponse(res *http.Response, body []byte,
+	elapsed time.Duration) *hookResponse {
+	r := &hookResponse{
+		Status:  res.StatusCode,
+		Headers: map[string]string{},
+		Cookies: map[string]string{},
+		Body:    string(body),
+		Time:    float64(elapsed.Nanoseconds()) / float64(time.Millisecond),
+	}
+	for name := range res.Header {
+		r.Headers[name] = res.Header.Get(name)
+	}
+	for _, c := range res.Cookies() {
+		r.Cookies[c.Name] = c.Value
+	}
+	return r
+}
+
+// runs before hooks of t with req. hooks can change url, headers and body
+// of req, and cancel the transaction by returning false. returns the body
+// which is sent.
+func beforeRequest(c *Conquest, t *Transaction, u *mUser, req *http.Request,
+	body []byte) ([]byte, error) {
+
+	for _, i := range t.Before {
+		hr := &hookRequest{
+			Method:  req.Method,
+			Url:     req.URL.String(),
+			Headers: map[string]string{},
+			Body:    string(body),
+		}
+		for name := range req.Header {
+			hr.Headers[name] = req.Header.Get(name)
+		}
+
+		ret, objs, err := u.call(c, i, hr)
+		if err != nil {
+			return nil, err
+		}
+		if ret.IsBoolean() {
+			if ok, _ := ret.ToBoolean(); !ok {
+				return nil, errSkipped
+			}
+		}
+
+		exp, err := objs[0].Export()
+		if err != nil {
+			return nil, err
+		}
+		b, err := json.Marshal(exp)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, hr); err != nil {
+			return nil, err
+		}
+
+		if hr.Url != req.URL.String() {
+			target, err := url.Parse(hr.Url)
+			if err != nil {
+				return nil, err
+			}
+			req.URL, req.Host = target, target.Host
+		}
+
+		req.Header = http.Header{}
+		for name, val := range hr.Headers {
+			req.Header.Set(name, val)
+		}
+
+		body = []byte(hr.Body)
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+	return body, nil
+}
+
+// runs after hooks of t with the response. a hook fails the transaction
+// by returning false or throwing an error.
+func afterResponse(c *Conquest, t *Transaction, u *mUser,
+	res *hookResponse) error {
+
+	for _, i := range t.After {
+		ret, _, err := u.call(c, i, res)
+		if err != nil {
+			return err
+		}
+		if ret.IsBoolean() {
+			if ok, _ := ret.ToBoolean(); !ok {
+				return errors.New("After hook rejected the response.")
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package conquest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// before hooks change requests or skip them, after hooks capture values
+// of responses into vars or fail them
+func TestPerformHooks(t *testing.T) {
+	var m sync.Mutex
+	got := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			body, _ := ioutil.ReadAll(req.Body)
+			m.Lock()
+			got[req.URL.Path] = req.URL.RawQuery + " " +
+				req.Header.Get("X-Token") + " " + string(body)
+			m.Unlock()
+			if req.URL.Path == "/login" {
+				w.Write([]byte(`{"token": "t0k3n"}`))
+			}
+		}))
+	defer srv.Close()
+
+	c := runTestScript(t, `conquest.Host("`+srv.URL+`")
+.Iterations(1)
+.Users(1, function(users){
+users.Every(function(user){
+user.Do("POST", "/login").After(function(res, vars){
+vars.token = JSON.parse(res.body).token;
+});
+user.Do("GET", "/me").Before(function(req, vars){
+req.headers["X-Token"] = vars.token;
+req.url = req.url + "?v=2";
+});
+user.Do("POST", "/echo").RawBody("sent").Before(function(req, vars){
+req.body = req.body + " by hook";
+});
+user.Do("GET", "/skipped").Before(function(req, vars){ return false; });
+user.Do("GET", "/rejected").After(function(res, vars){ return res.status != 200; });
+});
+});`)
+	r := performTest(t, c)
+
+	if r.Success != 3 || r.Fails != 1 || len(r.Failed["/rejected"]) != 1 {
+		t.Errorf("%d succeeded, %d failed: %v", r.Success, r.Fails, r.Failed)
+	}
+	want := map[string]string{
+		"/login":    "  ",
+		"/me":       "v=2 t0k3n ",
+		"/echo":     "  sent by hook",
+		"/rejected": "  ",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("requests = %q, want %q", got, want)
+	}
+}
@@ -3,11 +3,13 @@ package conquest
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/robertkrimen/otto"
-	"net/url"
+	"strings"
 	"time"
 	
 	"github.com/brsyuksel/conquest/utils"
+	"google.golang.org/grpc/codes"
 )
 
 // Returns t as otto.Value or panics
@@ -18,6 +20,12 @@ func toOttoValueOrPanic(vm *otto.Otto, t interface{}) otto.Value {
 	return obj
 }
 
+// Returns the position of the script code which calls a builder
+func scriptPosition(vm *otto.Otto) Position {
+	ctx := vm.ContextLimit(0)
+	return Position{File: ctx.Filename, Line: ctx.Line, Column: ctx.Column}
+}
+
 // javascript conquest object
 type JSConquest struct {
 	conquest *Conquest
@@ -25,7 +33,7 @@ type JSConquest struct {
 }
 
 // conquest.prototype.Dump
-// Prints configuration by javascript and panics
+// Prints configuration as json and goes on with the script
 // Useful for debugging
 // Ex:
 // conquest.Dump()
@@ -33,8 +41,8 @@ func (c JSConquest) Dump(call otto.FunctionCall) otto.Value {
 	jbyte, err := json.MarshalIndent(c.conquest, "", "\t")
 	utils.UnlessNilThenPanic(err)
 
-	panic(string(jbyte))
-	return otto.Value{}
+	fmt.Println(string(jbyte))
+	return toOttoValueOrPanic(c.vm, c)
 }
 
 // conquest.prototype.Proto
@@ -44,10 +52,7 @@ func (c JSConquest) Proto(call otto.FunctionCall) otto.Value {
 	proto, err := call.Argument(0).ToString()
 	utils.UnlessNilThenPanic(err)
 
-	if proto != "HTTP/1.1" && proto != "HTTP/1.0" {
-		panic("Only HTTP/1.1 and HTTP/1.0 protocols are available.")
-	}
-	c.conquest.Proto = proto
+	utils.UnlessNilThenPanic(c.conquest.SetProto(proto))
 	return toOttoValueOrPanic(c.vm, c)
 }
 
@@ -85,11 +90,162 @@ func (c JSConquest) Host(call otto.FunctionCall) otto.Value {
 	host_str, err := call.Argument(0).ToString()
 	utils.UnlessNilThenPanic(err)
 
-	hostUrl, err := url.Parse(host_str)
+	utils.UnlessNilThenPanic(c.conquest.SetHost(host_str))
+	return toOttoValueOrPanic(c.vm, c)
+}
+
+// conquest.prototype.Hosts
+// Sets named origins, transactions reach them with an alias prefixed path
+// Ex:
+// conquest.Hosts({"api": "https://api.local", "auth": "https://auth.local"})
+// user.Do("GET", "auth:/token")
+func (c JSConquest) Hosts(call otto.FunctionCall) otto.Value {
+	arg := call.Argument(0)
+	panicStr := "Hosts function parameter 1 must be an object."
+
+	if arg.Class() != "Object" {
+		panic(errors.New(panicStr))
+	}
+
+	argObj := arg.Object()
+	if argObj == nil {
+		panic(errors.New(panicStr))
+	}
+
+	for _, k := range argObj.Keys() {
+		val, err := argObj.Get(k)
+		utils.UnlessNilThenPanic(err)
+
+		valStr, err := val.ToString()
+		utils.UnlessNilThenPanic(err)
+
+		utils.UnlessNilThenPanic(c.conquest.SetAlias(k, valStr))
+	}
+	return toOttoValueOrPanic(c.vm, c)
+}
+
+// conquest.prototype.OAuth2
+// Every user requests its own token with client credentials grant and
+// refreshes it before it expires. The token is sent as bearer unless a
+// transaction has its own authentication or clears initial headers
+// Ex:
+// conquest.OAuth2({
+//   "tokenUrl": "auth:/token", "clientId": "id", "clientSecret": "secret",
+//   "scopes": ["read", "write"]
+// })
+func (c JSConquest) OAuth2(call otto.FunctionCall) otto.Value {
+	arg := call.Argument(0)
+	panicStr := "OAuth2 function parameter 1 must be an object."
+
+	if arg.Class() != "Object" {
+		panic(errors.New(panicStr))
+	}
+
+	exp, err := arg.Export()
+	utils.UnlessNilThenPanic(err)
+
+	opts, ok := exp.(map[string]interface{})
+	if !ok {
+		panic(errors.New(panicStr))
+	}
+
+	config := &OAuth2Config{}
+	for k, dst := range map[string]*string{
+		"tokenUrl":     &config.TokenUrl,
+		"clientId":     &config.ClientId,
+		"clientSecret": &config.ClientSecret,
+	} {
+		val, ok := opts[k].(string)
+		if !ok || val == "" {
+			panic(errors.New("OAuth2 " + k + " must be a string."))
+		}
+		*dst = val
+	}
+
+	switch scopes := opts["scopes"].(type) {
+	case nil:
+	case []string:
+		config.Scopes = scopes
+	case []interface{}:
+		for _, s := range scopes {
+			config.Scopes = append(config.Scopes, fmt.Sprint(s))
+		}
+	default:
+		panic(errors.New("OAuth2 scopes must be an array."))
+	}
+
+	c.conquest.OAuth2 = config
+	return toOttoValueOrPanic(c.vm, c)
+}
+
+// converts script object of signing settings to SignConfig
+func signConfig(arg otto.Value) *SignConfig {
+	panicStr := "Sign function parameter 1 must be an object."
+	if arg.Class() != "Object" {
+		panic(errors.New(panicStr))
+	}
+
+	exp, err := arg.Export()
 	utils.UnlessNilThenPanic(err)
 
-	c.conquest.Host = hostUrl.Host
-	c.conquest.scheme = hostUrl.Scheme
+	opts, ok := exp.(map[string]interface{})
+	if !ok {
+		panic(errors.New(panicStr))
+	}
+
+	config := &SignConfig{}
+	for k, dst := range map[string]*string{
+		"scheme":  &config.Scheme,
+		"keyId":   &config.KeyId,
+		"secret":  &config.Secret,
+		"region":  &config.Region,
+		"service": &config.Service,
+	} {
+		if v, ok := opts[k]; ok {
+			*dst = fmt.Sprint(v)
+		}
+	}
+
+	switch headers := opts["headers"].(type) {
+	case nil:
+	case []string:
+		config.Headers = headers
+	case []interface{}:
+		for _, h := range headers {
+			config.Headers = append(config.Headers, fmt.Sprint(h))
+		}
+	default:
+		panic(errors.New("Sign headers must be an array."))
+	}
+
+	switch config.Scheme {
+	case SIGN_HMAC_SHA256:
+	case SIGN_SIGV4:
+		if config.Region == "" || config.Service == "" {
+			panic(errors.New("sigv4 signing needs region and service."))
+		}
+	default:
+		panic(errors.New("Sign scheme must be " + SIGN_HMAC_SHA256 + " or " +
+			SIGN_SIGV4 + "."))
+	}
+	if config.KeyId == "" || config.Secret == "" {
+		panic(errors.New("Sign keyId and secret must be set."))
+	}
+	return config
+}
+
+// conquest.prototype.Sign
+// Signs every request over method, path, sorted query, body hash, timestamp
+// and listed headers, just before it is sent. The signature goes into the
+// Authorization header, so transactions which set it with Auth, OAuth2 or
+// a header fail instead of being signed.
+// Ex:
+// conquest.Sign({"scheme": "hmac-sha256", "keyId": "id", "secret": "s",
+//   "headers": ["Content-Type"]})
+// conquest.Sign({"scheme": "sigv4", "keyId": "AKID", "secret": "s",
+//   "region": "eu-west-1", "service": "execute-api"})
+func (c JSConquest) Sign(call otto.FunctionCall) otto.Value {
+	c.conquest.Sign = signConfig(call.Argument(0))
 	return toOttoValueOrPanic(c.vm, c)
 }
 
@@ -109,6 +265,39 @@ func (c JSConquest) Duration(call otto.FunctionCall) otto.Value {
 	return toOttoValueOrPanic(c.vm, c)
 }
 
+// conquest.prototype.Iterations
+// Makes every user perform its every/then/finally journey exactly n times
+// instead of running for a duration
+// Ex:
+// conquest.Iterations(1)
+func (c JSConquest) Iterations(call otto.FunctionCall) otto.Value {
+	n, err := call.Argument(0).ToInteger()
+	utils.UnlessNilThenPanic(err)
+
+	if n <= 0 {
+		panic(errors.New("Iterations can not be equal zero or lesser."))
+	}
+
+	c.conquest.Iterations = uint64(n)
+	return toOttoValueOrPanic(c.vm, c)
+}
+
+// conquest.prototype.TotalTransactions
+// Stops the whole run after n transactions
+// Ex:
+// conquest.TotalTransactions(1000)
+func (c JSConquest) TotalTransactions(call otto.FunctionCall) otto.Value {
+	n, err := call.Argument(0).ToInteger()
+	utils.UnlessNilThenPanic(err)
+
+	if n <= 0 {
+		panic(errors.New("Total transactions can not be equal zero or lesser."))
+	}
+
+	c.conquest.TotalTransactions = uint64(n)
+	return toOttoValueOrPanic(c.vm, c)
+}
+
 // sets initial cookies and headers for conquest
 func conquestInitials(conquest *Conquest, method string, call *otto.FunctionCall) {
 	arg := call.Argument(0)
@@ -134,11 +323,7 @@ func conquestInitials(conquest *Conquest, method string, call *otto.FunctionCall
 			panic(err)
 		}
 
-		if _, exists := conquest.Initials[method]; !exists {
-			conquest.Initials[method] = map[string]interface{}{}
-		}
-
-		conquest.Initials[method][k] = valStr
+		conquest.SetInitial(method, k, valStr)
 	}
 }
 
@@ -160,29 +345,56 @@ func (c JSConquest) Cookies(call otto.FunctionCall) otto.Value {
 	return toOttoValueOrPanic(c.vm, c)
 }
 
-// conquest.prototype.Cookies
-// Sets total user count and calls user defined functions with JSTransactionCtx
-// Ex: conquest.Users(100, function(user){})
+// conquest.prototype.Users
+// Sets total user count of a group and calls user defined functions with
+// JSTransactionCtx. Groups with different names run at the same time, the
+// unnamed group is extended by every call without a name.
+// Ex: conquest.Users(100, function(users){})
+// Ex: conquest.Users("browsers", 80, function(users){})
 func (c JSConquest) Users(call otto.FunctionCall) otto.Value {
-	if len(call.ArgumentList) != 2 {
-		panic(errors.New("conquest.Users method takes exactly 2 arguments."))
+	args := call.ArgumentList
+	if len(args) != 2 && len(args) != 3 {
+		panic(errors.New("conquest.Users method takes 2 or 3 arguments."))
+	}
+
+	name := ""
+	if len(args) == 3 {
+		n, err := args[0].ToString()
+		utils.UnlessNilThenPanic(err)
+		if n == "" {
+			panic(errors.New("Users group name can not be empty."))
+		}
+		name = n
+		args = args[1:]
 	}
 
-	uc, err := call.Argument(0).ToInteger()
+	uc, err := args[0].ToInteger()
 	utils.UnlessNilThenPanic(err)
 
 	if uc <= 0 {
 		panic(errors.New("Total users can not be equal zero or lesser."))
 	}
 
-	c.conquest.TotalUsers = uint64(uc)
-
-	fn := call.Argument(1)
+	fn := args[1]
 	if !fn.IsFunction() {
-		panic(errors.New("Users function argument 2 must be a function."))
+		panic(errors.New("Users function last argument must be a function."))
 	}
 
-	ctx := NewJSTransactionCtx(&c)
+	for _, g := range c.conquest.Groups {
+		if g.Name != name {
+			continue
+		}
+		if name == "" {
+			panic(errors.New("Users without a group name is already defined, " +
+				"name the groups to define more than one."))
+		}
+		panic(errors.New("Users group " + name + " is already defined."))
+	}
+
+	g := c.conquest.group(name)
+	g.TotalUsers = uint64(uc)
+
+	ctx := NewJSTransactionCtx(&c, g)
 	ctxObj := toOttoValueOrPanic(c.vm, *ctx)
 
 	_, err = fn.Call(fn, ctxObj)
@@ -191,19 +403,129 @@ func (c JSConquest) Users(call otto.FunctionCall) otto.Value {
 	return toOttoValueOrPanic(c.vm, c)
 }
 
+// conquest.prototype.Protoset
+// Resolves gRPC methods by a protoset file instead of server reflection.
+// It can be written by protoc --include_imports --descriptor_set_out
+// Ex: conquest.Protoset("shop.protoset")
+func (c JSConquest) Protoset(call otto.FunctionCall) otto.Value {
+	path, err := call.Argument(0).ToString()
+	utils.UnlessNilThenPanic(err)
+
+	files, err := loadProtoset(path)
+	utils.UnlessNilThenPanic(err)
+
+	c.conquest.grpc.files = files
+	return toOttoValueOrPanic(c.vm, c)
+}
+
+// conquest.prototype.Setup
+// Declares transactions which are performed once before any user starts.
+// Values which their hooks capture into vars are shared with all users
+// through fetch.FromShared. Users do not start if a setup transaction fails.
+// Ex: conquest.Setup(function(user){
+//   user.Do("POST", "/tenants").After(function(res, vars){
+//     vars.tenant = JSON.parse(res.body).id;
+//   });
+// })
+func (c JSConquest) Setup(call otto.FunctionCall) otto.Value {
+	fn := call.Argument(0)
+	if !fn.IsFunction() {
+		panic(errors.New("Setup function argument 1 must be a function."))
+	}
+
+	c.conquest.Setup = append(c.conquest.Setup,
+		declareTransactions(&c, CTX_EVERY, fn)...)
+	return toOttoValueOrPanic(c.vm, c)
+}
+
+// conquest.prototype.Teardown
+// Declares transactions which are performed once after all users stop, by
+// the same user as setup, so they see its cookies and vars. Teardown is
+// stopped after a timeout, 30s unless it is given as the second argument.
+// Ex: conquest.Teardown(function(user){
+//   user.Do("DELETE", "/tenants/").Before(function(req, vars){
+//     req.url += vars.tenant;
+//   });
+// }, "1m")
+func (c JSConquest) Teardown(call otto.FunctionCall) otto.Value {
+	fn := call.Argument(0)
+	if !fn.IsFunction() {
+		panic(errors.New("Teardown function argument 1 must be a function."))
+	}
+
+	if len(call.ArgumentList) > 1 {
+		timeoutStr, err := call.Argument(1).ToString()
+		utils.UnlessNilThenPanic(err)
+
+		timeout, err := time.ParseDuration(timeoutStr)
+		utils.UnlessNilThenPanic(err)
+		if timeout <= 0 {
+			panic(errors.New("Teardown timeout can not be equal zero or lesser."))
+		}
+		c.conquest.TeardownTimeout = timeout
+	}
+
+	c.conquest.Teardown = append(c.conquest.Teardown,
+		declareTransactions(&c, CTX_FINALLY, fn)...)
+	return toOttoValueOrPanic(c.vm, c)
+}
+
 // Transaction context manager
 type JSTransactionCtx struct {
 	jsconquest *JSConquest
+	group      *Group
 }
 
 // Returns new JSTransactionCtx pointer
-func NewJSTransactionCtx(jsc *JSConquest) *JSTransactionCtx {
+func NewJSTransactionCtx(jsc *JSConquest, g *Group) *JSTransactionCtx {
 	return &JSTransactionCtx{
 		jsconquest: jsc,
+		group:      g,
 	}
 }
 
-// Adds or gets context from Conquest.Track
+// parses a duration argument or panics
+func durationArgument(arg otto.Value) time.Duration {
+	durationStr, err := arg.ToString()
+	utils.UnlessNilThenPanic(err)
+
+	duration, err := time.ParseDuration(durationStr)
+	utils.UnlessNilThenPanic(err)
+
+	if duration < 0 {
+		panic(errors.New("Duration can not be negative."))
+	}
+	return duration
+}
+
+// users.ThinkTime
+// Sets the pause of users in the group after each transaction
+// Ex: users.ThinkTime("500ms")
+func (c JSTransactionCtx) ThinkTime(call otto.FunctionCall) otto.Value {
+	c.group.ThinkTime = durationArgument(call.Argument(0))
+	return toOttoValueOrPanic(c.jsconquest.vm, c)
+}
+
+// users.RampUp
+// Starts users of the group evenly over the duration instead of at once
+// Ex: users.RampUp("30s")
+func (c JSTransactionCtx) RampUp(call otto.FunctionCall) otto.Value {
+	c.group.RampUp = durationArgument(call.Argument(0))
+	return toOttoValueOrPanic(c.jsconquest.vm, c)
+}
+
+// returns a positive weight argument or panics
+func weightArgument(arg otto.Value) uint64 {
+	w, err := arg.ToInteger()
+	utils.UnlessNilThenPanic(err)
+
+	if w <= 0 {
+		panic(errors.New("Weight can not be equal zero or lesser."))
+	}
+	return uint64(w)
+}
+
+// Adds or gets context from Track of the group
 // If wanted ctx type is Finally, function goes to last of Track and
 // uses it if its type is Finally, otherwise adds a new Finally type ctx
 // if wanted ctx type is Every/Cases/Then, function goes to last of Track
@@ -219,7 +541,7 @@ func ctxResolve(ctxType uint8, jsctx *JSTransactionCtx,
 		panic(errors.New("Context functions argument 1 must be a function."))
 	}
 
-	track := jsctx.jsconquest.conquest.Track
+	track := jsctx.group.Track
 	var ctx *TransactionContext
 	if track == nil || track.CtxType == CTX_FINALLY {
 		ctx = &TransactionContext{
@@ -228,7 +550,7 @@ func ctxResolve(ctxType uint8, jsctx *JSTransactionCtx,
 		if track != nil {
 			ctx.Next = track
 		}
-		jsctx.jsconquest.conquest.Track = ctx
+		jsctx.group.Track = ctx
 		goto CALL_UD_FN
 	}
 
@@ -261,13 +583,28 @@ func ctxResolve(ctxType uint8, jsctx *JSTransactionCtx,
 	}
 
 CALL_UD_FN:
+	if len(call.ArgumentList) > 1 {
+		if ctxType != CTX_THEN {
+			panic(errors.New("Only then contexts can be weighted."))
+		}
+		ctx.Weight = weightArgument(call.Argument(1))
+	}
+
 	jstact := &JSTransaction{
 		jsconquest: jsctx.jsconquest,
 		ctx:        ctx,
 		Response: JSTransactionResponse{
 			jsconquest: jsctx.jsconquest,
 		},
+		Auth: JSTransactionAuth{
+			jsconquest: jsctx.jsconquest,
+		},
+		Events: JSTransactionEvents{
+			jsconquest: jsctx.jsconquest,
+		},
 	}
+	// auth methods called before Do panic like the other builders
+	jstact.Auth.jstransaction = jstact
 	jstact_obj := toOttoValueOrPanic(jsctx.jsconquest.vm, *jstact)
 	_, err := fn.Call(fn, jstact_obj)
 	utils.UnlessNilThenPanic(err)
@@ -281,6 +618,9 @@ func (c JSTransactionCtx) Every(call otto.FunctionCall) otto.Value {
 }
 
 // users.Then
+// An optional weight makes the context picked that much more often than
+// other then contexts in random mode
+// Ex: users.Then(function(user){}, 3)
 func (c JSTransactionCtx) Then(call otto.FunctionCall) otto.Value {
 	return ctxResolve(CTX_THEN, &c, &call)
 }
@@ -311,6 +651,31 @@ func (r JSTransactionResponse) StatusCode(call otto.FunctionCall) otto.Value {
 	return toOttoValueOrPanic(r.jsconquest.vm, r)
 }
 
+// Sets expected status of a gRPC call by its name or number
+// Ex: t.Response.GRPCStatus("NOT_FOUND")
+func (r JSTransactionResponse) GRPCStatus(call otto.FunctionCall) otto.Value {
+	if r.transaction == nil || r.transaction.GRPC == nil {
+		panic(errors.New("Response.GRPCStatus can only be used with GRPC calls."))
+	}
+
+	arg := call.Argument(0)
+	var code codes.Code
+	if arg.IsNumber() {
+		n, err := arg.ToInteger()
+		utils.UnlessNilThenPanic(err)
+		code = codes.Code(n)
+	} else {
+		name, err := arg.ToString()
+		utils.UnlessNilThenPanic(err)
+
+		err = code.UnmarshalJSON([]byte(`"` + strings.ToUpper(name) + `"`))
+		utils.UnlessNilThenPanic(err)
+	}
+
+	r.transaction.ResConditions["GRPCStatus"] = code
+	return toOttoValueOrPanic(r.jsconquest.vm, r)
+}
+
 // Inserts a map as like "Contains":[substr] into transactions response
 // conditions
 // Ex: t.Response.Contains("<h1>Fancy Header</h1>")
@@ -322,6 +687,33 @@ func (r JSTransactionResponse) Contains(call otto.FunctionCall) otto.Value {
 	return toOttoValueOrPanic(r.jsconquest.vm, r)
 }
 
+// Adds a named check which is written in script. fn gets the response
+// object with status, headers, cookies, body and time fields and passes
+// the check by returning a truthy value. Pass and fail counts of every
+// check are reported, a failed check also fails the transaction.
+// Ex: t.Response.Check("has items", function(res){
+//   return res.status < 500 && JSON.parse(res.body).items.length > 0;
+// })
+func (r JSTransactionResponse) Check(call otto.FunctionCall) otto.Value {
+	if len(call.ArgumentList) != 2 {
+		panic(errors.New("Response.Check function takes exactly 2 arguments."))
+	}
+
+	name, err := call.Argument(0).ToString()
+	utils.UnlessNilThenPanic(err)
+
+	fn := call.Argument(1)
+	if !fn.IsFunction() {
+		panic(errors.New("Response.Check function argument 2 must be a function."))
+	}
+
+	r.transaction.Checks = append(r.transaction.Checks, &Check{
+		Name: name,
+		Fn:   registerFn(r.jsconquest.vm, fn),
+	})
+	return toOttoValueOrPanic(r.jsconquest.vm, r)
+}
+
 // Inserts a map as like [name]:[expected] into kind map of
 // transactions response conditions. if conditions[kind] is not allocated,
 // allocates first.
@@ -351,12 +743,156 @@ func (r JSTransactionResponse) Header(call otto.FunctionCall) otto.Value {
 	return expectedAdditionals("Header", &call, &r)
 }
 
+// Sets expected values at dotted paths in data of a GraphQL result,
+// values which are not strings are compared as json
+// Ex: t.Response.Data("user.friends.0.name", "Ana")
+func (r JSTransactionResponse) Data(call otto.FunctionCall) otto.Value {
+	return expectedAdditionals("Data", &call, &r)
+}
+
 // Sets expected cookies
 // Ex: t.Response.Cookie("X-Header", "Expected Value");
 func (r JSTransactionResponse) Cookie(call otto.FunctionCall) otto.Value {
 	return expectedAdditionals("Cookie", &call, &r)
 }
 
+// Window and assertions of a stream transaction
+type JSTransactionEvents struct {
+	jsconquest  *JSConquest
+	transaction *Transaction
+}
+
+func (e *JSTransactionEvents) stream() *StreamNotation {
+	if e.transaction == nil || e.transaction.Stream == nil {
+		panic(errors.New("Call Stream function first for events of a stream."))
+	}
+	return e.transaction.Stream
+}
+
+// Sets how long the stream is read
+// Ex: t.Events.Window("1m")
+func (e JSTransactionEvents) Window(call otto.FunctionCall) otto.Value {
+	e.stream().Window = durationArgument(call.Argument(0))
+	return toOttoValueOrPanic(e.jsconquest.vm, e)
+}
+
+// Sets the least events which the window has to receive
+// Ex: t.Events.Min(10)
+func (e JSTransactionEvents) Min(call otto.FunctionCall) otto.Value {
+	n, err := call.Argument(0).ToInteger()
+	utils.UnlessNilThenPanic(err)
+
+	if n < 0 {
+		panic(errors.New("Events.Min can not be negative."))
+	}
+	e.stream().MinEvents = uint64(n)
+	return toOttoValueOrPanic(e.jsconquest.vm, e)
+}
+
+// Expects an event in the window whose data contains match, or for which
+// match function returns a truthy value. event has event, id, data and
+// time fields.
+// Ex: t.Events.Expect("price")
+// Ex: t.Events.Expect(function(event, vars){ return event.event == "done"; })
+func (e JSTransactionEvents) Expect(call otto.FunctionCall) otto.Value {
+	stream := e.stream()
+
+	expect := &StreamExpect{Fn: -1}
+	match := call.Argument(0)
+	if match.IsFunction() {
+		expect.Fn = registerFn(e.jsconquest.vm, match)
+	} else {
+		var err error
+		expect.Message, err = match.ToString()
+		utils.UnlessNilThenPanic(err)
+	}
+
+	stream.Expects = append(stream.Expects, expect)
+	return toOttoValueOrPanic(e.jsconquest.vm, e)
+}
+
+// Authentication helpers of a transaction, every method returns the
+// transaction back.
+type JSTransactionAuth struct {
+	jsconquest    *JSConquest
+	jstransaction *JSTransaction
+}
+
+// Sets username and password of basic and digest authentication
+func (a *JSTransactionAuth) credentials(kind uint8, method string,
+	call *otto.FunctionCall) otto.Value {
+	a.jstransaction.unlessAllocatedThenPanic()
+	if len(call.ArgumentList) != 2 {
+		panic(errors.New("Auth." + method +
+			" function takes exactly 2 arguments."))
+	}
+
+	user, err := call.Argument(0).ToString()
+	utils.UnlessNilThenPanic(err)
+
+	pass, err := call.Argument(1).ToString()
+	utils.UnlessNilThenPanic(err)
+
+	a.jstransaction.transaction.Auth = &AuthNotation{
+		Type: kind,
+		Args: []string{user, pass},
+	}
+	return toOttoValueOrPanic(a.jsconquest.vm, *a.jstransaction)
+}
+
+// Sets basic authentication
+// Ex: t.Auth.Basic("user", "pass")
+func (a JSTransactionAuth) Basic(call otto.FunctionCall) otto.Value {
+	return a.credentials(AUTH_BASIC, "Basic", &call)
+}
+
+// Sets digest authentication, the first request answers the challenge of
+// server and following ones reuse it.
+// Ex: t.Auth.Digest("user", "pass")
+func (a JSTransactionAuth) Digest(call otto.FunctionCall) otto.Value {
+	return a.credentials(AUTH_DIGEST, "Digest", &call)
+}
+
+// Sets bearer token, either a string or fetched by a function
+// Ex: t.Auth.Bearer(function(fetch){ return fetch.FromCookie("token"); })
+func (a JSTransactionAuth) Bearer(call otto.FunctionCall) otto.Value {
+	a.jstransaction.unlessAllocatedThenPanic()
+
+	notation := &AuthNotation{
+		Type: AUTH_BEARER,
+		Args: []string{""},
+	}
+
+	val := call.Argument(0)
+	if val.IsFunction() {
+		fetcher := &JSFetch{
+			jsconquest: a.jsconquest,
+		}
+
+		jsfetcher := toOttoValueOrPanic(a.jsconquest.vm, *fetcher)
+		retv, err := val.Call(val, jsfetcher)
+		utils.UnlessNilThenPanic(err)
+
+		retn, err := retv.Export()
+		utils.UnlessNilThenPanic(err)
+
+		notation.Fetch, err = mapToFetchNotation(retn.(map[string]interface{}))
+		utils.UnlessNilThenPanic(err)
+
+		if strKind, ok := CorrectFetch(
+			FETCH_COOKIE|FETCH_HEADER|FETCH_SHARED, notation.Fetch); !ok {
+			panic(errors.New(strKind + " fetch can not be used with Auth.Bearer"))
+		}
+	} else {
+		token, err := val.ToString()
+		utils.UnlessNilThenPanic(err)
+		notation.Args[0] = token
+	}
+
+	a.jstransaction.transaction.Auth = notation
+	return toOttoValueOrPanic(a.jsconquest.vm, *a.jstransaction)
+}
+
 // Transaction methods which called by passed as an argument at the context
 // functions.
 type JSTransaction struct {
@@ -364,6 +900,8 @@ type JSTransaction struct {
 	ctx         *TransactionContext
 	transaction *Transaction
 	Response    JSTransactionResponse
+	Auth        JSTransactionAuth
+	Events      JSTransactionEvents
 }
 
 func (t *JSTransaction) unlessAllocatedThenPanic() {
@@ -372,8 +910,10 @@ func (t *JSTransaction) unlessAllocatedThenPanic() {
 	}
 }
 
-// Creates new transaction
+// Creates new transaction, path can also be an absolute url or a host alias
+// prefixed path
 // Ex: var t = user.Do("GET", "/")
+// Ex: var t = user.Do("GET", "auth:/token")
 func (t JSTransaction) Do(call otto.FunctionCall) otto.Value {
 	if len(call.ArgumentList) != 2 {
 		panic(errors.New("Do function takes exactly 2 parameters."))
@@ -385,8 +925,15 @@ func (t JSTransaction) Do(call otto.FunctionCall) otto.Value {
 	path, err := call.Argument(1).ToString()
 	utils.UnlessNilThenPanic(err)
 
+	t.add(verb, path)
+	return toOttoValueOrPanic(t.jsconquest.vm, t)
+}
+
+// Allocates a new transaction in the context of t
+func (t *JSTransaction) add(verb, path string) {
 	t.transaction = &Transaction{
 		conquest:      t.jsconquest.conquest,
+		pos:           scriptPosition(t.jsconquest.vm),
 		Verb:          verb,
 		Path:          path,
 		Headers:       map[string]interface{}{},
@@ -400,7 +947,286 @@ func (t JSTransaction) Do(call otto.FunctionCall) otto.Value {
 	}
 
 	t.Response.transaction = t.transaction
+	t.Auth.jstransaction = t
+	t.Events.transaction = t.transaction
 	t.ctx.Transactions = append(t.ctx.Transactions, t.transaction)
+}
+
+// Creates a transaction whose response is read as a stream of events for
+// a window of time, 10s by default. Server-sent events are parsed as such,
+// every line of other responses is an event. Time to first event and
+// inter-arrival times are reported, Events sets the window and assertions.
+// Ex: user.Stream("GET", "/events").Events.Window("30s").Min(5)
+func (t JSTransaction) Stream(call otto.FunctionCall) otto.Value {
+	if len(call.ArgumentList) != 2 {
+		panic(errors.New("Stream function takes exactly 2 parameters."))
+	}
+
+	verb, err := call.Argument(0).ToString()
+	utils.UnlessNilThenPanic(err)
+
+	path, err := call.Argument(1).ToString()
+	utils.UnlessNilThenPanic(err)
+
+	t.add(verb, path)
+	t.transaction.Stream = &StreamNotation{}
+	return toOttoValueOrPanic(t.jsconquest.vm, t)
+}
+
+// Creates a GraphQL operation which is sent as a json POST to path.
+// Results with errors fail the transaction, data of results can be checked
+// by Response.Data and is the data field of response objects of hooks and
+// checks. Operations are reported by their names.
+// Ex: user.GraphQL("/graphql", "query User($id: ID!) { user(id: $id) { name } }",
+//   {"id": "1"})
+// Ex: user.GraphQL("/graphql", doc, {}, "CreateUser")
+func (t JSTransaction) GraphQL(call otto.FunctionCall) otto.Value {
+	if len(call.ArgumentList) < 2 || len(call.ArgumentList) > 4 {
+		panic(errors.New("GraphQL function takes 2 to 4 parameters."))
+	}
+
+	path, err := call.Argument(0).ToString()
+	utils.UnlessNilThenPanic(err)
+
+	query, err := call.Argument(1).ToString()
+	utils.UnlessNilThenPanic(err)
+
+	notation := &GraphQLNotation{Query: query}
+	if arg := call.Argument(2); arg.IsObject() {
+		exp, err := arg.Export()
+		utils.UnlessNilThenPanic(err)
+
+		vars, ok := exp.(map[string]interface{})
+		if !ok {
+			panic(errors.New("GraphQL variables must be an object."))
+		}
+		notation.Variables = vars
+	}
+	if arg := call.Argument(3); arg.IsDefined() {
+		notation.OperationName, err = arg.ToString()
+		utils.UnlessNilThenPanic(err)
+	}
+
+	t.add("POST", path)
+	t.transaction.GraphQL = notation
+	return toOttoValueOrPanic(t.jsconquest.vm, t)
+}
+
+// Creates a unary gRPC call of method with a json message. Methods are
+// resolved through server reflection, or a protoset file which is set by
+// conquest.Protoset. A host alias can prefix the method. The call expects
+// OK status unless Response.GRPCStatus sets another one. Before hooks get
+// the message as json body, headers are sent as metadata.
+// Ex: user.GRPC("shop.Cart/AddItem", {"sku": "A-1", "quantity": 2})
+// Ex: user.GRPC("api:shop.Cart/GetCart", {})
+func (t JSTransaction) GRPC(call otto.FunctionCall) otto.Value {
+	if len(call.ArgumentList) < 1 || len(call.ArgumentList) > 2 {
+		panic(errors.New("GRPC function takes 1 or 2 parameters."))
+	}
+
+	method, err := call.Argument(0).ToString()
+	utils.UnlessNilThenPanic(err)
+
+	// methods are paths of http/2 requests
+	path := "/" + strings.TrimPrefix(method, "/")
+	if i := strings.Index(method, ":"); i > 0 {
+		path = method[:i] + ":/" + strings.TrimPrefix(method[i+1:], "/")
+	}
+
+	message := "{}"
+	if arg := call.Argument(1); arg.IsObject() {
+		exp, err := arg.Export()
+		utils.UnlessNilThenPanic(err)
+
+		b, err := json.Marshal(exp)
+		utils.UnlessNilThenPanic(err)
+		message = string(b)
+	} else if arg.IsDefined() {
+		panic(errors.New("GRPC function parameter 2 must be an object."))
+	}
+
+	t.add("GRPC", path)
+	t.transaction.GRPC = &GRPCNotation{Message: message}
+	t.transaction.ResConditions["GRPCStatus"] = codes.OK
+	return toOttoValueOrPanic(t.jsconquest.vm, t)
+}
+
+// Opens a websocket session on path with the cookies and headers of the
+// user. Steps are performed on one connection in declared order, the
+// connection is dropped after the last step unless Close is called.
+// Ex: user.WS("/ws").Send("hello").Expect("welcome", "5s").Close()
+func (t JSTransaction) WS(call otto.FunctionCall) otto.Value {
+	if len(call.ArgumentList) != 1 {
+		panic(errors.New("WS function takes exactly 1 parameter."))
+	}
+
+	path, err := call.Argument(0).ToString()
+	utils.UnlessNilThenPanic(err)
+
+	t.add("GET", path)
+	t.transaction.WS = &WSNotation{}
+
+	ws := &JSWebSocket{
+		jsconquest: t.jsconquest,
+		ws:         t.transaction.WS,
+	}
+	return toOttoValueOrPanic(t.jsconquest.vm, *ws)
+}
+
+// Calls fn with a JSTransaction whose transactions are collected in a
+// detached context and returns them
+func declareTransactions(jsc *JSConquest, ctxType uint8,
+	fn otto.Value) []*Transaction {
+
+	ctx := &TransactionContext{CtxType: ctxType}
+	jstact := &JSTransaction{
+		jsconquest: jsc,
+		ctx:        ctx,
+		Response: JSTransactionResponse{
+			jsconquest: jsc,
+		},
+		Auth: JSTransactionAuth{
+			jsconquest: jsc,
+		},
+		Events: JSTransactionEvents{
+			jsconquest: jsc,
+		},
+	}
+	jstact.Auth.jstransaction = jstact
+	_, err := fn.Call(fn, toOttoValueOrPanic(jsc.vm, *jstact))
+	utils.UnlessNilThenPanic(err)
+	return ctx.Transactions
+}
+
+// Adds a control block whose transactions are declared by fn
+func addBlock(b *Block, fn otto.Value, t *JSTransaction) otto.Value {
+	b.Transactions = declareTransactions(t.jsconquest, t.ctx.CtxType, fn)
+
+	t.ctx.Transactions = append(t.ctx.Transactions, &Transaction{
+		conquest: t.jsconquest.conquest,
+		pos:      scriptPosition(t.jsconquest.vm),
+		Block:    b,
+	})
+	// blocks are not configured like requests, Do comes next
+	t.transaction, t.Response.transaction = nil, nil
+	t.Events.transaction = nil
+	return toOttoValueOrPanic(t.jsconquest.vm, *t)
+}
+
+// Performs transactions declared by fn n times in a row
+// Ex: user.Repeat(3, function(user){ user.Do("GET", "/feed"); })
+func (t JSTransaction) Repeat(call otto.FunctionCall) otto.Value {
+	if len(call.ArgumentList) != 2 {
+		panic(errors.New("Repeat function takes exactly 2 arguments."))
+	}
+
+	n, err := call.Argument(0).ToInteger()
+	utils.UnlessNilThenPanic(err)
+	if n <= 0 {
+		panic(errors.New("Repeat count can not be equal zero or lesser."))
+	}
+
+	fn := call.Argument(1)
+	if !fn.IsFunction() {
+		panic(errors.New("Repeat function argument 2 must be a function."))
+	}
+
+	return addBlock(&Block{Type: BLOCK_REPEAT, Times: uint64(n)}, fn, &t)
+}
+
+// Performs transactions declared by fn if cond returns a truthy value.
+// cond is called when the block is reached, with the outcome of the
+// previous transaction which has status and ok fields, and vars of the
+// user which hooks capture values into. A block of random mode which
+// cond skips is thought after like a transaction.
+// Ex: user.If(function(last, vars){ return last.status == 200 && vars.token; },
+//   function(user){ user.Do("GET", "/account"); })
+func (t JSTransaction) If(call otto.FunctionCall) otto.Value {
+	if len(call.ArgumentList) != 2 {
+		panic(errors.New("If function takes exactly 2 arguments."))
+	}
+
+	cond, fn := call.Argument(0), call.Argument(1)
+	if !cond.IsFunction() || !fn.IsFunction() {
+		panic(errors.New("If function arguments must be functions."))
+	}
+
+	return addBlock(&Block{
+		Type: BLOCK_IF,
+		Fn:   registerFn(t.jsconquest.vm, cond),
+	}, fn, &t)
+}
+
+// Holds the user until n users have arrived at the named point, then
+// releases them together, e.g. to hit an endpoint at the same moment.
+// Waiting users are released anyway after the timeout, 30s by default.
+// Points with the same name are shared by all groups. Then contexts of
+// random mode wait at a point whenever the transaction which follows it is
+// picked, a point at the end of a context is never waited at.
+// Ex: user.Rendezvous("checkout", 50)
+// Ex: user.Rendezvous("checkout", 50, "10s")
+func (t JSTransaction) Rendezvous(call otto.FunctionCall) otto.Value {
+	if len(call.ArgumentList) < 2 || len(call.ArgumentList) > 3 {
+		panic(errors.New("Rendezvous function takes 2 or 3 arguments."))
+	}
+
+	name, err := call.Argument(0).ToString()
+	utils.UnlessNilThenPanic(err)
+	if name == "" {
+		panic(errors.New("Rendezvous name can not be empty."))
+	}
+
+	n, err := call.Argument(1).ToInteger()
+	utils.UnlessNilThenPanic(err)
+	if n <= 0 {
+		panic(errors.New("Rendezvous users can not be equal zero or lesser."))
+	}
+
+	point := &Rendezvous{Name: name, Users: uint64(n)}
+	if len(call.ArgumentList) == 3 {
+		point.Timeout = durationArgument(call.Argument(2))
+	}
+
+	t.ctx.Transactions = append(t.ctx.Transactions, &Transaction{
+		conquest:   t.jsconquest.conquest,
+		pos:        scriptPosition(t.jsconquest.vm),
+		Rendezvous: point,
+	})
+	t.transaction, t.Response.transaction = nil, nil
+	t.Events.transaction = nil
+	return toOttoValueOrPanic(t.jsconquest.vm, t)
+}
+
+// Sets what the user does when transaction fails. "continue" goes on with
+// the next transaction, it is the default. "abort-user" stops the user
+// without its finally contexts. "restart" starts the journey over with a
+// fresh session.
+// Ex: user.Do("POST", "/login").OnFailure("abort-user")
+func (t JSTransaction) OnFailure(call otto.FunctionCall) otto.Value {
+	t.unlessAllocatedThenPanic()
+
+	action, err := call.Argument(0).ToString()
+	utils.UnlessNilThenPanic(err)
+
+	switch action {
+	case "continue":
+		t.transaction.OnFailure = ON_FAILURE_CONTINUE
+	case "abort-user":
+		t.transaction.OnFailure = ON_FAILURE_ABORT_USER
+	case "restart":
+		t.transaction.OnFailure = ON_FAILURE_RESTART
+	default:
+		panic(errors.New("OnFailure action must be continue, abort-user or restart."))
+	}
+	return toOttoValueOrPanic(t.jsconquest.vm, t)
+}
+
+// Sets how often transaction is picked relative to others of its context
+// in random mode, default is 1
+// Ex: t.Weight(10)
+func (t JSTransaction) Weight(call otto.FunctionCall) otto.Value {
+	t.unlessAllocatedThenPanic()
+	t.transaction.Weight = weightArgument(call.Argument(0))
 	return toOttoValueOrPanic(t.jsconquest.vm, t)
 }
 
@@ -444,6 +1270,62 @@ func (t JSTransaction) RejectCookies(call otto.FunctionCall) otto.Value {
 	return toOttoValueOrPanic(t.jsconquest.vm, t)
 }
 
+// Registers a hook function of transaction
+func setHook(kind string, call *otto.FunctionCall, t *JSTransaction) otto.Value {
+	t.unlessAllocatedThenPanic()
+
+	fn := call.Argument(0)
+	if !fn.IsFunction() {
+		panic(errors.New(kind + " function argument 1 must be a function."))
+	}
+
+	i := registerFn(t.jsconquest.vm, fn)
+	switch kind {
+	case "Before":
+		t.transaction.Before = append(t.transaction.Before, i)
+	case "After":
+		t.transaction.After = append(t.transaction.After, i)
+	}
+	return toOttoValueOrPanic(t.jsconquest.vm, *t)
+}
+
+// Calls fn before every request of transaction. req has method, url, headers
+// and body fields which fn can change, vars is an object which lives as long
+// as the user. Returning false skips the transaction.
+// Every user runs hooks in its own copy of the script, so a hook sees
+// global variables as they were when the script finished.
+// Ex: t.Before(function(req, vars){ req.headers["X-Nonce"] = vars.nonce; })
+func (t JSTransaction) Before(call otto.FunctionCall) otto.Value {
+	return setHook("Before", &call, &t)
+}
+
+// Calls fn after every response of transaction. res has status, headers,
+// cookies, body and time fields. Returning false or throwing an error fails
+// the transaction.
+// Ex: t.After(function(res, vars){ vars.nonce = res.headers["X-Nonce"]; })
+func (t JSTransaction) After(call otto.FunctionCall) otto.Value {
+	return setHook("After", &call, &t)
+}
+
+// Overrides conquest signing for transaction, false leaves it unsigned
+// Ex: t.Sign({"scheme": "hmac-sha256", "keyId": "other", "secret": "s"})
+// Ex: t.Sign(false)
+func (t JSTransaction) Sign(call otto.FunctionCall) otto.Value {
+	t.unlessAllocatedThenPanic()
+
+	arg := call.Argument(0)
+	if arg.IsBoolean() {
+		if on, _ := arg.ToBoolean(); on {
+			panic(errors.New("Sign function parameter 1 must be an object or false."))
+		}
+		t.transaction.Sign = &SignConfig{}
+		return toOttoValueOrPanic(t.jsconquest.vm, t)
+	}
+
+	t.transaction.Sign = signConfig(arg)
+	return toOttoValueOrPanic(t.jsconquest.vm, t)
+}
+
 // Sets additional cookies and headers
 func setAdditionals(kind string, call *otto.FunctionCall,
 	t *JSTransaction) otto.Value {
@@ -568,6 +1450,116 @@ func (t JSTransaction) Body(call otto.FunctionCall) otto.Value {
 	return toOttoValueOrPanic(t.jsconquest.vm, t)
 }
 
+// Sets a body which is sent as it is. Objects are sent as json, strings
+// with the given content type.
+// Ex: t.RawBody({"name": "conquest", "tags": ["load"]})
+// Ex: t.RawBody("<item><name>conquest</name></item>", "application/xml")
+func (t JSTransaction) RawBody(call otto.FunctionCall) otto.Value {
+	t.unlessAllocatedThenPanic()
+
+	if len(call.ArgumentList) < 1 || len(call.ArgumentList) > 2 {
+		panic(errors.New("RawBody function takes 1 or 2 parameters."))
+	}
+
+	raw := &RawBody{}
+	arg := call.Argument(0)
+	if arg.IsObject() {
+		exp, err := arg.Export()
+		utils.UnlessNilThenPanic(err)
+
+		b, err := json.Marshal(exp)
+		utils.UnlessNilThenPanic(err)
+		raw.Data, raw.ContentType = string(b), "application/json"
+	} else {
+		data, err := arg.ToString()
+		utils.UnlessNilThenPanic(err)
+		raw.Data = data
+	}
+
+	if ct := call.Argument(1); ct.IsDefined() {
+		contentType, err := ct.ToString()
+		utils.UnlessNilThenPanic(err)
+		raw.ContentType = contentType
+	}
+
+	t.transaction.Raw = raw
+	return toOttoValueOrPanic(t.jsconquest.vm, t)
+}
+
+// Steps of a websocket session, every method returns the session back
+type JSWebSocket struct {
+	jsconquest *JSConquest
+	ws         *WSNotation
+}
+
+func (w *JSWebSocket) addStep(step *WSStep) otto.Value {
+	steps := w.ws.Steps
+	if len(steps) > 0 && steps[len(steps)-1].Type == WS_CLOSE {
+		panic(errors.New("WS session is already closed."))
+	}
+	w.ws.Steps = append(steps, step)
+	return toOttoValueOrPanic(w.jsconquest.vm, *w)
+}
+
+// Sends a text message, objects are sent as json
+// Ex: ws.Send({"type": "subscribe", "channel": "prices"})
+func (w JSWebSocket) Send(call otto.FunctionCall) otto.Value {
+	arg := call.Argument(0)
+
+	var msg string
+	if arg.IsObject() {
+		exp, err := arg.Export()
+		utils.UnlessNilThenPanic(err)
+
+		b, err := json.Marshal(exp)
+		utils.UnlessNilThenPanic(err)
+		msg = string(b)
+	} else {
+		var err error
+		msg, err = arg.ToString()
+		utils.UnlessNilThenPanic(err)
+	}
+
+	return w.addStep(&WSStep{Type: WS_SEND, Message: msg, Fn: -1})
+}
+
+// Waits for a message which contains match, or for which match function
+// returns a truthy value. msg has data and time fields. Other messages
+// are dropped, the step fails if nothing matches in timeout, 10s by
+// default.
+// Ex: ws.Expect("welcome", "5s")
+// Ex: ws.Expect(function(msg, vars){ return JSON.parse(msg.data).ok; })
+func (w JSWebSocket) Expect(call otto.FunctionCall) otto.Value {
+	if len(call.ArgumentList) < 1 || len(call.ArgumentList) > 2 {
+		panic(errors.New("Expect function takes 1 or 2 arguments."))
+	}
+
+	step := &WSStep{Type: WS_EXPECT, Fn: -1}
+	match := call.Argument(0)
+	if match.IsFunction() {
+		step.Fn = registerFn(w.jsconquest.vm, match)
+	} else {
+		var err error
+		step.Message, err = match.ToString()
+		utils.UnlessNilThenPanic(err)
+	}
+
+	if len(call.ArgumentList) == 2 {
+		step.Timeout = durationArgument(call.Argument(1))
+	}
+	return w.addStep(step)
+}
+
+// Closes the connection and waits for the server to close it too
+// Ex: ws.Close()
+func (w JSWebSocket) Close(call otto.FunctionCall) otto.Value {
+	step := &WSStep{Type: WS_CLOSE, Fn: -1}
+	if len(call.ArgumentList) == 1 {
+		step.Timeout = durationArgument(call.Argument(0))
+	}
+	return w.addStep(step)
+}
+
 // fetch object which will be passed as argument user-defined argument at
 // body, header, cookies functions.
 type JSFetch struct {
@@ -590,6 +1582,7 @@ func fetchFrom(kind uint8, call *otto.FunctionCall,
 	notation := map[string]interface{}{
 		"type": kind,
 		"args": args,
+		"pos":  scriptPosition(f.jsconquest.vm),
 	}
 
 	return toOttoValueOrPanic(f.jsconquest.vm, notation)
@@ -612,6 +1605,13 @@ func (f JSFetch) FromCookie(call otto.FunctionCall) otto.Value {
 func (f JSFetch) FromDisk(call otto.FunctionCall) otto.Value {
 	return fetchFrom(FETCH_DISK, &call, &f)
 }
+
+// fetch.FromShared
+// ex: fetch.FromShared("tenant")
+func (f JSFetch) FromShared(call otto.FunctionCall) otto.Value {
+	return fetchFrom(FETCH_SHARED, &call, &f)
+}
+
 /*
 // fetch.FromHtml
 // ex: fetch.FromHtml("GET", "/path", "#selector_id")
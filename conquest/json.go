@@ -19,50 +19,121 @@ func (c *TransactionContext) MarshalJSON() ([]byte, error) {
 
 	return json.Marshal(struct {
 		Type         string
+		Weight       float64
 		Transactions []*Transaction
 		Next         *TransactionContext
 	}{
 		Type:         ctx,
+		Weight:       weightOf(c.Weight),
 		Transactions: c.Transactions,
 		Next:         c.Next,
 	})
 }
 
 func (t *Transaction) MarshalJSON() ([]byte, error) {
+	if t.Block != nil {
+		return json.Marshal(struct {
+			Block        string
+			Weight       float64
+			Transactions []*Transaction
+		}{
+			Block:        t.Block.name(),
+			Weight:       weightOf(t.Weight),
+			Transactions: t.Block.Transactions,
+		})
+	}
+
+	if t.Rendezvous != nil {
+		return json.Marshal(struct {
+			Rendezvous string
+			Users      uint64
+			Timeout    string
+		}{
+			Rendezvous: t.Rendezvous.Name,
+			Users:      t.Rendezvous.Users,
+			Timeout:    t.Rendezvous.Timeout.String(),
+		})
+	}
+
 	var topts string
 
+	// initials are merged into copies, t is left as it is
+	cookies, headers := t.Cookies, t.Headers
 	reqopts := t.ReqOptions
 	if reqopts&CLEAR_COOKIES > 0 {
 		topts += "CLEAR_COOKIES "
 	} else {
-		t.Cookies = utils.MapMerge(t.Cookies, t.conquest.Initials["Cookies"],
-			false)
+		cookies = utils.MapMerge(utils.MapMerge(map[string]interface{}{},
+			t.Cookies, false), t.conquest.Initials["Cookies"], false)
 	}
 
 	if reqopts&CLEAR_HEADERS > 0 {
 		topts += "CLEAR_HEADERS "
 	} else {
-		t.Headers = utils.MapMerge(t.Headers, t.conquest.Initials["Headers"],
-			false)
+		headers = utils.MapMerge(utils.MapMerge(map[string]interface{}{},
+			t.Headers, false), t.conquest.Initials["Headers"], false)
 	}
 	if reqopts&REJECT_COOKIES > 0 {
 		topts += "REJECT_COOKIES "
 	}
 
+	var auth string
+	if t.Auth != nil {
+		switch t.Auth.Type {
+		case AUTH_BASIC:
+			auth = "BASIC"
+		case AUTH_DIGEST:
+			auth = "DIGEST"
+		case AUTH_BEARER:
+			auth = "BEARER"
+		}
+	}
+
+	var onFailure string
+	switch t.OnFailure {
+	case ON_FAILURE_ABORT_USER:
+		onFailure = "ABORT_USER"
+	case ON_FAILURE_RESTART:
+		onFailure = "RESTART"
+	}
+
+	var ws []*WSStep
+	if t.WS != nil {
+		ws = t.WS.Steps
+	}
+
 	res := struct {
-		Options, Header  string
-		Conditions, Body map[string]interface{}
+		Options, Header, Auth, OnFailure string
+		Weight                           float64
+		Conditions, Body                 map[string]interface{}
+		WebSocket                        []*WSStep        `json:",omitempty"`
+		Stream                           *StreamNotation  `json:",omitempty"`
+		GRPC                             *GRPCNotation    `json:",omitempty"`
+		GraphQL                          *GraphQLNotation `json:",omitempty"`
+		Raw                              *RawBody         `json:",omitempty"`
 	}{
 		Options:    topts,
+		Weight:     weightOf(t.Weight),
+		Auth:       auth,
+		OnFailure:  onFailure,
 		Conditions: t.ResConditions,
 		Body:       t.Body,
+		WebSocket:  ws,
+		Stream:     t.Stream,
+		GRPC:       t.GRPC,
+		GraphQL:    t.GraphQL,
+		Raw:        t.Raw,
 	}
 
-	res.Header = t.Verb + " " + t.Path + " " + t.conquest.Proto + "\r\n"
-	res.Header += "Host: " + t.conquest.Host + "\r\n"
+	path, host := t.Path, t.conquest.Host
+	if u, err := t.conquest.resolve(t.Path); err == nil {
+		path, host = u.RequestURI(), u.Host
+	}
+	res.Header = t.Verb + " " + path + " " + t.conquest.Proto + "\r\n"
+	res.Header += "Host: " + host + "\r\n"
 
-	if len(t.Headers) > 0 {
-		for k, v := range t.Headers {
+	if len(headers) > 0 {
+		for k, v := range headers {
 			if _, ok := v.(string); !ok {
 				continue
 			}
@@ -70,9 +141,9 @@ func (t *Transaction) MarshalJSON() ([]byte, error) {
 		}
 	}
 
-	if len(t.Cookies) > 0 {
+	if len(cookies) > 0 {
 		res.Header += "Cookie: "
-		for k, v := range t.Cookies {
+		for k, v := range cookies {
 			if _, ok := v.(string); !ok {
 				continue
 			}
@@ -92,6 +163,8 @@ func (f *FetchNotation) MarshalJSON() ([]byte, error) {
 		kind = "FROM_DISK"
 	case FETCH_HEADER:
 		kind = "FROM_HEADER"
+	case FETCH_SHARED:
+		kind = "FROM_SHARED"
 		/*
 			case FETCH_HTML:
 				kind = "FROM_HTML"
@@ -106,3 +179,23 @@ func (f *FetchNotation) MarshalJSON() ([]byte, error) {
 		Args: f.Args,
 	})
 }
+
+func (s *WSStep) MarshalJSON() ([]byte, error) {
+	var kind string
+	switch s.Type {
+	case WS_SEND:
+		kind = "SEND"
+	case WS_EXPECT:
+		kind = "EXPECT"
+	case WS_CLOSE:
+		kind = "CLOSE"
+	}
+
+	return json.Marshal(struct {
+		Type, Message, Timeout string
+	}{
+		Type:    kind,
+		Message: s.Message,
+		Timeout: s.timeout().String(),
+	})
+}
@@ -0,0 +1,434 @@
+package conquest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// resolved execution plan of a conquest, what users do in which order
+type Plan struct {
+	Proto, Mode                   string
+	Duration                      string `json:",omitempty"`
+	Iterations, TotalTransactions uint64 `json:",omitempty"`
+	Setup, Teardown               []*PlanStep
+	Groups                        []*PlanGroup
+}
+
+// flow of a group of users
+type PlanGroup struct {
+	Name              string
+	Users             uint64
+	ThinkTime, RampUp string
+	Contexts          []*PlanContext
+}
+
+// a context of a flow and the steps which it is made of
+type PlanContext struct {
+	Type   string
+	Weight float64 `json:",omitempty"`
+	Steps  []*PlanStep
+}
+
+// a transaction as it is performed, blocks have steps of their own
+type PlanStep struct {
+	Name     string
+	Position string   `json:",omitempty"`
+	URL      string   `json:",omitempty"`
+	Kind     string   `json:",omitempty"`
+	Options  []string `json:",omitempty"`
+	// effective values, initials included
+	Headers, Cookies []*PlanValue `json:",omitempty"`
+	Query, Form      []*PlanValue `json:",omitempty"`
+	Body             string       `json:",omitempty"`
+	Conditions       []*PlanValue `json:",omitempty"`
+	Checks           []string     `json:",omitempty"`
+	Steps            []*PlanStep  `json:",omitempty"`
+}
+
+// a value of a step, fetched values have their notation and what they are
+// fetched from. Source is empty if nothing provides a fetched value.
+type PlanValue struct {
+	Name, Value string
+	Fetch       *FetchNotation `json:",omitempty"`
+	Source      string         `json:",omitempty"`
+}
+
+var fetchNames = map[uint8]string{
+	FETCH_COOKIE: "FromCookie",
+	FETCH_HEADER: "FromHeader",
+	FETCH_DISK:   "FromDisk",
+	FETCH_SHARED: "FromShared",
+}
+
+// returns f as it is written in script
+func (f *FetchNotation) String() string {
+	args := make([]string, len(f.Args))
+	for i, a := range f.Args {
+		args[i] = jsString(a)
+	}
+	return fetchNames[f.Type] + "(" + strings.Join(args, ", ") + ")"
+}
+
+func (p Position) orEmpty() string {
+	if p.Line == 0 {
+		return ""
+	}
+	return p.String()
+}
+
+type planner struct {
+	c *Conquest
+}
+
+// returns v as a plan value which is used by a transaction of label
+func (p *planner) value(name string, v interface{}, label string,
+	s *userState) *PlanValue {
+
+	f, ok := v.(*FetchNotation)
+	if !ok {
+		str, _ := v.(string)
+		return &PlanValue{Name: name, Value: str}
+	}
+	return &PlanValue{
+		Name:   name,
+		Value:  f.String(),
+		Fetch:  f,
+		Source: s.source(p.c, f, label),
+	}
+}
+
+func (p *planner) values(pairs []exportPair, label string,
+	s *userState) []*PlanValue {
+
+	values := []*PlanValue{}
+	for _, pair := range pairs {
+		values = append(values, p.value(pair.Name, pair.Value, label, s))
+	}
+	return values
+}
+
+// returns headers which t is sent with, sorted by their names
+func (p *planner) headers(t *Transaction, label string,
+	s *userState) []*PlanValue {
+
+	c := p.c
+	contentType := ""
+	switch {
+	case t.Raw != nil:
+		contentType = t.Raw.ContentType
+	case t.GraphQL != nil:
+		contentType = "application/json"
+	case t.isMultiPart:
+		contentType = "multipart/form-data"
+	case len(t.Body) > 0 && t.Verb != "GET" && t.Verb != "HEAD" &&
+		t.Verb != "OPTIONS":
+		contentType = "application/x-www-form-urlencoded"
+	}
+
+	headers := map[string]interface{}{}
+	if contentType != "" && t.GRPC == nil {
+		headers["Content-Type"] = contentType
+	}
+	if t.Auth != nil {
+		switch t.Auth.Type {
+		case AUTH_BASIC:
+			headers["Authorization"] = basicAuthorization(t.Auth.Args[0], t.Auth.Args[1])
+		case AUTH_DIGEST:
+			headers["Authorization"] = "Digest, answer to the challenge of " +
+				t.Auth.Args[0]
+		case AUTH_BEARER:
+			headers["Authorization"] = "Bearer " + t.Auth.Args[0]
+			if t.Auth.Fetch != nil {
+				headers["Authorization"] = t.Auth.Fetch
+			}
+		}
+	} else if c.OAuth2 != nil && t.ReqOptions&CLEAR_HEADERS == 0 {
+		headers["Authorization"] = "Bearer, client credentials token of the user"
+	}
+
+	if t.ReqOptions&CLEAR_HEADERS == 0 {
+		for k, v := range c.Initials["Headers"] {
+			headers[k] = v
+		}
+	}
+	for k, v := range t.Headers {
+		headers[k] = v
+	}
+
+	values := p.values(sortedPairs(headers), label, s)
+	for _, v := range values {
+		if t.Auth != nil && v.Fetch != nil && v.Fetch == t.Auth.Fetch {
+			v.Value = "Bearer " + v.Value
+		}
+	}
+	return values
+}
+
+// returns t as a step which is performed after s, then adds what it
+// collects to s
+func (p *planner) step(t *Transaction, s *userState) *PlanStep {
+	c := p.c
+	if t.Block != nil {
+		step := &PlanStep{Name: t.Block.name(), Position: t.pos.orEmpty()}
+		step.Steps = p.steps(t.Block.Transactions, s)
+		return step
+	}
+	if t.Rendezvous != nil {
+		return &PlanStep{Name: t.Rendezvous.name(), Position: t.pos.orEmpty()}
+	}
+
+	step := &PlanStep{Name: c.transactionName(t), Position: t.pos.orEmpty()}
+	label, err := c.transactionLabel(t)
+	if err != nil {
+		step.Options = append(step.Options, "UNRESOLVED "+err.Error())
+		return step
+	}
+	if u, err := c.resolve(t.Path); err == nil {
+		step.URL = u.String()
+	}
+
+	switch {
+	case t.WS != nil:
+		step.Kind = "websocket"
+	case t.GRPC != nil:
+		step.Kind = "grpc"
+		step.Body = t.GRPC.Message
+	case t.Stream != nil:
+		step.Kind = "stream of " + t.Stream.window().String()
+	case t.GraphQL != nil:
+		step.Kind = "graphql"
+		if b, err := t.GraphQL.body(); err == nil {
+			step.Body = string(b)
+		}
+	case t.Raw != nil:
+		step.Body = t.Raw.Data
+	}
+
+	if t.Skip {
+		step.Options = append(step.Options, "SKIP")
+	}
+	if t.ReqOptions&CLEAR_COOKIES != 0 {
+		step.Options = append(step.Options, "CLEAR_COOKIES")
+	}
+	if t.ReqOptions&CLEAR_HEADERS != 0 {
+		step.Options = append(step.Options, "CLEAR_HEADERS")
+	}
+	if t.ReqOptions&REJECT_COOKIES != 0 {
+		step.Options = append(step.Options, "REJECT_COOKIES")
+	}
+	switch t.OnFailure {
+	case ON_FAILURE_ABORT_USER:
+		step.Options = append(step.Options, "ON_FAILURE ABORT_USER")
+	case ON_FAILURE_RESTART:
+		step.Options = append(step.Options, "ON_FAILURE RESTART")
+	}
+	if t.Weight != 0 {
+		step.Options = append(step.Options,
+			"WEIGHT "+strconv.FormatUint(t.Weight, 10))
+	}
+	if len(t.Before) > 0 {
+		step.Options = append(step.Options, "BEFORE HOOKS")
+	}
+	if len(t.After) > 0 {
+		step.Options = append(step.Options, "AFTER HOOKS")
+	}
+	if t.Sign != nil || c.Sign != nil {
+		step.Options = append(step.Options, "SIGNED")
+	}
+
+	step.Headers = p.headers(t, label, s)
+	step.Cookies = p.values(mergedPairs(c.Initials["Cookies"], t.Cookies,
+		t.ReqOptions&CLEAR_COOKIES != 0), label, s)
+	if t.Raw == nil && t.GraphQL == nil && t.GRPC == nil {
+		switch t.Verb {
+		case "GET", "HEAD", "OPTIONS":
+			step.Query = p.values(sortedPairs(t.Body), label, s)
+		default:
+			step.Form = p.values(sortedPairs(t.Body), label, s)
+		}
+	}
+
+	for _, cond := range sortedPairs(t.ResConditions) {
+		if m, ok := cond.Value.(map[string]string); ok {
+			for _, kv := range sortedPairs(stringMap(m)) {
+				step.Conditions = append(step.Conditions, &PlanValue{
+					Name: cond.Name + " " + kv.Name, Value: kv.Value.(string)})
+			}
+			continue
+		}
+		step.Conditions = append(step.Conditions, &PlanValue{
+			Name: cond.Name, Value: fmt.Sprint(cond.Value)})
+	}
+	for _, check := range t.Checks {
+		step.Checks = append(step.Checks, check.Name)
+	}
+
+	if !t.Skip {
+		s.collect(t, label)
+	}
+	return step
+}
+
+func stringMap(m map[string]string) map[string]interface{} {
+	im := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		im[k] = v
+	}
+	return im
+}
+
+func (p *planner) steps(ts []*Transaction, s *userState) []*PlanStep {
+	steps := []*PlanStep{}
+	for _, t := range ts {
+		steps = append(steps, p.step(t, s))
+	}
+	return steps
+}
+
+// Returns the plan which c is performed by, nothing is sent and c is left
+// as it is
+func NewPlan(c *Conquest) *Plan {
+	p := &planner{c: c}
+	plan := &Plan{
+		Proto:             c.Proto,
+		Mode:              "random",
+		Iterations:        c.Iterations,
+		TotalTransactions: c.TotalTransactions,
+		Groups:            []*PlanGroup{},
+	}
+	// teardown is performed by the user of setup
+	s := newUserState()
+	s.setup = true
+	plan.Setup = p.steps(c.Setup, s)
+	s.setup = false
+	plan.Teardown = p.steps(c.Teardown, s)
+
+	if c.Sequential {
+		plan.Mode = "sequential"
+	}
+	if c.Iterations == 0 {
+		plan.Duration = c.Duration.String()
+	}
+
+	for _, g := range c.Groups {
+		pg := &PlanGroup{
+			Name:      g.Name,
+			Users:     g.TotalUsers,
+			ThinkTime: g.ThinkTime.String(),
+			RampUp:    g.RampUp.String(),
+		}
+		contexts := map[*TransactionContext]*PlanContext{}
+		for ctx := g.Track; ctx != nil; ctx = ctx.Next {
+			pc := &PlanContext{Steps: []*PlanStep{}}
+			switch ctx.CtxType {
+			case CTX_EVERY:
+				pc.Type = "EVERY"
+			case CTX_THEN:
+				pc.Type = "THEN"
+				pc.Weight = weightOf(ctx.Weight)
+			case CTX_FINALLY:
+				pc.Type = "FINALLY"
+			}
+			contexts[ctx] = pc
+			pg.Contexts = append(pg.Contexts, pc)
+		}
+		walkGroup(c, g, func(ctx *TransactionContext, t *Transaction, s *userState) {
+			contexts[ctx].Steps = append(contexts[ctx].Steps, p.step(t, s))
+		})
+		plan.Groups = append(plan.Groups, pg)
+	}
+	return plan
+}
+
+// writes steps as indented text
+func writePlanSteps(w *bufio.Writer, indent string, steps []*PlanStep) {
+	for _, s := range steps {
+		w.WriteString(indent + s.Name)
+		if s.Position != "" {
+			w.WriteString("  (" + s.Position + ")")
+		}
+		w.WriteString("\n")
+
+		in := indent + "  "
+		if s.URL != "" {
+			w.WriteString(in + "url " + s.URL + "\n")
+		}
+		if s.Kind != "" {
+			w.WriteString(in + "kind " + s.Kind + "\n")
+		}
+		if len(s.Options) > 0 {
+			w.WriteString(in + "options " + strings.Join(s.Options, ", ") + "\n")
+		}
+		values := func(kind, sep string, vs []*PlanValue) {
+			for _, v := range vs {
+				w.WriteString(in + kind + " " + v.Name + sep + v.Value)
+				if v.Fetch != nil {
+					source := v.Source
+					if source == "" {
+						source = "nothing provides it"
+					}
+					w.WriteString(" <- " + source)
+				}
+				w.WriteString("\n")
+			}
+		}
+		values("header", ": ", s.Headers)
+		values("cookie", "=", s.Cookies)
+		values("query", "=", s.Query)
+		values("form", "=", s.Form)
+		if s.Body != "" {
+			w.WriteString(in + "body " + s.Body + "\n")
+		}
+		values("expect", ": ", s.Conditions)
+		for _, check := range s.Checks {
+			w.WriteString(in + "check " + check + "\n")
+		}
+		writePlanSteps(w, in, s.Steps)
+	}
+}
+
+// Writes p as human-readable text
+func (p *Plan) Write(out io.Writer) error {
+	w := bufio.NewWriter(out)
+	w.WriteString(p.Mode + " mode, " + p.Proto)
+	if p.Duration != "" {
+		w.WriteString(", duration " + p.Duration)
+	}
+	if p.Iterations > 0 {
+		w.WriteString(", " + strconv.FormatUint(p.Iterations, 10) + " journeys per user")
+	}
+	if p.TotalTransactions > 0 {
+		w.WriteString(", stops after " +
+			strconv.FormatUint(p.TotalTransactions, 10) + " transactions")
+	}
+	w.WriteString("\n")
+
+	if len(p.Setup) > 0 {
+		w.WriteString("\nsetup\n")
+		writePlanSteps(w, "  ", p.Setup)
+	}
+	for _, g := range p.Groups {
+		name := g.Name
+		if name == "" {
+			name = "users"
+		}
+		w.WriteString("\n" + name + ": " + strconv.FormatUint(g.Users, 10) +
+			" users, think time " + g.ThinkTime + ", ramp up " + g.RampUp + "\n")
+		for _, ctx := range g.Contexts {
+			w.WriteString("  " + ctx.Type)
+			if ctx.Type == "THEN" {
+				w.WriteString(" weight " + strconv.FormatFloat(ctx.Weight, 'f', -1, 64))
+			}
+			w.WriteString("\n")
+			writePlanSteps(w, "    ", ctx.Steps)
+		}
+	}
+	if len(p.Teardown) > 0 {
+		w.WriteString("\nteardown\n")
+		writePlanSteps(w, "  ", p.Teardown)
+	}
+	return w.Flush()
+}
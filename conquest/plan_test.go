@@ -0,0 +1,140 @@
+package conquest
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const planScript = `conquest.Host("http://api.local")
+.Headers({"X-App": "test"})
+.Iterations(5)
+.Users(3, function(users){
+users.ThinkTime("1s");
+users.Every(function(user){
+user.Do("POST", "/login").Body({"user": "a"}).Response.StatusCode(200).Cookie("sid", "*");
+});
+users.Then(function(user){
+user.Do("GET", "/items").ClearHeaders().SetCookie("sid", function(fetch){ return fetch.FromCookie("sid"); });
+}, 3);
+users.Then(function(user){
+user.Do("GET", "/search").Body({"q": "a"}).Skip();
+});
+users.Finally(function(user){
+user.Do("GET", "/logout").SetHeader("If-None-Match", function(fetch){ return fetch.FromHeader("Etag"); });
+});
+});`
+
+func TestNewPlan(t *testing.T) {
+	c := runTestScript(t, planScript)
+	plan := NewPlan(c)
+
+	if plan.Mode != "random" || plan.Iterations != 5 || plan.Duration != "" {
+		t.Errorf("plan is %s of %d iterations, duration %q", plan.Mode,
+			plan.Iterations, plan.Duration)
+	}
+	if len(plan.Groups) != 1 {
+		t.Fatalf("%d groups, want 1", len(plan.Groups))
+	}
+	g := plan.Groups[0]
+	if g.Users != 3 || g.ThinkTime != "1s" {
+		t.Errorf("group of %d users thinks %s", g.Users, g.ThinkTime)
+	}
+
+	type context struct {
+		typ    string
+		weight float64
+		steps  []string
+	}
+	want := []context{
+		{"EVERY", 0, []string{"POST /login"}},
+		{"THEN", 3, []string{"GET /items"}},
+		{"THEN", 1, []string{"GET /search"}},
+		{"FINALLY", 0, []string{"GET /logout"}},
+	}
+	if len(g.Contexts) != len(want) {
+		t.Fatalf("%d contexts, want %d", len(g.Contexts), len(want))
+	}
+	for i, ctx := range g.Contexts {
+		names := []string{}
+		for _, s := range ctx.Steps {
+			names = append(names, s.Name)
+		}
+		if ctx.Type != want[i].typ || ctx.Weight != want[i].weight ||
+			!reflect.DeepEqual(names, want[i].steps) {
+			t.Errorf("context %d is %s of weight %v with %v, want %+v", i,
+				ctx.Type, ctx.Weight, names, want[i])
+		}
+	}
+
+	values := func(vs []*PlanValue) map[string]string {
+		m := map[string]string{}
+		for _, v := range vs {
+			m[v.Name] = v.Value
+			if v.Fetch != nil {
+				m[v.Name] += " <- " + v.Source
+			}
+		}
+		return m
+	}
+
+	login := g.Contexts[0].Steps[0]
+	if login.URL != "http://api.local/login" {
+		t.Errorf("url = %s", login.URL)
+	}
+	if h := values(login.Headers); !reflect.DeepEqual(h, map[string]string{
+		"Content-Type": "application/x-www-form-urlencoded", "X-App": "test"}) {
+		t.Errorf("headers of login = %v", h)
+	}
+	if f := values(login.Form); !reflect.DeepEqual(f, map[string]string{"user": "a"}) {
+		t.Errorf("form of login = %v", f)
+	}
+	if c := values(login.Conditions); c["StatusCode"] != "200" || c["Cookie sid"] != "*" {
+		t.Errorf("conditions of login = %v", c)
+	}
+
+	items := g.Contexts[1].Steps[0]
+	if h := values(items.Headers); len(h) != 0 {
+		t.Errorf("cleared headers of items = %v", h)
+	}
+	if c := values(items.Cookies); !strings.HasPrefix(c["sid"],
+		`FromCookie("sid") <- Set-Cookie of POST /login at `) {
+		t.Errorf("cookies of items = %v", c)
+	}
+	if !reflect.DeepEqual(items.Options, []string{"CLEAR_HEADERS"}) {
+		t.Errorf("options of items = %v", items.Options)
+	}
+
+	search := g.Contexts[2].Steps[0]
+	if q := values(search.Query); !reflect.DeepEqual(q, map[string]string{"q": "a"}) {
+		t.Errorf("query of search = %v", q)
+	}
+	if !reflect.DeepEqual(search.Options, []string{"SKIP"}) {
+		t.Errorf("options of search = %v", search.Options)
+	}
+
+	// nothing caches headers of logout
+	logout := g.Contexts[3].Steps[0]
+	if h := values(logout.Headers); h["If-None-Match"] != `FromHeader("Etag") <- ` {
+		t.Errorf("headers of logout = %v", h)
+	}
+}
+
+func TestNewPlanLeavesConquest(t *testing.T) {
+	c := runTestScript(t, planScript)
+	before, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan := NewPlan(c)
+	if err := plan.Write(&bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := json.Marshal(c)
+	if !bytes.Equal(before, after) {
+		t.Errorf("conquest is changed by its plan\n%s\n%s", before, after)
+	}
+}
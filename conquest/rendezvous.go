@@ -0,0 +1,121 @@
+package conquest
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// users wait this long at a rendezvous point unless it is set
+const defaultRendezvousTimeout = 30 * time.Second
+
+// a point which holds users until Users of them have arrived, then
+// releases them together. waiting users are released anyway when Timeout
+// passes.
+type Rendezvous struct {
+	Name    string
+	Users   uint64
+	Timeout time.Duration
+}
+
+// returns the name of p in reports
+func (p *Rendezvous) name() string {
+	return "Rendezvous " + p.Name
+}
+
+// waiting users of a rendezvous point. every release starts a new round.
+type barrier struct {
+	m       sync.Mutex
+	waiting uint64
+	release chan struct{}
+	// releases by full arrival and by timeout
+	full, timedOut uint64
+}
+
+// releases the users of the current round, b.m must be held
+func (b *barrier) open() {
+	close(b.release)
+	b.release = make(chan struct{})
+	b.waiting = 0
+}
+
+// holds the caller until the round is released or ctx is done
+func (b *barrier) wait(ctx context.Context, p *Rendezvous) {
+	b.m.Lock()
+	b.waiting++
+	release := b.release
+	if b.waiting >= p.Users {
+		b.full++
+		b.open()
+		b.m.Unlock()
+		return
+	}
+	b.m.Unlock()
+
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = defaultRendezvousTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-release:
+	case <-timer.C:
+		b.m.Lock()
+		if b.release == release {
+			b.timedOut++
+			b.open()
+		}
+		b.m.Unlock()
+	case <-ctx.Done():
+		b.m.Lock()
+		if b.release == release {
+			b.waiting--
+		}
+		b.m.Unlock()
+	}
+}
+
+// rendezvous points of a run by their names
+type barriers struct {
+	m      sync.Mutex
+	points map[string]*barrier
+}
+
+func (bs *barriers) get(name string) *barrier {
+	bs.m.Lock()
+	defer bs.m.Unlock()
+
+	if bs.points == nil {
+		bs.points = map[string]*barrier{}
+	}
+	b, ok := bs.points[name]
+	if !ok {
+		b = &barrier{release: make(chan struct{})}
+		bs.points[name] = b
+	}
+	return b
+}
+
+// returns release counts of rendezvous points sorted by their names
+func (bs *barriers) stats() []*rendezvousStat {
+	bs.m.Lock()
+	defer bs.m.Unlock()
+
+	stats := []*rendezvousStat{}
+	for name, b := range bs.points {
+		b.m.Lock()
+		stats = append(stats, &rendezvousStat{
+			Name:     name,
+			Full:     b.full,
+			TimedOut: b.timedOut,
+		})
+		b.m.Unlock()
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
@@ -2,61 +2,176 @@ package conquest
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 	"time"
-	
+
 	"github.com/brsyuksel/conquest/utils"
 )
 
 const (
 	REASON_TRANSACTION = 1 << iota
 	REASON_RESPONSE
+	// the transaction was in flight when the run was stopped
+	REASON_CANCELLED
 )
 
 type Success struct {
 	Path        string
+	Group       string
 	ElapsedTime time.Duration
+	Checks      []checkResult
+	Stream      *streamResult
 }
 
 type reason struct {
 	Kind    uint8
 	Error   error
 	Request *http.Request
+	// request of a saved report
+	dump string
 }
 type Fail struct {
 	Path        string
+	Group       string
 	ElapsedTime time.Duration
 	Reason      *reason
+	Checks      []checkResult
+	Stream      *streamResult
+}
+
+// expected and actual pick rates of a transaction in random mode
+type distributionStat struct {
+	Name             string
+	Expected, Actual float64
+}
+
+// releases of a rendezvous point
+type rendezvousStat struct {
+	Name           string
+	Full, TimedOut uint64
+}
+
+// events of streams by their paths
+type streamStat struct {
+	Sessions, Events, Gaps uint64
+	// sessions which received an event
+	Started                      uint64
+	FirstEvent, GapTotal, GapMax time.Duration
+}
+
+// breakdown of a user group
+type groupStat struct {
+	Hits, Success, Fails uint64
+	ElapsedTime          time.Duration
+}
+
+// pass and fail counts of a named check
+type checkStat struct {
+	Pass, Fail uint64
 }
 
 type reportChannels struct {
-	Fail    chan *Fail
-	Success chan *Success
-	Done    chan bool
+	Fail      chan *Fail
+	Success   chan *Success
+	Done      chan bool
+	Interrupt chan bool
 }
 
 type report struct {
-	Hits        uint64
-	Success     uint64
-	Fails       uint64
+	Hits    uint64
+	Success uint64
+	Fails   uint64
+	// transactions which were in flight when the run was stopped, they
+	// are not counted as hits
+	Cancelled   uint64
 	ElapsedTime time.Duration
 	AverageTime time.Duration
 	SlowestTime time.Duration
 	FastestTime time.Duration
 	Failed      map[string][]*reason
-	Slowest     *Success
-	Fastest     *Success
-	C           *reportChannels
+	Checks      map[string]*checkStat
+	Groups      map[string]*groupStat
+	Streams     map[string]*streamStat
+	// set by Perform before the report is written
+	Distribution []*distributionStat
+	Rendezvous   []*rendezvousStat
+	Slowest      *Success
+	Fastest      *Success
+	Interrupted  bool
+	// users did not start
+	SetupFailed bool
+	C           *reportChannels `json:"-"`
+}
+
+func (r *report) countChecks(results []checkResult) {
+	for _, c := range results {
+		if _, ok := r.Checks[c.Name]; !ok {
+			r.Checks[c.Name] = &checkStat{}
+		}
+		if c.Passed {
+			r.Checks[c.Name].Pass++
+		} else {
+			r.Checks[c.Name].Fail++
+		}
+	}
+}
+
+func (r *report) countGroup(name string, success bool, e time.Duration) {
+	if name == "" {
+		return
+	}
+	if _, ok := r.Groups[name]; !ok {
+		r.Groups[name] = &groupStat{}
+	}
+
+	g := r.Groups[name]
+	g.Hits++
+	g.ElapsedTime += e
+	if success {
+		g.Success++
+	} else {
+		g.Fails++
+	}
+}
+
+func (r *report) countStream(p string, res *streamResult) {
+	if res == nil {
+		return
+	}
+	if _, ok := r.Streams[p]; !ok {
+		r.Streams[p] = &streamStat{}
+	}
+
+	st := r.Streams[p]
+	st.Sessions++
+	st.Events += res.Events
+	if res.Events > 0 {
+		st.Started++
+		st.FirstEvent += res.FirstEvent
+		st.Gaps += res.Events - 1
+	}
+	st.GapTotal += res.GapTotal
+	if res.GapMax > st.GapMax {
+		st.GapMax = res.GapMax
+	}
 }
 
-func write(r *report, f *os.File, v bool) {
+func write(r *report, f io.Writer, v bool) {
 STAT:
 	for {
 		select {
 		case f := <-r.C.Fail:
+			if f.Reason.Kind == REASON_CANCELLED {
+				r.Cancelled++
+				continue
+			}
 			r.Hits++
 			r.Fails++
 			r.ElapsedTime += f.ElapsedTime
@@ -65,11 +180,17 @@ STAT:
 				r.Failed[f.Path] = []*reason{}
 			}
 			r.Failed[f.Path] = append(r.Failed[f.Path], f.Reason)
+			r.countChecks(f.Checks)
+			r.countGroup(f.Group, false, f.ElapsedTime)
+			r.countStream(f.Path, f.Stream)
 
 		case s := <-r.C.Success:
 			r.Hits++
 			r.Success++
 			r.ElapsedTime += s.ElapsedTime
+			r.countChecks(s.Checks)
+			r.countGroup(s.Group, true, s.ElapsedTime)
+			r.countStream(s.Path, s.Stream)
 
 			if s.ElapsedTime > r.SlowestTime {
 				r.SlowestTime = s.ElapsedTime
@@ -86,14 +207,38 @@ STAT:
 			}
 		case <-r.C.Done:
 			break STAT
+		case <-r.C.Interrupt:
+			r.Interrupted = true
+			break STAT
 		}
 	}
 
-	fmt.Fprintln(f, "Summary:")
-	fmt.Fprintf(f, "Hits: %d Success: %d Fails: %d\n\n", r.Hits, r.Success, r.Fails)
+	r.Summary(f, v)
+	r.C.Done <- true
+}
+
+// Writes the summary of r, requests of failed transactions are included
+// if v is set
+func (r *report) Summary(f io.Writer, v bool) {
+	if r.Interrupted {
+		fmt.Fprintln(f, "Summary (interrupted):")
+		fmt.Fprintln(f, "Run was interrupted, only completed transactions are counted as hits.")
+	} else if r.SetupFailed {
+		fmt.Fprintln(f, "Summary (setup failed):")
+		fmt.Fprintln(f, "Setup did not complete, users were not started.")
+	} else {
+		fmt.Fprintln(f, "Summary:")
+	}
+	fmt.Fprintf(f, "Hits: %d Success: %d Fails: %d\n", r.Hits, r.Success, r.Fails)
+	if r.Cancelled > 0 {
+		fmt.Fprintf(f, "Cancelled: %d in flight when the run stopped\n", r.Cancelled)
+	}
+	fmt.Fprintln(f, "")
 	fmt.Fprintln(f, "Elapsed Time: ", utils.NS2MS(r.ElapsedTime.Nanoseconds()), " ms")
-	fmt.Fprintln(f, "Average Time: ",
-		utils.NS2MS(time.Duration(int64(r.ElapsedTime)/int64(r.Hits)).Nanoseconds()), " ms")
+	if r.Hits > 0 {
+		r.AverageTime = time.Duration(int64(r.ElapsedTime) / int64(r.Hits))
+	}
+	fmt.Fprintln(f, "Average Time: ", utils.NS2MS(r.AverageTime.Nanoseconds()), " ms")
 	fmt.Fprintln(f, "Slowest Time: ", utils.NS2MS(r.SlowestTime.Nanoseconds()), " ms")
 	fmt.Fprintln(f, "Fastest Time: ", utils.NS2MS(r.FastestTime.Nanoseconds()), " ms")
 	fmt.Fprintln(f, "")
@@ -110,6 +255,81 @@ STAT:
 		fmt.Fprintln(f, "")
 	}
 
+	if len(r.Groups) > 0 {
+		names := make([]string, 0, len(r.Groups))
+		for name := range r.Groups {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		fmt.Fprintln(f, "Groups:")
+		for _, name := range names {
+			g := r.Groups[name]
+			fmt.Fprintf(f, "\t%s: Hits: %d Success: %d Fails: %d Average Time: %v ms\n",
+				name, g.Hits, g.Success, g.Fails,
+				utils.NS2MS(int64(g.ElapsedTime)/int64(g.Hits)))
+		}
+		fmt.Fprintln(f, "")
+	}
+
+	if len(r.Streams) > 0 {
+		paths := make([]string, 0, len(r.Streams))
+		for p := range r.Streams {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+
+		fmt.Fprintln(f, "Streams:")
+		for _, p := range paths {
+			st := r.Streams[p]
+			fmt.Fprintf(f, "\t%s: Sessions: %d Events: %d\n", p, st.Sessions,
+				st.Events)
+			if st.Started > 0 {
+				fmt.Fprintf(f, "\t\tFirst Event: %v ms\n",
+					utils.NS2MS(int64(st.FirstEvent)/int64(st.Started)))
+			}
+			if st.Gaps > 0 {
+				fmt.Fprintf(f, "\t\tInter-arrival Average: %v ms Max: %v ms\n",
+					utils.NS2MS(int64(st.GapTotal)/int64(st.Gaps)),
+					utils.NS2MS(int64(st.GapMax)))
+			}
+		}
+		fmt.Fprintln(f, "")
+	}
+
+	if len(r.Rendezvous) > 0 {
+		fmt.Fprintln(f, "Rendezvous (released full / timed out):")
+		for _, p := range r.Rendezvous {
+			fmt.Fprintf(f, "\t%s: %d / %d\n", p.Name, p.Full, p.TimedOut)
+		}
+		fmt.Fprintln(f, "")
+	}
+
+	if len(r.Distribution) > 0 {
+		fmt.Fprintln(f, "Distribution (expected / actual):")
+		for _, d := range r.Distribution {
+			fmt.Fprintf(f, "\t%s: %.2f%% / %.2f%%\n", d.Name,
+				d.Expected*100, d.Actual*100)
+		}
+		fmt.Fprintln(f, "")
+	}
+
+	if len(r.Checks) > 0 {
+		names := make([]string, 0, len(r.Checks))
+		for name := range r.Checks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		fmt.Fprintln(f, "Checks:")
+		for _, name := range names {
+			c := r.Checks[name]
+			fmt.Fprintf(f, "\t%s: %d passed, %d failed (%.2f%%)\n", name,
+				c.Pass, c.Fail, float64(c.Pass)*100/float64(c.Pass+c.Fail))
+		}
+		fmt.Fprintln(f, "")
+	}
+
 	if len(r.Failed) > 0 {
 		fmt.Fprintln(f, "Failed Transactions:")
 		for path, reasons := range r.Failed {
@@ -123,12 +343,9 @@ STAT:
 					fmt.Fprintln(f, "\t\tTransaction Error: ", r.Error.Error())
 				}
 				/* FIXME: pretty print for failed request*/
-				if v {
+				if req := r.request(); v && req != "" {
 					fmt.Fprintln(f, "\t\tRequest:")
-					reqb := &bytes.Buffer{}
-					r.Request.Write(reqb)
-					reqStr := strings.Split(reqb.String(), "\n")
-					for _, line := range reqStr {
+					for _, line := range strings.Split(req, "\n") {
 						fmt.Fprintln(f, "\t\t\t"+line)
 					}
 				}
@@ -137,16 +354,70 @@ STAT:
 			fmt.Fprintln(f, "")
 		}
 	}
-	r.C.Done <- true
+}
+
+// returns the failed request of r as it is written on the wire
+func (r *reason) request() string {
+	if r.Request == nil {
+		return r.dump
+	}
+	reqb := &bytes.Buffer{}
+	r.Request.Write(reqb)
+	return reqb.String()
+}
+
+func (r *reason) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind           uint8
+		Error, Request string
+	}{
+		Kind:    r.Kind,
+		Error:   r.Error.Error(),
+		Request: r.request(),
+	})
+}
+
+func (r *reason) UnmarshalJSON(b []byte) error {
+	saved := struct {
+		Kind           uint8
+		Error, Request string
+	}{}
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return err
+	}
+	r.Kind = saved.Kind
+	r.Error = errors.New(saved.Error)
+	r.dump = saved.Request
+	return nil
+}
+
+// Writes the collected results of r as json, LoadReport reads them back
+func (r *report) Save(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// Reads results which are written by Save
+func LoadReport(rd io.Reader) (*report, error) {
+	r := &report{}
+	if err := json.NewDecoder(rd).Decode(r); err != nil {
+		return nil, err
+	}
+	return r, nil
 }
 
 func NewReporter(f *os.File, v bool) *report {
 	r := &report{
-		Failed: map[string][]*reason{},
+		Failed:  map[string][]*reason{},
+		Checks:  map[string]*checkStat{},
+		Groups:  map[string]*groupStat{},
+		Streams: map[string]*streamStat{},
 		C: &reportChannels{
-			Fail:    make(chan *Fail),
-			Success: make(chan *Success),
-			Done:    make(chan bool),
+			Fail:      make(chan *Fail),
+			Success:   make(chan *Success),
+			Done:      make(chan bool),
+			Interrupt: make(chan bool),
 		},
 	}
 
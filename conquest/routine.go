@@ -2,70 +2,154 @@ package conquest
 
 import (
 	"bytes"
+	"context"
 	"errors"
-	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"mime/multipart"
 	"net/http"
 	"net/url"
 	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 )
 
+// state of a virtual user. every crew member keeps its own cookies and
+// caching headers, so transactions of a user only see what it received.
 type mUser struct {
 	M       *sync.Mutex
+	ID      uint64
+	Group   string
 	Cookies map[string]string
 	Headers map[string]map[string]string
+	rand    *rand.Rand
+	auth    authState
+	vm      *userScript
+	// outcome of the latest transaction, if conditions are evaluated on it
+	last lastResult
+	// failed transactions so far
+	failures uint64
+	// consecutive restarts, each of them doubles the pause before the
+	// user starts over
+	restarts uint
+	// values captured by setup, read-only for users
+	shared map[string]string
 }
 
-var (
-	metaUser mUser = mUser{
+type lastResult struct {
+	// zero if no response is received
+	Status int  `json:"status"`
+	Ok     bool `json:"ok"`
+}
+
+func newUser(id uint64, group string) *mUser {
+	return &mUser{
 		M:       &sync.Mutex{},
+		ID:      id,
+		Group:   group,
 		Cookies: map[string]string{},
 		Headers: map[string]map[string]string{},
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano() + int64(id))),
+		auth: authState{
+			digests: map[string]*digestChallenge{},
+		},
 	}
-)
+}
+
+// drops the session of u, so it starts over like a new user. the vm copy
+// is kept, only its vars are dropped.
+func (u *mUser) reset() {
+	u.M.Lock()
+	defer u.M.Unlock()
+
+	u.Cookies = map[string]string{}
+	u.Headers = map[string]map[string]string{}
+	u.auth = authState{
+		digests: map[string]*digestChallenge{},
+	}
+	if u.vm != nil {
+		vars, err := u.vm.vm.Object("({})")
+		if err != nil {
+			u.vm = nil
+		} else {
+			u.vm.vars = vars.Value()
+		}
+	}
+	u.last = lastResult{}
+}
 
 // stores caching headers
-func storeHeaders(p string, h http.Header) {
-	metaUser.M.Lock()
-	defer metaUser.M.Unlock()
+func (u *mUser) storeHeaders(p string, h http.Header) {
+	u.M.Lock()
+	defer u.M.Unlock()
 
 	for name, values := range h {
 		switch name {
 		case "Etag", "Last-Modified":
-			if _, ok := metaUser.Headers[p]; !ok {
-				metaUser.Headers[p] = map[string]string{}
+			if _, ok := u.Headers[p]; !ok {
+				u.Headers[p] = map[string]string{}
 			}
 
-			metaUser.Headers[p][name] = values[0]
+			u.Headers[p][name] = values[0]
 		}
 	}
 }
 
-func storeCookies(cs []*http.Cookie) {
-	metaUser.M.Lock()
-	defer metaUser.M.Unlock()
+func (u *mUser) storeCookies(cs []*http.Cookie) {
+	u.M.Lock()
+	defer u.M.Unlock()
 
 	for _, c := range cs {
 		// delete cookie
 		if c.Value == "" {
-			delete(metaUser.Cookies, c.Name)
+			delete(u.Cookies, c.Name)
 			continue
 		}
-		metaUser.Cookies[c.Name] = c.Value
+		u.Cookies[c.Name] = c.Value
 	}
 }
 
-// routine of crew members
-type dutyRoutine func(chan<- *Success, chan<- *Fail, *sync.WaitGroup)
+// routine of crew members, returns false if the transaction failed
+type dutyRoutine func(context.Context, chan<- *Success, chan<- *Fail) bool
+
+// prepares req of t to be sent by u, whatever its protocol is: authorizes
+// it, runs before hooks of t and signs it. returns the body which is sent,
+// or errSkipped if a hook cancels the transaction.
+func prepare(ctx context.Context, c *http.Client, conquest *Conquest,
+	t *Transaction, u *mUser, req *http.Request, body []byte) ([]byte, error) {
+
+	if err := authorize(ctx, c, conquest, t, u, req); err != nil {
+		return nil, err
+	}
+	if len(t.Before) > 0 {
+		var err error
+		body, err = beforeRequest(conquest, t, u, req, body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := sign(conquest, t, req, body, time.Now()); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
 
+// builds the routine of transaction t for user u. fetches are resolved
+// against the cookies and headers which u has collected so far.
 func buildDutyRoutine(c *http.Client, conquest *Conquest,
-	t *Transaction) (dutyRoutine, error) {
+	t *Transaction, u *mUser) (dutyRoutine, error) {
+
+	targetUrl, err := conquest.resolve(t.Path)
+	if err != nil {
+		return nil, errors.New(t.Verb + " " + t.Path + " Error:" + err.Error())
+	}
+	target := targetUrl.String()
+	// name of the transaction in reports and caching headers
+	label := conquest.label(targetUrl)
+	if t.GraphQL != nil {
+		label = t.GraphQL.label(label)
+	}
 
-	target := conquest.scheme + "://" + conquest.Host + t.Path + "?"
 	body := &bytes.Buffer{}
 
 	var carrier *bytes.Buffer
@@ -73,6 +157,18 @@ func buildDutyRoutine(c *http.Client, conquest *Conquest,
 
 	switch t.Verb {
 	case "POST", "PUT", "PATCH", "DELETE":
+		if t.GraphQL != nil {
+			b, err := t.GraphQL.body()
+			if err != nil {
+				return nil, err
+			}
+			body.Write(b)
+			break
+		}
+		if t.Raw != nil {
+			body.WriteString(t.Raw.Data)
+			break
+		}
 		if t.isMultiPart {
 			mwriter := multipart.NewWriter(body)
 			boundary = mwriter.Boundary()
@@ -84,7 +180,7 @@ func buildDutyRoutine(c *http.Client, conquest *Conquest,
 				}
 
 				f := d.(*FetchNotation)
-				val, err := FetchFrom(f, t.Path, &metaUser)
+				val, err := FetchFrom(f, label, u)
 				if err != nil {
 					return nil, errors.New(t.Verb + " " + t.Path + " Error:" + err.Error())
 				}
@@ -126,12 +222,13 @@ func buildDutyRoutine(c *http.Client, conquest *Conquest,
 			}
 
 			f := d.(*FetchNotation)
-			if strKind, ok := CorrectFetch(FETCH_COOKIE|FETCH_HEADER, f); !ok {
+			if strKind, ok := CorrectFetch(
+				FETCH_COOKIE|FETCH_HEADER|FETCH_SHARED, f); !ok {
 				return nil, errors.New(strKind + " fetch can not be used with " +
 					t.Verb + " " + t.Path)
 			}
 
-			val, err := FetchFrom(f, t.Path, &metaUser)
+			val, err := FetchFrom(f, label, u)
 			if err != nil {
 				return nil, errors.New(t.Verb + " " + t.Path + " Error:" + err.Error())
 			}
@@ -144,7 +241,13 @@ func buildDutyRoutine(c *http.Client, conquest *Conquest,
 			break
 		}
 		// url values
-		target += v.Encode()
+		if len(v) > 0 {
+			if targetUrl.RawQuery != "" {
+				target += "&" + v.Encode()
+			} else {
+				target += "?" + v.Encode()
+			}
+		}
 	}
 
 	manreq, err := http.NewRequest(t.Verb, target, body)
@@ -158,6 +261,12 @@ func buildDutyRoutine(c *http.Client, conquest *Conquest,
 	if carrier != nil {
 		manreq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	}
+	if t.GraphQL != nil {
+		manreq.Header.Set("Content-Type", "application/json")
+	}
+	if t.Raw != nil && t.Raw.ContentType != "" {
+		manreq.Header.Set("Content-Type", t.Raw.ContentType)
+	}
 
 	// initial conquest headers
 	if t.ReqOptions&CLEAR_HEADERS == 0 {
@@ -173,12 +282,13 @@ func buildDutyRoutine(c *http.Client, conquest *Conquest,
 		}
 
 		f := d.(*FetchNotation)
-		if strKind, ok := CorrectFetch(FETCH_COOKIE|FETCH_HEADER, f); !ok {
+		if strKind, ok := CorrectFetch(
+			FETCH_COOKIE|FETCH_HEADER|FETCH_SHARED, f); !ok {
 			return nil, errors.New(strKind + " fetch can not be used with " +
 				t.Verb + " " + t.Path)
 		}
 
-		val, err := FetchFrom(f, t.Path, &metaUser)
+		val, err := FetchFrom(f, label, u)
 		if err != nil {
 			return nil, errors.New(t.Verb + " " + t.Path + " Error:" + err.Error())
 		}
@@ -195,7 +305,7 @@ func buildDutyRoutine(c *http.Client, conquest *Conquest,
 			manreq.AddCookie(c)
 		}
 
-		for k, v := range metaUser.Cookies {
+		for k, v := range u.Cookies {
 			c := &http.Cookie{
 				Name:  k,
 				Value: v,
@@ -211,118 +321,191 @@ func buildDutyRoutine(c *http.Client, conquest *Conquest,
 				Value: val,
 			}
 			manreq.AddCookie(c)
+			continue
 		}
 
 		f := v.(*FetchNotation)
-		if strKind, ok := CorrectFetch(FETCH_COOKIE|FETCH_HEADER, f); !ok {
+		if strKind, ok := CorrectFetch(
+			FETCH_COOKIE|FETCH_HEADER|FETCH_SHARED, f); !ok {
 			return nil, errors.New(strKind + " fetch can not be used with " +
 				t.Verb + " " + t.Path)
 		}
 
-		val, err := FetchFrom(f, t.Path, &metaUser)
+		val, err := FetchFrom(f, label, u)
 		if err != nil {
 			return nil, errors.New(t.Verb + " " + t.Path + " Error:" + err.Error())
 		}
 		manreq.AddCookie(&http.Cookie{Name: k, Value: string(val)})
 	}
 
+	if t.WS != nil {
+		return wsRoutine(c, conquest, t, u, manreq, label), nil
+	}
+	if t.GRPC != nil {
+		return grpcRoutine(c, conquest, t, u, manreq, label), nil
+	}
+
 	bodyByte := body.Bytes()
 
 	// routine func
-	routine := func(s chan<- *Success, f chan<- *Fail, d *sync.WaitGroup) {
-		defer d.Done()
+	routine := func(ctx context.Context, s chan<- *Success,
+		f chan<- *Fail) (ok bool) {
 		// recover panics and generate stats about transactions
 		defer func() {
 			if r := recover(); r != nil {
 				switch r.(type) {
 				case *Success:
+					ok = true
+					r.(*Success).Group = u.Group
 					s <- r.(*Success)
 				case *Fail:
+					r.(*Fail).Group = u.Group
 					f <- r.(*Fail)
 				}
 			}
 		}()
 
-		req, _ := http.NewRequest(t.Verb, target, bytes.NewBuffer(bodyByte))
-		req.Header = manreq.Header
+		// streams are read until their window is over
+		reqCtx := ctx
+		if t.Stream != nil {
+			var cancel context.CancelFunc
+			reqCtx, cancel = context.WithTimeout(ctx, t.Stream.window())
+			defer cancel()
+		}
 
-		start := time.Now()
-		res, err := c.Do(req)
-		elapsed := time.Since(start)
+		// only the request itself is timed, fetching a token, before hooks
+		// and signing are not part of the elapsed time of the transaction
+		var start time.Time
+		send := func() (*http.Request, *http.Response, error) {
+			start = time.Time{}
+			req, _ := http.NewRequest(t.Verb, target, bytes.NewBuffer(bodyByte))
+			req = req.WithContext(reqCtx)
+			req.Header = manreq.Header.Clone()
+
+			if _, err := prepare(ctx, c, conquest, t, u, req, bodyByte); err != nil {
+				return req, nil, err
+			}
+			start = time.Now()
+			res, err := c.Do(req)
+			return req, res, err
+		}
+
+		req, res, err := send()
+		// answer the digest challenge once, only the answered request is
+		// timed
+		if err == nil && t.Auth != nil && t.Auth.Type == AUTH_DIGEST &&
+			res.StatusCode == http.StatusUnauthorized && u.challenge(req, res) {
+			res.Body.Close()
+			req, res, err = send()
+		}
+		var elapsed time.Duration
+		if !start.IsZero() {
+			elapsed = time.Since(start)
+		}
+		u.last.Status = 0
+		if err == nil {
+			u.last.Status = res.StatusCode
+		}
 		if err != nil {
-			panic(NewFail(REASON_TRANSACTION, req.URL.Path, err, elapsed, req))
+			// cancelled transactions are counted apart from hits
+			if ctx.Err() != nil {
+				panic(NewFail(REASON_CANCELLED, label, ctx.Err(), elapsed, req))
+			}
+			if err == errSkipped {
+				return true
+			}
+			panic(NewFail(REASON_TRANSACTION, label, err, elapsed, req))
 		}
 		defer res.Body.Close()
 
 		// store caching headers
-		storeHeaders(req.URL.Path, res.Header)
+		u.storeHeaders(label, res.Header)
 
 		resCookies := res.Cookies()
 		// store cookies
 		if t.ReqOptions&REJECT_COOKIES == 0 {
-			storeCookies(resCookies)
-		}
-
-		if len(t.ResConditions) == 0 {
-			goto SUCCESS_STAT
+			u.storeCookies(resCookies)
 		}
 
-		// check response conditions
-		for k, v := range t.ResConditions {
-			switch k {
-			case "StatusCode":
-				if int64(res.StatusCode) != v.(int64) {
-					err := errors.New(
-						fmt.Sprintf(
-							"Expected status code is %d but it returned as %d.",
-							v.(int64), res.StatusCode))
-					panic(NewFail(REASON_RESPONSE, req.URL.Path, err, elapsed, req))
-				}
-			case "Header":
-				for name, val := range v.(map[string]string) {
-					h := res.Header.Get(name)
-					if h != val {
-						err := errors.New(
-							fmt.Sprintf(
-								"Expected %s header value is %s but it returned as %s.",
-								name, val, h))
-						panic(NewFail(REASON_RESPONSE, req.URL.Path, err, elapsed, req))
-					}
+		// body is read once for conditions and hooks which need it, events
+		// of a stream make up its body. failure is found while reading.
+		var resBody []byte
+		var stream *streamResult
+		var failure error
+		if t.Stream != nil {
+			stream, resBody, failure = readStream(conquest, t, u, res, start)
+			if ctx.Err() != nil {
+				panic(NewFail(REASON_CANCELLED, label, ctx.Err(), elapsed, req))
+			}
+		} else if _, contains := t.ResConditions["Contains"]; contains ||
+			t.GraphQL != nil || len(t.After) > 0 || len(t.Checks) > 0 {
+			resBody, err = ioutil.ReadAll(res.Body)
+			if err != nil {
+				if ctx.Err() != nil {
+					panic(NewFail(REASON_CANCELLED, label, ctx.Err(), elapsed, req))
 				}
-			case "Cookie":
-				eCookies := v.(map[string]string)
-				for _, cookie := range resCookies {
-					if _, ok := eCookies[cookie.Name]; !ok {
-						continue
-					}
+				panic(NewFail(REASON_TRANSACTION, label, err, elapsed, req))
+			}
+		}
 
-					if eCookies[cookie.Name] != cookie.Value {
-						err := errors.New(
-							fmt.Sprintf("Expected %s cookie value is %s but it returned as %s",
-								cookie.Name, eCookies[cookie.Name], cookie.Value))
-						panic(NewFail(REASON_RESPONSE, req.URL.Path, err, elapsed, req))
-					}
-					delete(eCookies, cookie.Name)
-				}
+		success, fail := conclude(conquest, t, u, label, req,
+			&checkedResponse{Response: res, Body: resBody, Cookies: resCookies},
+			elapsed, failure)
+		if fail != nil {
+			fail.Stream = stream
+			panic(fail)
+		}
+		success.Stream = stream
+		panic(success)
+	}
+	return routine, nil
+}
 
-				for n, _ := range eCookies {
-					err := errors.New(fmt.Sprintf("No cookie named as %s", n))
-					panic(NewFail(REASON_RESPONSE, req.URL.Path, err, elapsed, req))
-				}
-			case "Contains":
-				body, err := ioutil.ReadAll(res.Body)
-				if err != nil {
-					panic(NewFail(REASON_TRANSACTION, req.URL.Path, err, elapsed, req))
-				}
+// judges res of t by response conditions, failure, named checks and after
+// hooks in this order. returns a Fail for the first unsatisfied one,
+// otherwise a Success. failure is an error which is found while reading
+// the response, errors of a graphql result are counted as such.
+func conclude(conquest *Conquest, t *Transaction, u *mUser, label string,
+	req *http.Request, res *checkedResponse, elapsed time.Duration,
+	failure error) (*Success, *Fail) {
+
+	var hr *hookResponse
+	if len(t.Checks) > 0 || len(t.After) > 0 {
+		hr = newHookResponse(res.Response, res.Body, elapsed)
+	}
 
-				if !strings.Contains(string(body), v.(string)) {
-					err := errors.New(fmt.Sprintf("Response does not contain %s.", v.(string)))
-					panic(NewFail(REASON_RESPONSE, req.URL.Path, err, elapsed, req))
-				}
-			}
+	if t.GraphQL != nil {
+		result, err := parseGraphQL(res.Body)
+		if result != nil && hr != nil {
+			hr.Data = result.Data
+		}
+		if err != nil && failure == nil {
+			failure = err
 		}
-	SUCCESS_STAT:
-		panic(NewSuccess(req.URL.Path, elapsed))
 	}
-	return routine, nil
+
+	checks, checkErr := runChecks(conquest, t, u, hr)
+	// fails the transaction with results of named checks
+	fail := func(err error) (*Success, *Fail) {
+		f := NewFail(REASON_RESPONSE, label, err, elapsed, req)
+		f.Checks = checks
+		return nil, f
+	}
+
+	if err := checkConditions(t, res); err != nil {
+		return fail(err)
+	}
+	if failure != nil {
+		return fail(failure)
+	}
+	if checkErr != nil {
+		return fail(checkErr)
+	}
+	if err := afterResponse(conquest, t, u, hr); err != nil {
+		return fail(err)
+	}
+
+	success := NewSuccess(label, elapsed)
+	success.Checks = checks
+	return success, nil
 }
@@ -0,0 +1,60 @@
+package conquest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// raw bodies are sent as they are, objects as json
+func TestPerformRawBody(t *testing.T) {
+	var m sync.Mutex
+	got := map[string][2]string{}
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			body, _ := ioutil.ReadAll(req.Body)
+			m.Lock()
+			got[req.URL.Path] = [2]string{req.Header.Get("Content-Type"), string(body)}
+			m.Unlock()
+		}))
+	defer srv.Close()
+
+	c := runTestScript(t, `conquest.Host("`+srv.URL+`")
+.Iterations(1)
+.Users(1, function(users){
+users.Every(function(user){
+user.Do("POST", "/json").RawBody({"name": "conquest", "tags": ["load"]});
+user.Do("POST", "/xml").RawBody("<item><name>conquest</name></item>", "application/xml");
+user.Do("PUT", "/text").RawBody("a=b&c");
+user.Do("POST", "/typed").RawBody({"a": 1}, "application/merge-patch+json");
+});
+});`)
+	r := performTest(t, c)
+
+	if r.Fails != 0 || r.Success != 4 {
+		t.Errorf("%d of %d transactions failed: %v", r.Fails, r.Hits, r.Failed)
+	}
+	want := map[string][2]string{
+		"/json":  {"application/json", `{"name":"conquest","tags":["load"]}`},
+		"/xml":   {"application/xml", "<item><name>conquest</name></item>"},
+		"/text":  {"", "a=b&c"},
+		"/typed": {"application/merge-patch+json", `{"a":1}`},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("bodies = %q, want %q", got, want)
+	}
+}
+
+func TestRawBodyFails(t *testing.T) {
+	for _, src := range []string{
+		`user.Do("POST", "/a").RawBody();`,
+		`user.Do("POST", "/a").RawBody("a", "text/plain", "b");`,
+	} {
+		if _, err := RunScript(writeTestScript(t, userFlow(src))); err == nil {
+			t.Errorf("%s did not fail", src)
+		}
+	}
+}
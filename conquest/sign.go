@@ -0,0 +1,192 @@
+package conquest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SIGN_HMAC_SHA256 = "hmac-sha256"
+	SIGN_SIGV4       = "sigv4"
+)
+
+// request signing settings. an empty Scheme leaves requests unsigned.
+type SignConfig struct {
+	Scheme, KeyId, Secret string
+	// names of additional headers which are signed
+	Headers []string
+	// sigv4 credential scope
+	Region, Service string
+}
+
+// returns the signing settings of t, or nil if it is sent unsigned
+func signingOf(conquest *Conquest, t *Transaction) *SignConfig {
+	config := conquest.Sign
+	if t.Sign != nil {
+		config = t.Sign
+	}
+	if config == nil || config.Scheme == "" {
+		return nil
+	}
+	return config
+}
+
+// signs req with the signing settings of t or conquest. it is the last
+// step before sending, so body and headers are final. the signature goes
+// into the Authorization header, a request which has one already fails.
+func sign(conquest *Conquest, t *Transaction, req *http.Request,
+	body []byte, now time.Time) error {
+
+	config := signingOf(conquest, t)
+	if config == nil {
+		return nil
+	}
+	if req.Header.Get("Authorization") != "" {
+		return errors.New("Signing can not replace the Authorization header " +
+			"which is set already.")
+	}
+
+	switch config.Scheme {
+	case SIGN_HMAC_SHA256:
+		return signHMAC(config, req, body, now)
+	case SIGN_SIGV4:
+		return signV4(config, req, body, now)
+	}
+	return errors.New("Unknown signing scheme: " + config.Scheme)
+}
+
+func hashHex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// returns query of u sorted by key and value, encoded as rfc 3986.
+func canonicalQuery(u *url.URL) string {
+	q := u.Query()
+	pairs := []string{}
+	for k, vs := range q {
+		for _, v := range vs {
+			pairs = append(pairs, uriEscape(k)+"="+uriEscape(v))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func uriEscape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// returns lowercased, sorted signed header names and their canonical
+// lines. host is always signed.
+func canonicalHeaders(req *http.Request, names []string) (string, string) {
+	set := map[string]bool{"host": true}
+	for _, n := range names {
+		set[strings.ToLower(n)] = true
+	}
+
+	signed := make([]string, 0, len(set))
+	for n := range set {
+		signed = append(signed, n)
+	}
+	sort.Strings(signed)
+
+	lines := ""
+	for _, n := range signed {
+		val := req.Header.Get(n)
+		if n == "host" {
+			val = req.URL.Host
+		}
+		lines += n + ":" + strings.Join(strings.Fields(val), " ") + "\n"
+	}
+	return strings.Join(signed, ";"), lines
+}
+
+// signs method, path, sorted query, body hash, timestamp and configured
+// headers with hmac-sha256. X-Date and X-Content-Sha256 headers carry the
+// timestamp and body hash, signature goes to Authorization header as
+// HMAC-SHA256 KeyId=<id>, SignedHeaders=<names>, Signature=<hex>
+func signHMAC(config *SignConfig, req *http.Request, body []byte,
+	now time.Time) error {
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	bodyHash := hashHex(body)
+	req.Header.Set("X-Date", timestamp)
+	req.Header.Set("X-Content-Sha256", bodyHash)
+
+	signed, lines := canonicalHeaders(req, config.Headers)
+	payload := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL),
+		bodyHash,
+		timestamp,
+		lines,
+	}, "\n")
+
+	signature := hex.EncodeToString(hmacSHA256([]byte(config.Secret), payload))
+	req.Header.Set("Authorization", "HMAC-SHA256 KeyId="+config.KeyId+
+		", SignedHeaders="+signed+", Signature="+signature)
+	return nil
+}
+
+// signs req with aws signature version 4
+func signV4(config *SignConfig, req *http.Request, body []byte,
+	now time.Time) error {
+
+	if config.Region == "" || config.Service == "" {
+		return errors.New("sigv4 signing needs region and service")
+	}
+
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	bodyHash := hashHex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", bodyHash)
+
+	signed, lines := canonicalHeaders(req, append([]string{
+		"x-amz-date", "x-amz-content-sha256"}, config.Headers...))
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL),
+		lines,
+		signed,
+		bodyHash,
+	}, "\n")
+
+	scope := date + "/" + config.Region + "/" + config.Service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" +
+		hashHex([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+config.Secret), date)
+	key = hmacSHA256(key, config.Region)
+	key = hmacSHA256(key, config.Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+
+		config.KeyId+"/"+scope+", SignedHeaders="+signed+
+		", Signature="+signature)
+	return nil
+}
@@ -0,0 +1,179 @@
+package conquest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// streams are read this long unless their window is set
+const defaultStreamWindow = 10 * time.Second
+
+// a streaming response which is read for a window of time. server-sent
+// events are parsed as such, every line of other responses is an event.
+type StreamNotation struct {
+	Window time.Duration
+	// least events which the window has to receive
+	MinEvents uint64
+	Expects   []*StreamExpect
+}
+
+func (s *StreamNotation) window() time.Duration {
+	if s.Window == 0 {
+		return defaultStreamWindow
+	}
+	return s.Window
+}
+
+// an event which is expected in the window, either containing Message or
+// accepted by the registered function Fn
+type StreamExpect struct {
+	Message string
+	// -1 if Message is matched
+	Fn int
+}
+
+// event object of expect functions
+type streamEvent struct {
+	Event string `json:"event"`
+	Id    string `json:"id"`
+	Data  string `json:"data"`
+	// elapsed time since the request in milliseconds
+	Time float64 `json:"time"`
+}
+
+// timings of a stream in its window
+type streamResult struct {
+	Events     uint64
+	FirstEvent time.Duration
+	// sum and maximum of inter-arrival times
+	GapTotal, GapMax time.Duration
+}
+
+// reads events of res until the window of t is over, start is the time
+// which the request was sent at. returns timings and data of all events,
+// and an error for the first unsatisfied expectation.
+func readStream(conquest *Conquest, t *Transaction, u *mUser,
+	res *http.Response, start time.Time) (*streamResult, []byte, error) {
+
+	result := &streamResult{}
+	matched := make([]bool, len(t.Stream.Expects))
+	data := &strings.Builder{}
+	last := start
+
+	sse := strings.HasPrefix(res.Header.Get("Content-Type"),
+		"text/event-stream")
+	var hookErr error
+	dispatch := func(e *streamEvent) {
+		now := time.Now()
+		if result.Events == 0 {
+			result.FirstEvent = now.Sub(start)
+		} else {
+			gap := now.Sub(last)
+			result.GapTotal += gap
+			if gap > result.GapMax {
+				result.GapMax = gap
+			}
+		}
+		last = now
+		result.Events++
+		data.WriteString(e.Data + "\n")
+
+		e.Time = float64(now.Sub(start).Nanoseconds()) / float64(time.Millisecond)
+		for i, expect := range t.Stream.Expects {
+			if matched[i] {
+				continue
+			}
+			ok, err := expect.match(conquest, u, e)
+			if err != nil && hookErr == nil {
+				hookErr = err
+			}
+			matched[i] = ok
+		}
+	}
+
+	reader := bufio.NewReader(res.Body)
+	event := &streamEvent{}
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+
+		if line != "" || err == nil {
+			if !sse {
+				if line != "" {
+					dispatch(&streamEvent{Data: line})
+				}
+			} else if line == "" {
+				// a blank line ends the event
+				if event.Data != "" {
+					event.Data = strings.TrimSuffix(event.Data, "\n")
+					dispatch(event)
+				}
+				event = &streamEvent{Id: event.Id}
+			} else if !strings.HasPrefix(line, ":") {
+				field, val := line, ""
+				if i := strings.Index(line, ":"); i >= 0 {
+					field, val = line[:i], strings.TrimPrefix(line[i+1:], " ")
+				}
+				switch field {
+				case "event":
+					event.Event = val
+				case "data":
+					event.Data += val + "\n"
+				case "id":
+					event.Id = val
+				}
+			}
+		}
+
+		if err != nil {
+			// the window is over or server has closed the stream
+			if err != io.EOF && !errors.Is(err, context.DeadlineExceeded) {
+				return result, []byte(data.String()), err
+			}
+			break
+		}
+	}
+
+	if hookErr != nil {
+		return result, []byte(data.String()), hookErr
+	}
+	if result.Events < t.Stream.MinEvents {
+		return result, []byte(data.String()), errors.New(fmt.Sprintf(
+			"Expected %d events at least but %d received.",
+			t.Stream.MinEvents, result.Events))
+	}
+	for i, expect := range t.Stream.Expects {
+		if !matched[i] {
+			name := expect.Message
+			if expect.Fn >= 0 {
+				name = "function"
+			}
+			return result, []byte(data.String()), errors.New(
+				"No event matched " + name + ".")
+		}
+	}
+	return result, []byte(data.String()), nil
+}
+
+func (s *StreamExpect) match(conquest *Conquest, u *mUser,
+	e *streamEvent) (bool, error) {
+
+	if s.Fn < 0 {
+		return strings.Contains(e.Data, s.Message), nil
+	}
+
+	ret, _, err := u.call(conquest, s.Fn, e)
+	if err != nil {
+		return false, err
+	}
+	if !ret.IsDefined() || ret.IsNull() {
+		return false, nil
+	}
+	return ret.ToBoolean()
+}
@@ -0,0 +1,141 @@
+package conquest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadStream(t *testing.T) {
+	const sse = "text/event-stream; charset=utf-8"
+	tests := []struct {
+		name, contentType, body string
+		min                     uint64
+		expects                 []string
+		events                  uint64
+		data                    string
+		fails                   bool
+	}{
+		{
+			name:        "server-sent events",
+			contentType: sse,
+			body: "event: a\ndata: one\ndata: two\n\n: comment\n\n" +
+				"id: 7\r\ndata:three\r\n\r\n",
+			events: 2,
+			data:   "one\ntwo\nthree\n",
+		},
+		{
+			name:        "unterminated event is dropped",
+			contentType: sse,
+			body:        "data: one\n\ndata: two",
+			events:      1,
+			data:        "one\n",
+		},
+		{
+			name:        "unknown fields",
+			contentType: sse,
+			body:        "retry: 100\nfoo: bar\n\ndata: x\n\n",
+			events:      1,
+			data:        "x\n",
+		},
+		{
+			name:        "lines of other responses",
+			contentType: "application/x-ndjson",
+			body:        "{\"a\":1}\n\n{\"a\":2}\r\n{\"a\":3}",
+			events:      3,
+			data:        "{\"a\":1}\n{\"a\":2}\n{\"a\":3}\n",
+		},
+		{
+			name:        "least events",
+			contentType: sse,
+			body:        "data: one\n\n",
+			min:         2,
+			events:      1,
+			data:        "one\n",
+			fails:       true,
+		},
+		{
+			name:        "expected events",
+			contentType: sse,
+			body:        "data: {\"status\":\"queued\"}\n\ndata: {\"status\":\"done\"}\n\n",
+			expects:     []string{"queued", "done"},
+			events:      2,
+			data:        "{\"status\":\"queued\"}\n{\"status\":\"done\"}\n",
+		},
+		{
+			name:        "unmatched expectation",
+			contentType: sse,
+			body:        "data: {\"status\":\"queued\"}\n\n",
+			expects:     []string{"done"},
+			events:      1,
+			data:        "{\"status\":\"queued\"}\n",
+			fails:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := &StreamNotation{MinEvents: tt.min}
+			for _, e := range tt.expects {
+				stream.Expects = append(stream.Expects,
+					&StreamExpect{Message: e, Fn: -1})
+			}
+			res := &http.Response{
+				Header: http.Header{"Content-Type": {tt.contentType}},
+				Body:   ioutil.NopCloser(strings.NewReader(tt.body)),
+			}
+
+			result, data, err := readStream(NewConquest(),
+				&Transaction{Stream: stream}, newUser(0, ""), res, time.Now())
+			if (err != nil) != tt.fails {
+				t.Errorf("error = %v, fails %v", err, tt.fails)
+			}
+			if result.Events != tt.events {
+				t.Errorf("events = %d, want %d", result.Events, tt.events)
+			}
+			if string(data) != tt.data {
+				t.Errorf("data = %q, want %q", data, tt.data)
+			}
+			if tt.events > 1 && result.GapTotal < 0 {
+				t.Errorf("gap total = %v", result.GapTotal)
+			}
+		})
+	}
+}
+
+// stream requests are prepared like other http requests, before hooks
+// and signing apply to them
+func TestPerformStreamIsPrepared(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			headers <- req.Header
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: one\n\ndata: two\n\n"))
+		}))
+	defer srv.Close()
+
+	c := runTestScript(t, `conquest.Host("`+srv.URL+`")
+.Iterations(1)
+.Sign({"scheme": "hmac-sha256", "keyId": "id", "secret": "s"})
+.Users(1, function(users){
+users.Every(function(user){
+user.Stream("GET", "/events")
+.Before(function(req, vars){ req.headers["X-Hook"] = "1"; })
+.Events.Window("1s").Min(2);
+});
+});`)
+	r := performTest(t, c)
+
+	if r.Fails != 0 || r.Success != 1 {
+		t.Errorf("%d of %d transactions failed: %v", r.Fails, r.Hits, r.Failed)
+	}
+	h := <-headers
+	if h.Get("X-Hook") != "1" ||
+		!strings.HasPrefix(h.Get("Authorization"), "HMAC-SHA256 ") {
+		t.Errorf("stream request headers = %v", h)
+	}
+}
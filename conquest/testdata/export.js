@@ -0,0 +1,50 @@
+conquest.Host("https://shop.local")
+	.Hosts({"auth": "https://auth.local"})
+	.Headers({"X-App": "export"})
+	.Cookies({"lang": "en"})
+	.OAuth2({
+		"tokenUrl": "auth:/token", "clientId": "id", "clientSecret": "secret",
+		"scopes": ["read"]
+	})
+	.Iterations(2)
+	.Setup(function(user){
+		user.Do("POST", "/tenants").RawBody({"name": "export"});
+	})
+	.Users("shoppers", 3, function(users){
+		users.ThinkTime("500ms");
+		users.RampUp("2s");
+		users.Every(function(user){
+			user.Do("POST", "auth:/login")
+				.Auth.Basic("ana", "p'ass")
+				.Body({"remember": "yes"})
+				.Response.StatusCode(200);
+			user.Do("GET", "/items")
+				.Body({"q": "shoe", "page": "1"})
+				.SetHeader("If-None-Match", function(fetch){ return fetch.FromHeader("Etag"); })
+				.SetCookie("sid", function(fetch){ return fetch.FromCookie("sid"); })
+				.Response.Contains("items");
+			user.Do("POST", "/avatar")
+				.Body({"file": function(fetch){ return fetch.FromDisk("testdata/avatar.txt", "text/plain"); }})
+				.SetHeader("X-Tenant", function(fetch){ return fetch.FromShared("tenant"); });
+			user.GraphQL("/graphql", "query User($id: ID!) { user(id: $id) { name } }", {"id": "1"});
+			user.Repeat(2, function(user){
+				user.Do("GET", "/feed").ClearHeaders().RejectCookies();
+			});
+			user.If(function(last, vars){ return last.status == 200; }, function(user){
+				user.Do("DELETE", "/cart").Auth.Bearer("t0k3n");
+			});
+		});
+	})
+	.Users("browsers", 1, function(users){
+		users.Every(function(user){
+			user.Do("PUT", "/profile")
+				.RawBody("<name>ana</name>", "application/xml")
+				.After(function(res, vars){ vars.name = res.body; });
+			user.Rendezvous("sale", 1);
+			user.WS("/ws").Send("hello").Close();
+			user.GRPC("shop.Cart/GetCart", {});
+		});
+	})
+	.Teardown(function(user){
+		user.Do("DELETE", "/tenants").ClearInitials();
+	});
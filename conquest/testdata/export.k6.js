@@ -0,0 +1,112 @@
+// exported from conquest.js
+// shared values are read from environment variables.
+import http from "k6/http";
+import { check, sleep } from "k6";
+
+export const options = {
+  scenarios: {
+    "shoppers": {executor: "per-vu-iterations", vus: 3, iterations: 2, exec: "flow_shoppers"},
+    "browsers": {executor: "per-vu-iterations", vus: 1, iterations: 2, exec: "flow_browsers"},
+  },
+};
+
+const file0 = open("testdata/avatar.txt", "b");
+
+// cached headers of the last responses by transactions
+const cached = {};
+
+function cookie(url, name) {
+  const values = http.cookieJar().cookiesForURL(url)[name];
+  return values && values.length > 0 ? values[values.length - 1] : "";
+}
+
+function header(label, name) {
+  const headers = cached[label] || {};
+  return headers[name] || "";
+}
+
+function query(url, values) {
+  const parts = Object.keys(values).map(
+    (k) => encodeURIComponent(k) + "=" + encodeURIComponent(values[k]));
+  if (parts.length === 0) {
+    return url;
+  }
+  return url + (url.indexOf("?") < 0 ? "?" : "&") + parts.join("&");
+}
+
+function expect(res, name, status, contains) {
+  const checks = {};
+  if (status) {
+    checks[name + " status is " + status] = (r) => r.status === status;
+  }
+  if (contains) {
+    checks[name + " contains " + contains] = (r) => String(r.body).indexOf(contains) >= 0;
+  }
+  check(res, checks);
+}
+
+// client credentials token of a user
+let token = "";
+function oauth2() {
+  if (token === "") {
+    const res = http.post("https://auth.local/token", {grant_type: "client_credentials", scope: "read"}, {headers: {"Authorization": "Basic aWQ6c2VjcmV0"}});
+    token = res.json("access_token");
+  }
+  return token;
+}
+
+export function setup() {
+  let res;
+  res = http.request("POST", "https://shop.local/tenants", "{\"name\":\"export\"}", {headers: {"X-App": "export", "Authorization": "Bearer " + oauth2(), "Content-Type": "application/json"}, cookies: {"lang": "en"}});
+  cached["/tenants"] = res.headers;
+  expect(res, "POST /tenants", 0, "");
+}
+
+export function flow_shoppers() {
+  let res;
+  res = http.request("POST", "https://auth.local/login", {"remember": "yes"}, {headers: {"X-App": "export", "Authorization": "Basic YW5hOnAnYXNz"}, cookies: {"lang": "en"}});
+  cached["auth.local/login"] = res.headers;
+  expect(res, "POST auth.local/login", 200, "");
+  sleep(0.5);
+  res = http.request("GET", query("https://shop.local/items", {"page": "1", "q": "shoe"}), null, {headers: {"If-None-Match": header("/items", "Etag"), "X-App": "export", "Authorization": "Bearer " + oauth2()}, cookies: {"lang": "en", "sid": cookie("https://shop.local/items", "sid")}});
+  cached["/items"] = res.headers;
+  expect(res, "GET /items", 0, "items");
+  sleep(0.5);
+  res = http.request("POST", "https://shop.local/avatar", {"file": http.file(file0, "avatar.txt", "text/plain")}, {headers: {"X-App": "export", "X-Tenant": (__ENV["tenant"] || ""), "Authorization": "Bearer " + oauth2()}, cookies: {"lang": "en"}});
+  cached["/avatar"] = res.headers;
+  expect(res, "POST /avatar", 0, "");
+  sleep(0.5);
+  res = http.request("POST", "https://shop.local/graphql", "{\"query\":\"query User($id: ID!) { user(id: $id) { name } }\",\"variables\":{\"id\":\"1\"}}", {headers: {"X-App": "export", "Authorization": "Bearer " + oauth2(), "Content-Type": "application/json"}, cookies: {"lang": "en"}});
+  cached["/graphql"] = res.headers;
+  expect(res, "POST /graphql", 0, "");
+  sleep(0.5);
+  for (let i = 0; i < 2; i++) {
+    res = http.request("GET", "https://shop.local/feed", null, {cookies: {"lang": "en"}, jar: new http.CookieJar()});
+    cached["/feed"] = res.headers;
+    expect(res, "GET /feed", 0, "");
+    sleep(0.5);
+  }
+  // the condition of If is a script function, its transactions are performed unconditionally
+  res = http.request("DELETE", "https://shop.local/cart", null, {headers: {"X-App": "export", "Authorization": "Bearer " + "t0k3n"}, cookies: {"lang": "en"}});
+  cached["/cart"] = res.headers;
+  expect(res, "DELETE /cart", 0, "");
+  sleep(0.5);
+}
+
+export function flow_browsers() {
+  let res;
+  // script hooks and checks are not exported
+  res = http.request("PUT", "https://shop.local/profile", "\u003cname\u003eana\u003c/name\u003e", {headers: {"X-App": "export", "Authorization": "Bearer " + oauth2(), "Content-Type": "application/xml"}, cookies: {"lang": "en"}});
+  cached["/profile"] = res.headers;
+  expect(res, "PUT /profile", 0, "");
+  // rendezvous sale of 1 users is not exported
+  // websocket session /ws is not exported
+  // gRPC call /shop.Cart/GetCart is not exported
+}
+
+export function teardown() {
+  let res;
+  res = http.request("DELETE", "https://shop.local/tenants", null, {});
+  cached["/tenants"] = res.headers;
+  expect(res, "DELETE /tenants", 0, "");
+}
@@ -0,0 +1,128 @@
+#!/bin/sh
+# exported from conquest.js, every flow is performed once by one user.
+# shared values are read from SHARED_<name> environment variables.
+set -u
+
+JAR="$(mktemp)"
+HEADERS="$(mktemp -d)"
+trap 'rm -rf "$JAR" "$HEADERS"' EXIT
+
+# request STATUS CONTAINS CURL_ARGS...
+# performs a request with the cookie jar, reports unexpected responses
+request() {
+  want="$1"; contains="$2"; shift 2
+  body="$(mktemp)"
+  got="$(curl -sS -o "$body" -w '%{http_code}' "$@")"
+  if [ -n "$want" ] && [ "$got" != "$want" ]; then
+    echo "expected status $want, got $got: $*" >&2
+  fi
+  if [ -n "$contains" ] && ! grep -qF -- "$contains" "$body"; then
+    echo "response does not contain $contains: $*" >&2
+  fi
+  rm -f "$body"
+}
+
+# cookie NAME: value of a cookie in the jar
+cookie() {
+  awk -v name="$1" '$6 == name { value = $7 } END { print value }' "$JAR"
+}
+
+# header FILE NAME: cached header of the last response of a transaction
+header() {
+  grep -i "^$2:" "$HEADERS/$1" 2>/dev/null | tail -n 1 | cut -d' ' -f2- | tr -d '\r'
+}
+
+# client credentials token of requests
+TOKEN="$(curl -sS -u 'id:secret' -d grant_type=client_credentials --data-urlencode 'scope=read' 'https://auth.local/token' | sed -n 's/.*"access_token" *: *"\([^"]*\)".*/\1/p')"
+
+# setup
+request '' '' -X POST \
+  -b "$JAR" -c "$JAR" \
+  -D "$HEADERS/tenants" \
+  -H 'X-App: export' \
+  -b 'lang=en' \
+  -H "Authorization: Bearer $TOKEN" \
+  -H 'Content-Type: application/json' \
+  --data-binary '{"name":"export"}' \
+  'https://shop.local/tenants'
+
+# users of shoppers, 3 in conquest
+request 200 '' -X POST \
+  -b "$JAR" -c "$JAR" \
+  -D "$HEADERS/auth.local_login" \
+  -H 'X-App: export' \
+  -b 'lang=en' \
+  -u 'ana:p'\''ass' \
+  --data-urlencode 'remember=yes' \
+  'https://auth.local/login'
+sleep 0.5
+request '' 'items' -X GET \
+  -b "$JAR" -c "$JAR" \
+  -D "$HEADERS/items" \
+  -H 'If-None-Match: '"$(header 'items' 'Etag')" \
+  -H 'X-App: export' \
+  -b 'lang=en''; ''sid='"$(cookie 'sid')" \
+  -H "Authorization: Bearer $TOKEN" \
+  -G \
+  --data-urlencode 'page=1' \
+  --data-urlencode 'q=shoe' \
+  'https://shop.local/items'
+sleep 0.5
+request '' '' -X POST \
+  -b "$JAR" -c "$JAR" \
+  -D "$HEADERS/avatar" \
+  -H 'X-App: export' \
+  -H 'X-Tenant: '"${SHARED_tenant:-}" \
+  -b 'lang=en' \
+  -H "Authorization: Bearer $TOKEN" \
+  -F 'file=@testdata/avatar.txt;filename=avatar.txt;type=text/plain' \
+  'https://shop.local/avatar'
+sleep 0.5
+request '' '' -X POST \
+  -b "$JAR" -c "$JAR" \
+  -D "$HEADERS/graphql" \
+  -H 'X-App: export' \
+  -b 'lang=en' \
+  -H "Authorization: Bearer $TOKEN" \
+  -H 'Content-Type: application/json' \
+  --data-binary '{"query":"query User($id: ID!) { user(id: $id) { name } }","variables":{"id":"1"}}' \
+  'https://shop.local/graphql'
+sleep 0.5
+for i in $(seq 2); do
+  request '' '' -X GET \
+    -b "$JAR" \
+    -D "$HEADERS/feed" \
+    -b 'lang=en' \
+    'https://shop.local/feed'
+  sleep 0.5
+done
+# the condition of If is a script function, its transactions are performed unconditionally
+request '' '' -X DELETE \
+  -b "$JAR" -c "$JAR" \
+  -D "$HEADERS/cart" \
+  -H 'X-App: export' \
+  -b 'lang=en' \
+  -H 'Authorization: Bearer t0k3n' \
+  'https://shop.local/cart'
+sleep 0.5
+
+# users of browsers, 1 in conquest
+# script hooks and checks are not exported
+request '' '' -X PUT \
+  -b "$JAR" -c "$JAR" \
+  -D "$HEADERS/profile" \
+  -H 'X-App: export' \
+  -b 'lang=en' \
+  -H "Authorization: Bearer $TOKEN" \
+  -H 'Content-Type: application/xml' \
+  --data-binary '<name>ana</name>' \
+  'https://shop.local/profile'
+# rendezvous sale of 1 users is not exported
+# websocket session /ws is not exported
+# gRPC call /shop.Cart/GetCart is not exported
+
+# teardown
+request '' '' -X DELETE \
+  -b "$JAR" -c "$JAR" \
+  -D "$HEADERS/tenants" \
+  'https://shop.local/tenants'
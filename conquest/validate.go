@@ -0,0 +1,190 @@
+package conquest
+
+import (
+	"os"
+	"sort"
+)
+
+// a problem of a scenario which would show up while it runs, or never.
+// warnings are the ones which may not show up.
+type Problem struct {
+	Pos     Position
+	Message string
+	Warning bool
+}
+
+func (p *Problem) String() string {
+	if p.Warning {
+		return p.Pos.String() + ": warning: " + p.Message
+	}
+	return p.Pos.String() + ": " + p.Message
+}
+
+type validation struct {
+	c        *Conquest
+	problems []*Problem
+}
+
+func (v *validation) report(pos Position, t *Transaction, msg string) {
+	if t != nil {
+		msg = t.Verb + " " + t.Path + ": " + msg
+	}
+	v.problems = append(v.problems, &Problem{Pos: pos, Message: msg})
+}
+
+func (v *validation) warn(pos Position, t *Transaction, msg string) {
+	v.report(pos, t, msg)
+	v.problems[len(v.problems)-1].Warning = true
+}
+
+// checks fetch f which is used at where of t
+func (v *validation) fetch(f *FetchNotation, where string, label string,
+	t *Transaction, s *userState) {
+
+	pos := f.pos
+	if pos.Line == 0 {
+		pos = t.pos
+	}
+
+	switch f.Type {
+	case FETCH_COOKIE:
+		// any stored response may set it, only expected cookies are known
+		if _, ok := s.cookies[f.Args[0]]; ok {
+			break
+		}
+		if s.stores {
+			v.warn(pos, t, "no earlier transaction expects cookie "+f.Args[0]+
+				" which "+where+" fetches, Response.Cookie of the one which "+
+				"sets it would make it certain")
+			break
+		}
+		v.report(pos, t, "no earlier transaction sets cookie "+f.Args[0]+
+			" which "+where+" fetches")
+	case FETCH_HEADER:
+		if f.Args[0] != "Etag" && f.Args[0] != "Last-Modified" {
+			v.report(pos, t, "only Etag and Last-Modified headers are cached, "+
+				where+" fetches "+f.Args[0])
+		} else if s.source(v.c, f, label) == "" {
+			v.report(pos, t, "no earlier transaction caches headers of "+
+				label+" which "+where+" fetches")
+		}
+	case FETCH_DISK:
+		if where != "body" {
+			v.report(pos, t, "Disk fetch can not be used with "+where)
+			break
+		}
+		if finfo, err := os.Stat(f.Args[0]); err != nil {
+			v.report(pos, t, err.Error())
+		} else if finfo.IsDir() {
+			v.report(pos, t, f.Args[0]+" is not a regular file")
+		}
+		switch t.Verb {
+		case "GET", "HEAD", "OPTIONS":
+			v.report(pos, t, t.Verb+" can not contain multipart data")
+		}
+	case FETCH_SHARED:
+		if s.setup {
+			v.report(pos, t, "setup fetches shared value "+f.Args[0]+
+				", values are shared once setup is over")
+		} else if s.source(v.c, f, label) == "" {
+			v.report(pos, t, "shared value "+f.Args[0]+
+				" is never captured, there are no setup transactions")
+		}
+	}
+}
+
+// checks t as it would be performed after s, then adds what it collects
+// to s
+func (v *validation) transaction(t *Transaction, s *userState) {
+	if t.Block != nil {
+		for _, bt := range t.Block.Transactions {
+			v.transaction(bt, s)
+		}
+		return
+	}
+	if t.Rendezvous != nil {
+		return
+	}
+
+	if t.Skip {
+		for _, c := range sortedPairs(t.ResConditions) {
+			if c.Name == "GRPCStatus" {
+				continue
+			}
+			v.report(t.pos, t, "Response."+c.Name+
+				" of a skipped transaction is never checked")
+		}
+		if len(t.Checks) > 0 {
+			v.report(t.pos, t, "Response.Check of a skipped transaction is never checked")
+		}
+		return
+	}
+
+	label, err := v.c.transactionLabel(t)
+	if err != nil {
+		v.report(t.pos, t, err.Error())
+		return
+	}
+
+	if signingOf(v.c, t) != nil {
+		if by := authorizedBy(v.c, t); by != "" {
+			v.report(t.pos, t, "signing can not replace the Authorization "+
+				"header which "+by+" sets")
+		}
+	}
+
+	fetches := func(m map[string]interface{}, where string) {
+		for _, p := range sortedPairs(m) {
+			if f, ok := p.Value.(*FetchNotation); ok {
+				v.fetch(f, where+" "+p.Name, label, t, s)
+			}
+		}
+	}
+	fetches(t.Headers, "header")
+	fetches(t.Cookies, "cookie")
+	if t.Auth != nil && t.Auth.Fetch != nil {
+		v.fetch(t.Auth.Fetch, "Auth.Bearer", label, t, s)
+	}
+	for _, p := range sortedPairs(t.Body) {
+		if f, ok := p.Value.(*FetchNotation); ok {
+			v.fetch(f, "body", label, t, s)
+		}
+	}
+
+	s.collect(t, label)
+}
+
+// Walks setup, flows of groups and teardown of c and returns the problems
+// which would show up while they are performed, no requests are sent.
+// Problems are sorted by their positions in the script.
+func Validate(c *Conquest) []*Problem {
+	v := &validation{c: c}
+
+	// teardown is performed by the user of setup, it has what setup collects
+	s := newUserState()
+	s.setup = true
+	for _, t := range c.Setup {
+		v.transaction(t, s)
+	}
+	s.setup = false
+	for _, t := range c.Teardown {
+		v.transaction(t, s)
+	}
+	for _, g := range c.Groups {
+		walkGroup(c, g, func(_ *TransactionContext, t *Transaction, s *userState) {
+			v.transaction(t, s)
+		})
+	}
+
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		a, b := v.problems[i].Pos, v.problems[j].Pos
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return v.problems
+}
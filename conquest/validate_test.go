@@ -0,0 +1,186 @@
+package conquest
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writes src as a conquest.js of a temporary directory, returns its path
+func writeTestScript(t *testing.T, src string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "conquest.js")
+	if err := ioutil.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// runs src as a conquest.js of a temporary directory
+func runTestScript(t *testing.T, src string) *Conquest {
+	t.Helper()
+	c, err := RunScript(writeTestScript(t, src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// returns src as the flow of a user of a conquest on api.local, statements
+// start at line 3
+func userFlow(src string) string {
+	return "conquest.Host(\"http://api.local\")\n" +
+		".Users(1, function(users){ users.Every(function(user){\n" +
+		src + "\n});});\n"
+}
+
+func TestValidate(t *testing.T) {
+	type want struct {
+		line    int
+		warning bool
+		message string
+	}
+	tests := []struct {
+		name, src string
+		problems  []want
+	}{
+		{
+			"expected cookie",
+			`user.Do("GET", "/login").Response.Cookie("sid", "*");
+user.Do("GET", "/me").SetCookie("sid", function(fetch){ return fetch.FromCookie("sid"); });`,
+			nil,
+		},
+		{
+			"stored cookie",
+			`user.Do("GET", "/login");
+user.Do("GET", "/me").SetCookie("sid", function(fetch){ return fetch.FromCookie("sid"); });`,
+			[]want{{4, true, "GET /me: no earlier transaction expects cookie sid " +
+				"which cookie sid fetches, Response.Cookie of the one which sets " +
+				"it would make it certain"}},
+		},
+		{
+			"cookie of nothing",
+			`user.Do("GET", "/login").RejectCookies();
+user.Do("POST", "/me").Body({"sid": function(fetch){ return fetch.FromCookie("sid"); }});`,
+			[]want{{4, false, "POST /me: no earlier transaction sets cookie sid " +
+				"which body fetches"}},
+		},
+		{
+			"headers",
+			`user.Do("GET", "/a").SetHeader("If-None-Match", function(fetch){ return fetch.FromHeader("Etag"); });
+user.Do("GET", "/a").SetHeader("X-Id", function(fetch){ return fetch.FromHeader("X-Id"); });
+user.Do("GET", "/a").SetHeader("If-None-Match", function(fetch){ return fetch.FromHeader("Etag"); });`,
+			[]want{
+				{3, false, "GET /a: no earlier transaction caches headers of " +
+					"/a which header If-None-Match fetches"},
+				{4, false, "GET /a: only Etag and Last-Modified headers are " +
+					"cached, header X-Id fetches X-Id"},
+			},
+		},
+		{
+			"skipped",
+			`user.Do("GET", "/a").Skip().Response.StatusCode(200);`,
+			[]want{{3, false, "GET /a: Response.StatusCode of a skipped " +
+				"transaction is never checked"}},
+		},
+		{
+			"shared",
+			`user.Do("GET", "/a").SetHeader("X-Tenant", function(fetch){ return fetch.FromShared("tenant"); });`,
+			[]want{{3, false, "GET /a: shared value tenant is never " +
+				"captured, there are no setup transactions"}},
+		},
+		{
+			"signed",
+			`user.Do("GET", "/a").Sign({"scheme": "hmac-sha256", "keyId": "id", "secret": "s"}).Auth.Basic("u", "p");
+user.Do("GET", "/b").Sign({"scheme": "hmac-sha256", "keyId": "id", "secret": "s"}).SetHeader("Authorization", "Bearer t");
+user.Do("GET", "/c").Sign({"scheme": "hmac-sha256", "keyId": "id", "secret": "s"});`,
+			[]want{
+				{3, false, "GET /a: signing can not replace the Authorization " +
+					"header which Auth.Basic sets"},
+				{4, false, "GET /b: signing can not replace the Authorization " +
+					"header which header Authorization sets"},
+			},
+		},
+		{
+			"disk",
+			`user.Do("GET", "/a").SetHeader("X-File", function(fetch){ return fetch.FromDisk("conquest.js"); });`,
+			[]want{{3, false, "GET /a: Disk fetch can not be used with " +
+				"header X-File"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := runTestScript(t, userFlow(tt.src))
+			problems := Validate(c)
+			if len(problems) != len(tt.problems) {
+				for _, p := range problems {
+					t.Log(p)
+				}
+				t.Fatalf("%d problems, want %d", len(problems), len(tt.problems))
+			}
+			for i, p := range problems {
+				w := tt.problems[i]
+				if p.Pos.Line != w.line || p.Warning != w.warning ||
+					p.Message != w.message {
+					t.Errorf("problem %s (warning %v), want %d: %s (warning %v)",
+						p, p.Warning, w.line, w.message, w.warning)
+				}
+				if !strings.HasSuffix(p.Pos.File, "conquest.js") {
+					t.Errorf("problem is not in the script: %s", p)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSetup(t *testing.T) {
+	c := runTestScript(t, `conquest.Host("http://api.local")
+.Setup(function(user){ user.Do("POST", "/tenants"); })
+.Users(1, function(users){ users.Every(function(user){
+user.Do("GET", "/a").SetHeader("X-Tenant", function(fetch){ return fetch.FromShared("tenant"); });
+});});`)
+	if problems := Validate(c); len(problems) != 0 {
+		t.Errorf("problems: %v", problems)
+	}
+}
+
+func TestValidateSharedInSetup(t *testing.T) {
+	c := runTestScript(t, `conquest.Host("http://api.local")
+.Setup(function(user){
+user.Do("POST", "/tenants");
+user.Do("GET", "/a").SetHeader("X-Tenant", function(fetch){ return fetch.FromShared("tenant"); });
+})
+.Users(1, function(users){ users.Every(function(user){ user.Do("GET", "/b"); }); });`)
+
+	problems := Validate(c)
+	if len(problems) != 1 || problems[0].Pos.Line != 4 || problems[0].Message !=
+		"GET /a: setup fetches shared value tenant, values are shared once setup is over" {
+		t.Errorf("problems: %v", problems)
+	}
+}
+
+// setup and teardown are performed by the same user
+func TestValidateTeardownAfterSetup(t *testing.T) {
+	c := runTestScript(t, `conquest.Host("http://api.local")
+.Setup(function(user){
+user.Do("POST", "/login").Response.Cookie("sid", "*");
+})
+.Teardown(function(user){
+user.Do("POST", "/logout").SetCookie("sid", function(fetch){ return fetch.FromCookie("sid"); });
+user.Do("DELETE", "/tenants").SetCookie("csrf", function(fetch){ return fetch.FromCookie("csrf"); });
+})
+.Users(1, function(users){ users.Every(function(user){ user.Do("GET", "/b"); }); });`)
+
+	problems := Validate(c)
+	if len(problems) != 1 || problems[0].Pos.Line != 7 || !problems[0].Warning {
+		t.Errorf("problems: %v", problems)
+	}
+
+	plan := NewPlan(c)
+	if src := plan.Teardown[0].Cookies[0].Source; !strings.HasPrefix(src,
+		"Set-Cookie of POST /login at ") {
+		t.Errorf("source of the teardown cookie = %q", src)
+	}
+}
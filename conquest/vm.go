@@ -27,6 +27,10 @@ func RunScript(file string) (conquest *Conquest, err error) {
 		return
 	}
 
+	if _, err = vm.Run("var " + scriptFns + " = [];"); err != nil {
+		return
+	}
+
 	script, err := vm.Compile(file, nil)
 	if err != nil {
 		return
@@ -37,5 +41,6 @@ func RunScript(file string) (conquest *Conquest, err error) {
 		return
 	}
 	conquest = conjs.conquest
+	conquest.vm = vm
 	return
 }
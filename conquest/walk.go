@@ -0,0 +1,143 @@
+package conquest
+
+// what a user may have collected before a transaction is performed
+type userState struct {
+	// an earlier transaction stores cookies of its response
+	stores bool
+	// earlier transactions which expect cookies in their responses
+	cookies map[string]*Transaction
+	// last earlier transactions which cache headers of labels
+	labels map[string]*Transaction
+	// transactions are of the setup phase, values are shared once it is over
+	setup bool
+}
+
+func newUserState() *userState {
+	return &userState{
+		cookies: map[string]*Transaction{},
+		labels:  map[string]*Transaction{},
+	}
+}
+
+func (s *userState) copy() *userState {
+	c := newUserState()
+	c.merge(s)
+	return c
+}
+
+// adds what o may have collected to s
+func (s *userState) merge(o *userState) {
+	s.stores = s.stores || o.stores
+	for k, t := range o.cookies {
+		if _, ok := s.cookies[k]; !ok {
+			s.cookies[k] = t
+		}
+	}
+	for k, t := range o.labels {
+		if _, ok := s.labels[k]; !ok {
+			s.labels[k] = t
+		}
+	}
+}
+
+// adds what performing t of label collects to s
+func (s *userState) collect(t *Transaction, label string) {
+	if t.GRPC != nil {
+		return
+	}
+	if t.ReqOptions&REJECT_COOKIES == 0 {
+		s.stores = true
+		if expected, ok := t.ResConditions["Cookie"].(map[string]string); ok {
+			for name := range expected {
+				s.cookies[name] = t
+			}
+		}
+	}
+	if t.WS == nil {
+		s.labels[label] = t
+	}
+}
+
+// returns what f gets its value from when it is used by a transaction of
+// label, empty if nothing provides it
+func (s *userState) source(c *Conquest, f *FetchNotation, label string) string {
+	switch f.Type {
+	case FETCH_COOKIE:
+		if t, ok := s.cookies[f.Args[0]]; ok {
+			return "Set-Cookie of " + c.transactionName(t) + " at " + t.pos.String()
+		}
+		if s.stores {
+			return "cookies of earlier responses"
+		}
+	case FETCH_HEADER:
+		// only caching headers are stored
+		if f.Args[0] != "Etag" && f.Args[0] != "Last-Modified" {
+			break
+		}
+		if t, ok := s.labels[label]; ok {
+			return f.Args[0] + " of " + c.transactionName(t) + " at " + t.pos.String()
+		}
+	case FETCH_DISK:
+		return "file " + f.Args[0]
+	case FETCH_SHARED:
+		if len(c.Setup) > 0 && !s.setup {
+			return "vars captured by setup"
+		}
+	}
+	return ""
+}
+
+// returns the label of t which reports and cached headers use
+func (c *Conquest) transactionLabel(t *Transaction) (string, error) {
+	u, err := c.resolve(t.Path)
+	if err != nil {
+		return "", err
+	}
+	label := c.label(u)
+	if t.GraphQL != nil {
+		label = t.GraphQL.label(label)
+	}
+	return label, nil
+}
+
+// returns the name of t in plans and problems
+func (c *Conquest) transactionName(t *Transaction) string {
+	label, err := c.transactionLabel(t)
+	if err != nil {
+		label = t.Path
+	}
+	return t.Verb + " " + label
+}
+
+// walks transactions of the flow of g in the order a user performs them.
+// visit gets what the user may have collected before each of them and
+// adds what the transaction collects. then contexts of random mode follow
+// every contexts in any order, so each of their transactions is visited
+// after the every contexts only.
+func walkGroup(c *Conquest, g *Group,
+	visit func(*TransactionContext, *Transaction, *userState)) {
+
+	s := newUserState()
+	for ctx := g.Track; ctx != nil; ctx = ctx.Next {
+		if ctx.CtxType != CTX_THEN || c.Sequential {
+			for _, t := range ctx.Transactions {
+				visit(ctx, t, s)
+			}
+			continue
+		}
+
+		base, merged := s, s.copy()
+		for {
+			for _, t := range ctx.Transactions {
+				picked := base.copy()
+				visit(ctx, t, picked)
+				merged.merge(picked)
+			}
+			if ctx.Next == nil || ctx.Next.CtxType != CTX_THEN {
+				break
+			}
+			ctx = ctx.Next
+		}
+		s = merged
+	}
+}
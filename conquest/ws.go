@@ -0,0 +1,254 @@
+package conquest
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	WS_SEND uint8 = 1 << iota
+	WS_EXPECT
+	WS_CLOSE
+)
+
+// steps wait this long unless their timeout is set
+const defaultWSTimeout = 10 * time.Second
+
+// a websocket session, steps are performed on one connection in order
+type WSNotation struct {
+	Steps []*WSStep
+}
+
+type WSStep struct {
+	Type uint8
+	// message to send, or the substring which an expected message contains
+	Message string
+	// index of the registered match function of WS_EXPECT, -1 if Message
+	// is matched
+	Fn      int
+	Timeout time.Duration
+}
+
+func (s *WSStep) timeout() time.Duration {
+	if s.Timeout == 0 {
+		return defaultWSTimeout
+	}
+	return s.Timeout
+}
+
+// returns the name of s in reports, after the path of its session
+func (s *WSStep) name() string {
+	switch s.Type {
+	case WS_SEND:
+		return "send"
+	case WS_EXPECT:
+		return "expect"
+	}
+	return "close"
+}
+
+// headers which the dialer sets itself, it refuses to dial with them
+var wsDialerHeaders = []string{"Connection", "Upgrade", "Sec-Websocket-Key",
+	"Sec-Websocket-Version", "Sec-Websocket-Extensions",
+	"Sec-Websocket-Protocol"}
+
+// returns the handshake headers of h without the ones which the dialer
+// sets itself, and the subprotocols which h asks for
+func wsHandshake(h http.Header) (http.Header, []string) {
+	header := h.Clone()
+	for _, name := range wsDialerHeaders {
+		header.Del(name)
+	}
+
+	protocols := []string{}
+	for _, v := range h.Values("Sec-Websocket-Protocol") {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				protocols = append(protocols, p)
+			}
+		}
+	}
+	return header, protocols
+}
+
+// message object of expect functions
+type wsMessage struct {
+	Data string `json:"data"`
+	// elapsed time since the step began in milliseconds
+	Time float64 `json:"time"`
+}
+
+// builds the routine of a websocket transaction. handshake goes with the
+// headers and cookies of manreq, and it is prepared like an http request.
+// connecting and every step are reported as separate transactions.
+func wsRoutine(c *http.Client, conquest *Conquest, t *Transaction, u *mUser,
+	manreq *http.Request, label string) dutyRoutine {
+
+	return func(ctx context.Context, s chan<- *Success,
+		f chan<- *Fail) bool {
+
+		req := manreq.Clone(ctx)
+		report := func(kind uint8, name string, elapsed time.Duration,
+			err error) bool {
+			if err != nil {
+				fl := NewFail(kind, label+" "+name, err, elapsed, req)
+				fl.Group = u.Group
+				f <- fl
+				return false
+			}
+			sc := NewSuccess(label+" "+name, elapsed)
+			sc.Group = u.Group
+			s <- sc
+			return true
+		}
+
+		if _, err := prepare(ctx, c, conquest, t, u, req, nil); err != nil {
+			if err == errSkipped {
+				return true
+			}
+			return report(REASON_TRANSACTION, "connect", 0, err)
+		}
+
+		target := *req.URL
+		target.Scheme = "ws"
+		if req.URL.Scheme == "https" {
+			target.Scheme = "wss"
+		}
+
+		header, protocols := wsHandshake(req.Header)
+		dialer := &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			TLSClientConfig:  &tls.Config{InsecureSkipVerify: true},
+			HandshakeTimeout: defaultWSTimeout,
+			Subprotocols:     protocols,
+		}
+
+		start := time.Now()
+		conn, res, err := dialer.DialContext(ctx, target.String(), header)
+		elapsed := time.Since(start)
+		u.last.Status = 0
+		if res != nil {
+			u.last.Status = res.StatusCode
+			if t.ReqOptions&REJECT_COOKIES == 0 {
+				u.storeCookies(res.Cookies())
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return report(REASON_CANCELLED, "connect", elapsed, ctx.Err())
+			}
+			return report(REASON_TRANSACTION, "connect", elapsed, err)
+		}
+		defer conn.Close()
+		report(0, "connect", elapsed, nil)
+
+		// blocked reads and writes return when the run is cancelled
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				conn.Close()
+			case <-stop:
+			}
+		}()
+
+		for _, step := range t.WS.Steps {
+			start := time.Now()
+			kind, err := wsStep(conquest, u, conn, step)
+			if ctx.Err() != nil {
+				return report(REASON_CANCELLED, step.name(), time.Since(start),
+					ctx.Err())
+			}
+			if !report(kind, step.name(), time.Since(start), err) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// performs step on conn, returns the reason kind of its error
+func wsStep(conquest *Conquest, u *mUser, conn *websocket.Conn,
+	step *WSStep) (uint8, error) {
+
+	deadline := time.Now().Add(step.timeout())
+	switch step.Type {
+	case WS_SEND:
+		conn.SetWriteDeadline(deadline)
+		return REASON_TRANSACTION, conn.WriteMessage(websocket.TextMessage,
+			[]byte(step.Message))
+
+	case WS_EXPECT:
+		conn.SetReadDeadline(deadline)
+		start := time.Now()
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					return REASON_RESPONSE, errors.New("No message matched in " +
+						step.timeout().String() + ".")
+				}
+				return REASON_RESPONSE, err
+			}
+
+			matched, err := wsMatch(conquest, u, step, msg, time.Since(start))
+			if err != nil {
+				return REASON_RESPONSE, err
+			}
+			if matched {
+				return 0, nil
+			}
+		}
+
+	case WS_CLOSE:
+		err := conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			deadline)
+		if err != nil {
+			return REASON_TRANSACTION, err
+		}
+
+		// waits for the close frame of server, messages before it are dropped
+		conn.SetReadDeadline(deadline)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					return REASON_RESPONSE, errors.New(
+						"Server did not close the connection.")
+				}
+				return 0, nil
+			}
+		}
+	}
+	return REASON_TRANSACTION, errors.New("Unknown websocket step.")
+}
+
+// returns true if msg contains the message of step or its match function
+// returns a truthy value
+func wsMatch(conquest *Conquest, u *mUser, step *WSStep, msg []byte,
+	elapsed time.Duration) (bool, error) {
+
+	if step.Fn < 0 {
+		return strings.Contains(string(msg), step.Message), nil
+	}
+
+	ret, _, err := u.call(conquest, step.Fn, &wsMessage{
+		Data: string(msg),
+		Time: float64(elapsed.Nanoseconds()) / float64(time.Millisecond),
+	})
+	if err != nil {
+		return false, err
+	}
+	if !ret.IsDefined() || ret.IsNull() {
+		return false, nil
+	}
+	return ret.ToBoolean()
+}
@@ -0,0 +1,111 @@
+package conquest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// echoes messages of a websocket session, handshake headers are passed to
+// check before upgrading
+func wsEchoServer(check func(*http.Request)) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			check(req)
+			conn, err := upgrader.Upgrade(w, req, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			for {
+				kind, msg, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				if err := conn.WriteMessage(kind, msg); err != nil {
+					return
+				}
+			}
+		}))
+}
+
+// handshakes are prepared like http requests, they are signed too
+func TestWSHandshakeIsSigned(t *testing.T) {
+	authorizations := make(chan string, 1)
+	srv := wsEchoServer(func(req *http.Request) {
+		authorizations <- req.Header.Get("Authorization")
+	})
+	defer srv.Close()
+
+	c := runTestScript(t, `conquest.Host("`+srv.URL+`")
+.Iterations(1)
+.Sign({"scheme": "hmac-sha256", "keyId": "id", "secret": "s"})
+.Users(1, function(users){
+users.Every(function(user){
+user.WS("/ws").Send("hello").Expect("hello", "1s").Close();
+});
+});`)
+	r := performTest(t, c)
+
+	if r.Fails != 0 {
+		t.Errorf("%d transactions failed: %v", r.Fails, r.Failed)
+	}
+	if a := <-authorizations; !strings.HasPrefix(a, "HMAC-SHA256 ") {
+		t.Errorf("handshake Authorization = %q", a)
+	}
+}
+
+func TestWSHandshake(t *testing.T) {
+	h := http.Header{}
+	h.Set("Sec-Websocket-Key", "k")
+	h.Set("Upgrade", "websocket")
+	h.Set("X-Token", "t")
+	h.Add("Sec-Websocket-Protocol", "v2, v1")
+	h.Add("Sec-Websocket-Protocol", " ,chat")
+
+	header, protocols := wsHandshake(h)
+	if !reflect.DeepEqual(header, http.Header{"X-Token": {"t"}}) {
+		t.Errorf("header = %v", header)
+	}
+	if !reflect.DeepEqual(protocols, []string{"v2", "v1", "chat"}) {
+		t.Errorf("protocols = %q", protocols)
+	}
+}
+
+// steps run on one connection in order and are reported apart, the
+// session stops at the first failed step
+func TestPerformWSSteps(t *testing.T) {
+	srv := wsEchoServer(func(*http.Request) {})
+	defer srv.Close()
+
+	c := runTestScript(t, `conquest.Host("`+srv.URL+`")
+.Iterations(1)
+.Users(1, function(users){
+users.Every(function(user){
+user.WS("/ok")
+.Send({"type": "subscribe"})
+.Expect(function(msg, vars){ return JSON.parse(msg.data).type == "subscribe" && msg.time >= 0; })
+.Send("hello")
+.Expect("hello", "1s")
+.Close();
+user.WS("/timeout")
+.Send("ping")
+.Expect("pong", "200ms")
+.Close();
+});
+});`)
+	r := performTest(t, c)
+
+	if r.Success != 8 || r.Fails != 1 {
+		t.Errorf("%d succeeded, %d failed: %v", r.Success, r.Fails, r.Failed)
+	}
+	fails := r.Failed["/timeout expect"]
+	if len(fails) != 1 || fails[0].Error.Error() != "No message matched in 200ms." {
+		t.Errorf("failures = %v", r.Failed)
+	}
+}
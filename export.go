@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/brsyuksel/conquest/conquest"
+)
+
+const exportUsage = "usage: conquest export -format curl|k6|postman [flags] [conquest.js]"
+
+// writes the scenario of a conquest.js in another format to stdout
+func exportScript(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	script := newScriptFlags(fs)
+	format := fs.String("format", "", "curl, k6 or postman")
+	script.parse(args)
+	if *format == "" {
+		return errors.New(exportUsage)
+	}
+
+	conq, err := script.load()
+	if err != nil {
+		return err
+	}
+	return conquest.Export(os.Stdout, conq, *format)
+}
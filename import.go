@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+
+	"github.com/brsyuksel/conquest/importer"
+)
+
+const importUsage = `usage: conquest import har [-drop-static] recording.har
+       conquest import openapi spec.yaml
+       conquest import curl commands.sh
+       conquest import postman [-env environment.json] collection.json
+
+a file of - is read from stdin`
+
+// converts the file in args into a conquest.js which is written to stdout
+func importScript(args []string) error {
+	if len(args) == 0 {
+		return errors.New(importUsage)
+	}
+
+	fs := flag.NewFlagSet("import "+args[0], flag.ExitOnError)
+	var convert func(f *os.File) (*importer.Script, error)
+
+	switch args[0] {
+	case "har":
+		dropStatic := fs.Bool("drop-static", false,
+			"leave out images, styles, scripts, fonts and media")
+		convert = func(f *os.File) (*importer.Script, error) {
+			return importer.HAR(f, *dropStatic)
+		}
+	case "openapi":
+		convert = func(f *os.File) (*importer.Script, error) {
+			return importer.OpenAPI(f)
+		}
+	case "curl":
+		convert = func(f *os.File) (*importer.Script, error) {
+			return importer.Curl(f)
+		}
+	case "postman":
+		envfile := fs.String("env", "", "postman environment file")
+		convert = func(f *os.File) (*importer.Script, error) {
+			if *envfile == "" {
+				return importer.Postman(f, nil)
+			}
+			env, err := os.Open(*envfile)
+			if err != nil {
+				return nil, err
+			}
+			defer env.Close()
+			return importer.Postman(f, env)
+		}
+	default:
+		return errors.New(importUsage)
+	}
+
+	files := parseFlags(fs, args[1:])
+	if len(files) != 1 {
+		return errors.New(importUsage)
+	}
+
+	f, name := os.Stdin, "stdin"
+	if files[0] != "-" {
+		var err error
+		f, err = os.Open(files[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		name = filepath.Base(files[0])
+	}
+
+	script, err := convert(f)
+	if err != nil {
+		return err
+	}
+	script.Source = "imported from " + name
+	return script.Write(os.Stdout)
+}
@@ -0,0 +1,467 @@
+package importer
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// splits shell text into commands of words. quotes, escapes and line
+// continuations are handled like sh does, commands end at unquoted
+// newlines, semicolons, pipes and && or ||.
+func shellWords(text string) ([][]string, error) {
+	cmds := [][]string{}
+	words := []string{}
+	word := &strings.Builder{}
+	inWord := false
+
+	endWord := func() {
+		if inWord {
+			words = append(words, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+	endCommand := func() {
+		endWord()
+		if len(words) > 0 {
+			cmds = append(cmds, words)
+			words = []string{}
+		}
+	}
+
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case c == '\\':
+			if i+1 < len(runes) {
+				i++
+				// a line continuation
+				if runes[i] == '\n' || (runes[i] == '\r' &&
+					i+1 < len(runes) && runes[i+1] == '\n') {
+					if runes[i] == '\r' {
+						i++
+					}
+					continue
+				}
+				word.WriteRune(runes[i])
+				inWord = true
+			}
+
+		case c == '\'':
+			j := i + 1
+			for j < len(runes) && runes[j] != '\'' {
+				j++
+			}
+			if j == len(runes) {
+				return nil, errors.New("Unterminated quote in curl command.")
+			}
+			word.WriteString(string(runes[i+1 : j]))
+			inWord = true
+			i = j
+
+		case c == '$' && i+1 < len(runes) && runes[i+1] == '\'':
+			// ansi-c quoting which browsers use when they copy as curl
+			i += 2
+			for ; i < len(runes) && runes[i] != '\''; i++ {
+				if runes[i] != '\\' || i+1 == len(runes) {
+					word.WriteRune(runes[i])
+					continue
+				}
+				i++
+				switch runes[i] {
+				case 'n':
+					word.WriteRune('\n')
+				case 't':
+					word.WriteRune('\t')
+				case 'r':
+					word.WriteRune('\r')
+				default:
+					word.WriteRune(runes[i])
+				}
+			}
+			if i == len(runes) {
+				return nil, errors.New("Unterminated quote in curl command.")
+			}
+			inWord = true
+
+		case c == '"':
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) &&
+					strings.ContainsRune("$`\"\\\n", runes[i+1]) {
+					i++
+					if runes[i] == '\n' {
+						continue
+					}
+				}
+				word.WriteRune(runes[i])
+			}
+			if i == len(runes) {
+				return nil, errors.New("Unterminated quote in curl command.")
+			}
+			inWord = true
+
+		case c == '#' && !inWord:
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			endCommand()
+
+		case c == ' ' || c == '\t' || c == '\r':
+			endWord()
+
+		case c == '\n' || c == ';' || c == '|' || c == '&':
+			if (c == '|' || c == '&') && i+1 < len(runes) && runes[i+1] == c {
+				i++
+			}
+			endCommand()
+
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	endCommand()
+	return cmds, nil
+}
+
+// canonical names of curl options which are imported
+var curlOptions = map[string]string{
+	"-X": "-X", "--request": "-X",
+	"-H": "-H", "--header": "-H",
+	"-d": "-d", "--data": "-d", "--data-ascii": "-d", "--data-binary": "-d",
+	"--data-raw":       "--data-raw",
+	"--data-urlencode": "--data-urlencode",
+	"--json":           "--json",
+	"-F":               "-F", "--form": "-F",
+	"--form-string": "--form-string",
+	"-b":            "-b", "--cookie": "-b",
+	"-u": "-u", "--user": "-u",
+	"-A": "-A", "--user-agent": "-A",
+	"-e": "-e", "--referer": "-e",
+	"--oauth2-bearer": "--oauth2-bearer",
+	"--url":           "--url",
+	"-G":              "-G", "--get": "-G",
+	"-I": "-I", "--head": "-I",
+}
+
+// options which take a value but are not imported
+var curlIgnoredValues = map[string]bool{
+	"-o": true, "--output": true, "-m": true, "--max-time": true,
+	"--connect-timeout": true, "--retry": true, "--retry-delay": true,
+	"--retry-max-time": true, "-w": true, "--write-out": true, "-x": true,
+	"--proxy": true, "-U": true, "--proxy-user": true, "--cacert": true,
+	"--capath": true, "-E": true, "--cert": true, "--cert-type": true,
+	"--key": true, "--key-type": true, "--pass": true, "-c": true,
+	"--cookie-jar": true, "-T": true, "--upload-file": true, "-r": true,
+	"--range": true, "-K": true, "--config": true, "--resolve": true,
+	"--connect-to": true, "--limit-rate": true, "--max-redirs": true,
+	"-y": true, "--speed-time": true, "-Y": true, "--speed-limit": true,
+	"--interface": true, "--dns-servers": true, "--ciphers": true,
+	"--tls-max": true, "-D": true, "--dump-header": true, "--trace": true,
+	"--trace-ascii": true, "--stderr": true, "-C": true,
+	"--continue-at": true, "--local-port": true, "-z": true,
+	"--time-cond": true, "--unix-socket": true, "--aws-sigv4": true,
+}
+
+// returns true if option o of curl takes a value
+func curlTakesValue(o string) bool {
+	if curlIgnoredValues[o] {
+		return true
+	}
+	name, ok := curlOptions[o]
+	return ok && name != "-G" && name != "-I"
+}
+
+// headers which the http client sets itself
+var curlSkippedHeaders = map[string]bool{
+	"host":            true,
+	"content-length":  true,
+	"accept-encoding": true,
+	"connection":      true,
+}
+
+// a curl command which is being converted
+type curlCommand struct {
+	method, rawURL, contentType string
+	get, head, json             bool
+	headers, cookies, form      []Pair
+	auth                        *Auth
+	data                        []string
+	notes                       []string
+}
+
+func (c *curlCommand) apply(name, val string) {
+	switch curlOptions[name] {
+	case "-X":
+		c.method = strings.ToUpper(val)
+	case "-H":
+		i := strings.Index(val, ":")
+		if i <= 0 {
+			return
+		}
+		k, v := strings.TrimSpace(val[:i]), strings.TrimSpace(val[i+1:])
+		switch lk := strings.ToLower(k); {
+		case lk == "cookie":
+			c.apply("-b", v)
+		case lk == "content-type":
+			c.contentType = v
+		case !curlSkippedHeaders[lk]:
+			c.headers = append(c.headers, Pair{k, Literal(v)})
+		}
+	case "-d":
+		if strings.HasPrefix(val, "@") {
+			c.notes = append(c.notes, "curl reads the body from "+val[1:]+
+				", it is not imported.")
+			return
+		}
+		c.data = append(c.data, val)
+	case "--data-raw":
+		c.data = append(c.data, val)
+	case "--json":
+		c.json = true
+		c.data = append(c.data, val)
+	case "--data-urlencode":
+		i := strings.IndexAny(val, "=@")
+		switch {
+		case i < 0:
+			c.data = append(c.data, url.QueryEscape(val))
+		case val[i] == '@':
+			c.notes = append(c.notes, "curl reads "+val[:i]+" from "+val[i+1:]+
+				", it is not imported.")
+		case i == 0:
+			c.data = append(c.data, url.QueryEscape(val[1:]))
+		default:
+			c.data = append(c.data, val[:i]+"="+url.QueryEscape(val[i+1:]))
+		}
+	case "-F", "--form-string":
+		i := strings.Index(val, "=")
+		if i <= 0 {
+			return
+		}
+		k, v := val[:i], val[i+1:]
+		if name != "--form-string" && (strings.HasPrefix(v, "@") ||
+			strings.HasPrefix(v, "<")) {
+			// @file;type=text/plain;filename=name
+			parts := strings.Split(v[1:], ";")
+			args := []string{parts[0]}
+			for _, p := range parts[1:] {
+				if strings.HasPrefix(p, "type=") {
+					args = append(args, strings.TrimPrefix(p, "type="))
+				}
+			}
+			c.form = append(c.form, Pair{k, Fetch("FromDisk", args...)})
+			return
+		}
+		c.form = append(c.form, Pair{k, Literal(v)})
+	case "-b":
+		// a cookie jar file otherwise
+		if !strings.Contains(val, "=") {
+			return
+		}
+		hr := &http.Request{Header: http.Header{"Cookie": {val}}}
+		for _, cookie := range hr.Cookies() {
+			c.cookies = append(c.cookies, Pair{cookie.Name, Literal(cookie.Value)})
+		}
+	case "-u":
+		user, pass := val, ""
+		if i := strings.Index(val, ":"); i >= 0 {
+			user, pass = val[:i], val[i+1:]
+		}
+		c.auth = &Auth{"Basic", []string{user, pass}}
+	case "--oauth2-bearer":
+		c.auth = &Auth{"Bearer", []string{val}}
+	case "-A":
+		c.headers = append(c.headers, Pair{"User-Agent", Literal(val)})
+	case "-e":
+		c.headers = append(c.headers, Pair{"Referer", Literal(val)})
+	case "--url":
+		c.rawURL = val
+	case "-G":
+		c.get = true
+	case "-I":
+		c.head = true
+	}
+}
+
+// parses the words of a curl command
+func parseCurl(words []string) (*curlCommand, error) {
+	c := &curlCommand{}
+	for i := 1; i < len(words); i++ {
+		w := words[i]
+		if w == "--" {
+			if i+1 < len(words) {
+				c.rawURL = words[i+1]
+			}
+			break
+		}
+		if !strings.HasPrefix(w, "-") || w == "-" {
+			c.rawURL = w
+			continue
+		}
+
+		name, val, hasVal := w, "", false
+		if !strings.HasPrefix(w, "--") && len(w) > 2 {
+			// grouped short options like -sSL, or a value like -XPOST
+			name = ""
+			for j := 1; j < len(w); j++ {
+				o := "-" + string(w[j])
+				if curlTakesValue(o) {
+					name, val = o, w[j+1:]
+					hasVal = val != ""
+					break
+				}
+				c.apply(o, "")
+			}
+			if name == "" {
+				continue
+			}
+		}
+
+		if !curlTakesValue(name) {
+			c.apply(name, "")
+			continue
+		}
+		if !hasVal {
+			i++
+			if i == len(words) {
+				return nil, errors.New("Missing value of curl option " + name + ".")
+			}
+			val = words[i]
+		}
+		c.apply(name, val)
+	}
+
+	if c.rawURL == "" {
+		return nil, errors.New("curl command has no url.")
+	}
+	return c, nil
+}
+
+// builds the request of c on s
+func (c *curlCommand) request(s *Script) (*Request, error) {
+	raw := c.rawURL
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	data := strings.Join(c.data, "&")
+	if c.get && data != "" {
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += data
+		data = ""
+	}
+
+	req := &Request{
+		Method:  c.method,
+		Path:    s.target(u),
+		Headers: c.headers,
+		Cookies: c.cookies,
+		Auth:    c.auth,
+		Comment: strings.Join(c.notes, "\n"),
+	}
+	if req.Method == "" {
+		switch {
+		case c.head:
+			req.Method = "HEAD"
+		case data != "" || len(c.form) > 0:
+			req.Method = "POST"
+		default:
+			req.Method = "GET"
+		}
+	}
+
+	contentType := c.contentType
+	if c.json && contentType == "" {
+		contentType = "application/json"
+	}
+	trimmed := strings.TrimSpace(data)
+	switch {
+	case len(c.form) > 0:
+		req.Body = c.form
+	case data == "":
+		if contentType != "" {
+			req.Headers = append(req.Headers,
+				Pair{"Content-Type", Literal(contentType)})
+		}
+	case strings.Contains(contentType, "json") || (contentType == "" &&
+		(strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "["))):
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		req.Raw, req.RawType = data, contentType
+	case (contentType == "" ||
+		strings.HasPrefix(contentType, "application/x-www-form-urlencoded")) &&
+		formLike(data):
+		req.Body = formFields(data)
+	default:
+		if contentType == "" {
+			contentType = "application/x-www-form-urlencoded"
+		}
+		req.Raw, req.RawType = data, contentType
+	}
+	return req, nil
+}
+
+// returns true if every field of data is a name=value pair
+func formLike(data string) bool {
+	for _, field := range strings.Split(data, "&") {
+		if !strings.Contains(field, "=") || strings.HasPrefix(field, "=") {
+			return false
+		}
+	}
+	return true
+}
+
+// converts curl commands into a script which performs them in order.
+// commands can be split to lines by backslashes like browsers copy them,
+// text other than curl commands is skipped.
+func Curl(r io.Reader) (*Script, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	cmds, err := shellWords(string(b))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Script{}
+	sec := &Section{}
+	for _, words := range cmds {
+		name := path.Base(words[0])
+		if name != "curl" && name != "curl.exe" {
+			continue
+		}
+
+		c, err := parseCurl(words)
+		if err != nil {
+			return nil, err
+		}
+		req, err := c.request(s)
+		if err != nil {
+			return nil, err
+		}
+		sec.Requests = append(sec.Requests, req)
+	}
+
+	if len(sec.Requests) == 0 {
+		return nil, errors.New("No curl commands found.")
+	}
+	s.Sections = []*Section{sec}
+	s.shareHeaders()
+	return s, nil
+}
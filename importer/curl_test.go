@@ -0,0 +1,160 @@
+package importer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestShellWords(t *testing.T) {
+	tests := []struct {
+		text string
+		want [][]string
+	}{
+		{"curl http://a", [][]string{{"curl", "http://a"}}},
+		{"curl 'http://a/?x=1&y=2'", [][]string{{"curl", "http://a/?x=1&y=2"}}},
+		{`curl -H "X-A: \"b\" \$c \d"`, [][]string{{"curl", "-H", `X-A: "b" $c \d`}}},
+		{`curl -d $'a\nb\'c'`, [][]string{{"curl", "-d", "a\nb'c"}}},
+		{"curl \\\n  -X POST \\\r\n  http://a", [][]string{{"curl", "-X", "POST", "http://a"}}},
+		{"curl a\\ b", [][]string{{"curl", "a b"}}},
+		{"curl a; curl b && curl c | jq .\ncurl d",
+			[][]string{{"curl", "a"}, {"curl", "b"}, {"curl", "c"}, {"jq", "."},
+				{"curl", "d"}}},
+		{"# copied from the browser\ncurl a#b", [][]string{{"curl", "a#b"}}},
+		{"curl ''", [][]string{{"curl", ""}}},
+		{"\n  \n", [][]string{}},
+	}
+
+	for _, tt := range tests {
+		got, err := shellWords(tt.text)
+		if err != nil {
+			t.Errorf("shellWords(%q): %s", tt.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("shellWords(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestShellWordsFails(t *testing.T) {
+	for _, text := range []string{`curl 'a`, `curl "a`, `curl $'a`} {
+		if _, err := shellWords(text); err == nil {
+			t.Errorf("shellWords(%q) did not fail", text)
+		}
+	}
+}
+
+func TestParseCurl(t *testing.T) {
+	tests := []struct {
+		name  string
+		words []string
+		want  *Request
+	}{
+		{
+			"get",
+			[]string{"curl", "-sSL", "http://api.local/items?a=1"},
+			&Request{Method: "GET", Path: "/items?a=1"},
+		},
+		{
+			"grouped value",
+			[]string{"curl", "-sXPUT", "http://api.local/items/1"},
+			&Request{Method: "PUT", Path: "/items/1"},
+		},
+		{
+			"head",
+			[]string{"curl", "-I", "--url", "http://api.local/"},
+			&Request{Method: "HEAD", Path: "/"},
+		},
+		{
+			"headers",
+			[]string{"curl", "http://api.local/", "-H", "Host: x", "-H",
+				"Accept: */*", "-H", "Cookie: a=1; b=2", "-A", "agent",
+				"-u", "user:pass", "-H", "broken"},
+			&Request{Method: "GET", Path: "/",
+				Headers: []Pair{{"Accept", Literal("*/*")},
+					{"User-Agent", Literal("agent")}},
+				Cookies: []Pair{{"a", Literal("1")}, {"b", Literal("2")}},
+				Auth:    &Auth{"Basic", []string{"user", "pass"}}},
+		},
+		{
+			"bearer",
+			[]string{"curl", "--oauth2-bearer", "t0k3n", "http://api.local/"},
+			&Request{Method: "GET", Path: "/", Auth: &Auth{"Bearer", []string{"t0k3n"}}},
+		},
+		{
+			"form data",
+			[]string{"curl", "http://api.local/login", "-d", "user=a",
+				"--data-urlencode", "pass=a b&c"},
+			&Request{Method: "POST", Path: "/login",
+				Body: []Pair{{"user", Literal("a")}, {"pass", Literal("a b&c")}}},
+		},
+		{
+			"json",
+			[]string{"curl", "http://api.local/items", "--data-raw", `{"a":1}`},
+			&Request{Method: "POST", Path: "/items",
+				Raw: `{"a":1}`, RawType: "application/json"},
+		},
+		{
+			"typed body",
+			[]string{"curl", "http://api.local/items", "-H",
+				"Content-Type: text/plain", "-d", "a=1"},
+			&Request{Method: "POST", Path: "/items",
+				Raw: "a=1", RawType: "text/plain"},
+		},
+		{
+			"data of get",
+			[]string{"curl", "-G", "http://api.local/search?q=1", "-d", "page=2"},
+			&Request{Method: "GET", Path: "/search?q=1&page=2"},
+		},
+		{
+			"multipart",
+			[]string{"curl", "http://api.local/upload", "-F", "name=a",
+				"-F", "file=@photo.png;type=image/png", "--form-string", "raw=@b"},
+			&Request{Method: "POST", Path: "/upload",
+				Body: []Pair{{"name", Literal("a")},
+					{"file", Fetch("FromDisk", "photo.png", "image/png")},
+					{"raw", Literal("@b")}}},
+		},
+		{
+			"body of a file",
+			[]string{"curl", "http://api.local/items", "-d", "@body.json",
+				"--", "http://api.local/other"},
+			&Request{Method: "GET", Path: "/other",
+				Comment: "curl reads the body from body.json, it is not imported."},
+		},
+		{
+			"ignored options",
+			[]string{"curl", "-o", "out", "--max-time", "5", "-k",
+				"api.local/a"},
+			&Request{Method: "GET", Path: "/a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseCurl(tt.words)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := c.request(&Script{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("request = %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCurlFails(t *testing.T) {
+	for _, words := range [][]string{
+		{"curl"},
+		{"curl", "-s"},
+		{"curl", "http://a", "-H"},
+	} {
+		if _, err := parseCurl(words); err == nil {
+			t.Errorf("parseCurl(%q) did not fail", words)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+type harPair struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harParam struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	FileName string `json:"fileName"`
+}
+
+type harPostData struct {
+	MimeType string     `json:"mimeType"`
+	Text     string     `json:"text"`
+	Params   []harParam `json:"params"`
+}
+
+type harEntry struct {
+	PageRef         string `json:"pageref"`
+	StartedDateTime string `json:"startedDateTime"`
+	ResourceType    string `json:"_resourceType"`
+	Comment         string `json:"comment"`
+	Request         struct {
+		Method   string       `json:"method"`
+		URL      string       `json:"url"`
+		Headers  []harPair    `json:"headers"`
+		Cookies  []harPair    `json:"cookies"`
+		PostData *harPostData `json:"postData"`
+	} `json:"request"`
+	Response struct {
+		Status      int       `json:"status"`
+		Headers     []harPair `json:"headers"`
+		Cookies     []harPair `json:"cookies"`
+		RedirectURL string    `json:"redirectURL"`
+		Content     struct {
+			MimeType string `json:"mimeType"`
+		} `json:"content"`
+	} `json:"response"`
+}
+
+type harPage struct {
+	Id    string `json:"id"`
+	Title string `json:"title"`
+}
+
+type harLog struct {
+	Log struct {
+		Pages   []harPage   `json:"pages"`
+		Entries []*harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+// headers which the http client sets itself, cookies are set one by one
+var harSkippedHeaders = map[string]bool{
+	"host":              true,
+	"content-length":    true,
+	"cookie":            true,
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+	"te":                true,
+	// responses would not be decompressed for checks
+	"accept-encoding": true,
+}
+
+var staticResourceTypes = map[string]bool{
+	"image":      true,
+	"stylesheet": true,
+	"script":     true,
+	"font":       true,
+	"media":      true,
+	"manifest":   true,
+}
+
+var staticExtensions = map[string]bool{
+	".css": true, ".js": true, ".mjs": true, ".map": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".svg": true,
+	".ico": true, ".webp": true, ".avif": true, ".bmp": true,
+	".woff": true, ".woff2": true, ".ttf": true, ".otf": true, ".eot": true,
+	".mp4": true, ".webm": true, ".mp3": true, ".ogg": true, ".wav": true,
+}
+
+// returns true if e fetches an image, style, script, font or media file
+func (e *harEntry) static(u *url.URL) bool {
+	if staticResourceTypes[e.ResourceType] {
+		return true
+	}
+	if staticExtensions[strings.ToLower(path.Ext(u.Path))] {
+		return true
+	}
+
+	mime := strings.ToLower(e.Response.Content.MimeType)
+	for _, prefix := range []string{"image/", "font/", "video/", "audio/",
+		"text/css", "text/javascript", "application/javascript",
+		"application/x-javascript", "application/font"} {
+		if strings.HasPrefix(mime, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// statuses which the client of conquest follows redirects of
+var harRedirects = map[int]bool{
+	http.StatusMovedPermanently:  true,
+	http.StatusFound:             true,
+	http.StatusSeeOther:          true,
+	http.StatusTemporaryRedirect: true,
+	http.StatusPermanentRedirect: true,
+}
+
+// returns the url which e redirects to, u is the url of its request
+func (e *harEntry) redirect(u *url.URL) (string, bool) {
+	if !harRedirects[e.Response.Status] {
+		return "", false
+	}
+	loc := e.Response.RedirectURL
+	for _, hd := range e.Response.Headers {
+		if loc == "" && strings.EqualFold(hd.Name, "Location") {
+			loc = hd.Value
+		}
+	}
+	to, err := u.Parse(loc)
+	if loc == "" || err != nil {
+		return "", false
+	}
+	to.Fragment = ""
+	return to.String(), true
+}
+
+// cookie values shorter than this are not taken as tokens in headers and
+// bodies, they would match unrelated values
+const minTokenLen = 8
+
+// converts a HAR recording into a script which replays its requests in
+// order. static assets are left out if dropStatic is set. cookies which
+// earlier responses have set are left to the cookies of user, header and
+// body values which equal to their values are fetched from them. requests
+// which only follow redirects are left out, the client follows them.
+func HAR(r io.Reader, dropStatic bool) (*Script, error) {
+	h := &harLog{}
+	if err := json.NewDecoder(r).Decode(h); err != nil {
+		return nil, errors.New("Invalid HAR file: " + err.Error())
+	}
+	return harScript(h.Log.Pages, h.Log.Entries, dropStatic)
+}
+
+// converts entries into a script, requests of pages are written under
+// their titles
+func harScript(pages []harPage, entries []*harEntry,
+	dropStatic bool) (*Script, error) {
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].StartedDateTime < entries[j].StartedDateTime
+	})
+
+	s := &Script{}
+	sections := map[string]*Section{}
+	for _, p := range pages {
+		sec := &Section{Title: p.Title}
+		sections[p.Id] = sec
+		s.Sections = append(s.Sections, sec)
+	}
+	section := func(pageref string) *Section {
+		if sec, ok := sections[pageref]; ok {
+			return sec
+		}
+		sec := &Section{}
+		sections[pageref] = sec
+		s.Sections = append(s.Sections, sec)
+		return sec
+	}
+
+	// cookies which responses have set so far, and their names by their
+	// values
+	set := map[string]bool{}
+	tokens := map[string]string{}
+	value := func(v string) Value {
+		if name, ok := tokens[v]; ok {
+			return Fetch("FromCookie", name)
+		}
+		return Literal(v)
+	}
+	collect := func(e *harEntry) {
+		cookies := e.Response.Cookies
+		if len(cookies) == 0 {
+			hr := &http.Response{Header: http.Header{}}
+			for _, hd := range e.Response.Headers {
+				if strings.EqualFold(hd.Name, "Set-Cookie") {
+					hr.Header.Add("Set-Cookie", hd.Value)
+				}
+			}
+			for _, c := range hr.Cookies() {
+				cookies = append(cookies, harPair{c.Name, c.Value})
+			}
+		}
+		for _, c := range cookies {
+			set[c.Name] = true
+			if len(c.Value) >= minTokenLen {
+				tokens[c.Value] = c.Name
+			}
+		}
+	}
+
+	// requests by the urls which they redirect to. the status of the
+	// request which ends a redirect chain is expected of the request
+	// which starts it.
+	redirects := map[string]*Request{}
+	follow := func(req *Request, e *harEntry, u *url.URL) {
+		if to, ok := e.redirect(u); ok {
+			redirects[to] = req
+			return
+		}
+		req.Status = e.Response.Status
+	}
+
+	for _, e := range entries {
+		u, err := url.Parse(e.Request.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+		if e.Request.Method == "CONNECT" {
+			continue
+		}
+		if from, ok := redirects[u.String()]; ok {
+			delete(redirects, u.String())
+			collect(e)
+			follow(from, e, u)
+			continue
+		}
+		if dropStatic && e.static(u) {
+			continue
+		}
+
+		req := &Request{
+			Method:  e.Request.Method,
+			Path:    s.target(u),
+			Comment: e.Comment,
+		}
+
+		post := e.Request.PostData
+		for _, hd := range e.Request.Headers {
+			name := strings.ToLower(hd.Name)
+			if strings.HasPrefix(name, ":") || harSkippedHeaders[name] {
+				continue
+			}
+			// body builders set their own content type
+			if name == "content-type" && post != nil {
+				continue
+			}
+			req.Headers = append(req.Headers, Pair{hd.Name, value(hd.Value)})
+		}
+
+		cookies := e.Request.Cookies
+		if len(cookies) == 0 {
+			hr := &http.Request{Header: http.Header{}}
+			for _, hd := range e.Request.Headers {
+				if strings.EqualFold(hd.Name, "Cookie") {
+					hr.Header.Add("Cookie", hd.Value)
+				}
+			}
+			for _, c := range hr.Cookies() {
+				cookies = append(cookies, harPair{c.Name, c.Value})
+			}
+		}
+		for _, c := range cookies {
+			// cookies of user are sent already
+			if !set[c.Name] {
+				req.Cookies = append(req.Cookies, Pair{c.Name, Literal(c.Value)})
+			}
+		}
+
+		if post != nil {
+			mime := strings.ToLower(post.MimeType)
+			switch {
+			case len(post.Params) > 0:
+				for _, p := range post.Params {
+					if p.FileName != "" {
+						req.Body = append(req.Body,
+							Pair{p.Name, Fetch("FromDisk", p.FileName)})
+						continue
+					}
+					req.Body = append(req.Body, Pair{p.Name, value(p.Value)})
+				}
+			case strings.HasPrefix(mime, "application/x-www-form-urlencoded"):
+				for _, field := range formFields(post.Text) {
+					req.Body = append(req.Body,
+						Pair{field.Name, value(field.Value.Literal)})
+				}
+			default:
+				req.Raw, req.RawType = post.Text, post.MimeType
+			}
+		}
+
+		section(e.PageRef).Requests = append(section(e.PageRef).Requests, req)
+		collect(e)
+		follow(req, e, u)
+	}
+
+	if s.Host == "" {
+		return nil, errors.New("No http requests are recorded.")
+	}
+	s.shareHeaders()
+	return s, nil
+}
@@ -0,0 +1,174 @@
+package importer
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const harFixture = `{"log": {
+  "pages": [{"id": "p1", "title": "login"}, {"id": "p2", "title": "cart"}],
+  "entries": [
+    {"pageref": "p2", "startedDateTime": "2024-01-01T00:00:03.000Z",
+     "request": {"method": "POST", "url": "https://shop.local/cart",
+       "headers": [{"name": "X-Csrf", "value": "csrf-token-1"},
+         {"name": "Content-Type", "value": "application/json"}],
+       "cookies": [{"name": "sid", "value": "old"}, {"name": "lang", "value": "en"},
+         {"name": "theme", "value": "dark"}],
+       "postData": {"mimeType": "application/json", "text": "{\"id\":1}"}},
+     "response": {"status": 201}},
+    {"pageref": "p1", "startedDateTime": "2024-01-01T00:00:01.000Z",
+     "request": {"method": "GET", "url": "https://shop.local/login",
+       "headers": [{"name": ":authority", "value": "shop.local"},
+         {"name": "Host", "value": "shop.local"},
+         {"name": "Accept-Encoding", "value": "gzip"}]},
+     "response": {"status": 200}},
+    {"pageref": "p1", "startedDateTime": "2024-01-01T00:00:01.500Z",
+     "_resourceType": "stylesheet",
+     "request": {"method": "GET", "url": "https://shop.local/site.css"},
+     "response": {"status": 200}},
+    {"pageref": "p1", "startedDateTime": "2024-01-01T00:00:02.000Z",
+     "comment": "signs in */",
+     "request": {"method": "POST", "url": "https://shop.local/login",
+       "postData": {"mimeType": "application/x-www-form-urlencoded",
+         "text": "user=a&pass=b+c"}},
+     "response": {"status": 302, "headers": [
+       {"name": "Set-Cookie", "value": "sid=s3ss10n; Path=/"},
+       {"name": "set-cookie", "value": "csrf=csrf-token-1"},
+       {"name": "Location", "value": "/welcome#top"}]}},
+    {"pageref": "p1", "startedDateTime": "2024-01-01T00:00:02.200Z",
+     "request": {"method": "GET", "url": "https://shop.local/welcome"},
+     "response": {"status": 301, "redirectURL": "https://shop.local/home"}},
+    {"pageref": "p1", "startedDateTime": "2024-01-01T00:00:02.400Z",
+     "request": {"method": "GET", "url": "https://shop.local/home"},
+     "response": {"status": 200, "headers": [
+       {"name": "Set-Cookie", "value": "theme=dark"}]}},
+    {"pageref": "p2", "startedDateTime": "2024-01-01T00:00:04.000Z",
+     "request": {"method": "GET", "url": "https://cdn.local/logo.png?v=1"},
+     "response": {"status": 200, "content": {"mimeType": "image/png"}}},
+    {"startedDateTime": "2024-01-01T00:00:05.000Z",
+     "request": {"method": "CONNECT", "url": "https://shop.local:443"},
+     "response": {"status": 200}},
+    {"startedDateTime": "2024-01-01T00:00:06.000Z",
+     "request": {"method": "GET", "url": "wss://shop.local/live"},
+     "response": {"status": 101}}
+  ]}}`
+
+func harFixtureEntries(t *testing.T) ([]harPage, []*harEntry) {
+	h := &harLog{}
+	if err := json.Unmarshal([]byte(harFixture), h); err != nil {
+		t.Fatal(err)
+	}
+	return h.Log.Pages, h.Log.Entries
+}
+
+func TestHARScript(t *testing.T) {
+	pages, entries := harFixtureEntries(t)
+	s, err := harScript(pages, entries, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Host != "https://shop.local" {
+		t.Errorf("host = %s", s.Host)
+	}
+	want := []*Section{
+		{Title: "login", Requests: []*Request{
+			{Method: "GET", Path: "/login", Status: 200},
+			{Method: "POST", Path: "/login", Status: 200, Comment: "signs in */",
+				Body: []Pair{{"user", Literal("a")}, {"pass", Literal("b c")}}},
+		}},
+		{Title: "cart", Requests: []*Request{
+			{Method: "POST", Path: "/cart", Status: 201,
+				Headers: []Pair{{"X-Csrf", Fetch("FromCookie", "csrf")}},
+				Cookies: []Pair{{"lang", Literal("en")}},
+				Raw:     `{"id":1}`, RawType: "application/json"},
+		}},
+	}
+	if len(s.Sections) != len(want) {
+		t.Fatalf("%d sections, want %d", len(s.Sections), len(want))
+	}
+	for i, sec := range s.Sections {
+		if sec.Title != want[i].Title {
+			t.Errorf("section %d title = %q, want %q", i, sec.Title, want[i].Title)
+		}
+		if !reflect.DeepEqual(sec.Requests, want[i].Requests) {
+			for _, r := range sec.Requests {
+				t.Logf("%+v", r)
+			}
+			t.Errorf("requests of section %q differ", sec.Title)
+		}
+	}
+}
+
+func TestHARScriptRedirects(t *testing.T) {
+	h := &harLog{}
+	err := json.Unmarshal([]byte(`{"log": {"entries": [
+    {"startedDateTime": "2024-01-01T00:00:01.000Z",
+     "request": {"method": "GET", "url": "https://shop.local/old"},
+     "response": {"status": 307, "headers": [{"name": "Location", "value": "new"}]}},
+    {"startedDateTime": "2024-01-01T00:00:02.000Z",
+     "request": {"method": "GET", "url": "https://shop.local/new"},
+     "response": {"status": 304}},
+    {"startedDateTime": "2024-01-01T00:00:03.000Z",
+     "request": {"method": "GET", "url": "https://shop.local/new"},
+     "response": {"status": 200}},
+    {"startedDateTime": "2024-01-01T00:00:04.000Z",
+     "request": {"method": "GET", "url": "https://shop.local/away"},
+     "response": {"status": 302, "headers": [
+       {"name": "Location", "value": "https://sso.local/login"}]}}
+  ]}}`), h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := harScript(nil, h.Log.Entries, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// redirects which are not followed are expected as they are
+	want := []*Request{
+		{Method: "GET", Path: "/old", Status: 304},
+		{Method: "GET", Path: "/new", Status: 200},
+		{Method: "GET", Path: "/away"},
+	}
+	if !reflect.DeepEqual(s.Sections[0].Requests, want) {
+		for _, r := range s.Sections[0].Requests {
+			t.Logf("%+v", r)
+		}
+		t.Error("requests differ")
+	}
+}
+
+func TestHARScriptKeepsStatic(t *testing.T) {
+	pages, entries := harFixtureEntries(t)
+	s, err := harScript(pages, entries, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{}
+	for _, sec := range s.Sections {
+		for _, r := range sec.Requests {
+			paths = append(paths, r.Path)
+		}
+	}
+	want := []string{"/login", "/site.css", "/login", "/cart",
+		"https://cdn.local/logo.png?v=1"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("paths = %q, want %q", paths, want)
+	}
+}
+
+func TestHARFails(t *testing.T) {
+	for _, h := range []string{
+		`{`,
+		`{"log": {"entries": []}}`,
+		`{"log": {"entries": [{"request": {"method": "GET", "url": "ftp://a/b"}}]}}`,
+	} {
+		if _, err := HAR(strings.NewReader(h), true); err == nil {
+			t.Errorf("HAR(%s) did not fail", h)
+		}
+	}
+}
@@ -0,0 +1,583 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// a mapping of a yaml or json document which keeps the order of its keys
+type omap struct {
+	keys []string
+	vals map[string]interface{}
+}
+
+func (m *omap) get(key string) interface{} {
+	if m == nil {
+		return nil
+	}
+	return m.vals[key]
+}
+
+func (m *omap) has(key string) bool {
+	if m == nil {
+		return false
+	}
+	_, ok := m.vals[key]
+	return ok
+}
+
+// keys of m, a nil map has none
+func (m *omap) keysOrNil() []string {
+	if m == nil {
+		return nil
+	}
+	return m.keys
+}
+
+func (m *omap) set(key string, val interface{}) {
+	if _, ok := m.vals[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.vals[key] = val
+}
+
+func newOmap() *omap {
+	return &omap{vals: map[string]interface{}{}}
+}
+
+func (m *omap) MarshalJSON() ([]byte, error) {
+	b := &bytes.Buffer{}
+	b.WriteString("{")
+	for i, k := range m.keys {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(quote(k) + ":")
+		v, err := marshal(m.vals[k])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(v)
+	}
+	b.WriteString("}")
+	return b.Bytes(), nil
+}
+
+// marshals v as json without escaping html characters
+func marshal(v interface{}) ([]byte, error) {
+	b := &bytes.Buffer{}
+	enc := json.NewEncoder(b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
+
+// converts n into omaps, slices and scalars
+func decodeNode(n *yaml.Node) interface{} {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return nil
+		}
+		return decodeNode(n.Content[0])
+	case yaml.AliasNode:
+		return decodeNode(n.Alias)
+	case yaml.MappingNode:
+		m := newOmap()
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			m.set(n.Content[i].Value, decodeNode(n.Content[i+1]))
+		}
+		return m
+	case yaml.SequenceNode:
+		list := make([]interface{}, 0, len(n.Content))
+		for _, c := range n.Content {
+			list = append(list, decodeNode(c))
+		}
+		return list
+	}
+
+	var v interface{}
+	if err := n.Decode(&v); err != nil {
+		return n.Value
+	}
+	return v
+}
+
+func asMap(v interface{}) *omap {
+	m, _ := v.(*omap)
+	return m
+}
+
+func asList(v interface{}) []interface{} {
+	l, _ := v.([]interface{})
+	return l
+}
+
+func asString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+// returns v as a parameter or form value
+func stringify(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case *omap, []interface{}:
+		b, _ := marshal(val)
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
+
+type openAPI struct {
+	doc *omap
+	// swagger 2.0 document
+	swagger bool
+	// references whose examples are being generated, recursive schemas
+	// end at them
+	expanding map[string]bool
+}
+
+// follows local references of v
+func (o *openAPI) resolve(v interface{}) *omap {
+	m := asMap(v)
+	for i := 0; i < 32 && m.has("$ref"); i++ {
+		ref := asString(m.get("$ref"))
+		if !strings.HasPrefix(ref, "#/") {
+			return nil
+		}
+
+		var node interface{} = o.doc
+		for _, key := range strings.Split(ref[2:], "/") {
+			key = strings.Replace(strings.Replace(key, "~1", "/", -1), "~0", "~", -1)
+			node = asMap(node).get(key)
+		}
+		m = asMap(node)
+	}
+	return m
+}
+
+var sampleStrings = map[string]string{
+	"date-time": "2024-01-01T00:00:00Z",
+	"date":      "2024-01-01",
+	"time":      "00:00:00",
+	"email":     "user@example.com",
+	"uuid":      "3fa85f64-5717-4562-b3fc-2c963f66afa6",
+	"uri":       "https://example.com",
+	"url":       "https://example.com",
+	"hostname":  "example.com",
+	"ipv4":      "192.0.2.1",
+	"ipv6":      "2001:db8::1",
+	"password":  "secret",
+	"byte":      "Y29ucXVlc3Q=",
+}
+
+// returns the example of schema, or a value which is generated from its
+// type when it has none
+func (o *openAPI) example(schema interface{}, depth int) interface{} {
+	if ref := asString(asMap(schema).get("$ref")); ref != "" {
+		if o.expanding[ref] {
+			return nil
+		}
+		o.expanding[ref] = true
+		defer delete(o.expanding, ref)
+	}
+
+	s := o.resolve(schema)
+	if s == nil || depth > 8 {
+		return nil
+	}
+
+	for _, key := range []string{"example", "x-example", "default", "const"} {
+		if s.has(key) {
+			return s.get(key)
+		}
+	}
+	if examples := asList(s.get("examples")); len(examples) > 0 {
+		return examples[0]
+	}
+	if enum := asList(s.get("enum")); len(enum) > 0 {
+		return enum[0]
+	}
+
+	if all := asList(s.get("allOf")); len(all) > 0 {
+		merged := newOmap()
+		for _, sub := range all {
+			ex, ok := o.example(sub, depth+1).(*omap)
+			if !ok {
+				continue
+			}
+			for _, k := range ex.keys {
+				merged.set(k, ex.vals[k])
+			}
+		}
+		return merged
+	}
+	for _, key := range []string{"oneOf", "anyOf"} {
+		if alt := asList(s.get(key)); len(alt) > 0 {
+			return o.example(alt[0], depth+1)
+		}
+	}
+
+	typ := asString(s.get("type"))
+	// types of 3.1 schemas can be a list
+	for _, t := range asList(s.get("type")) {
+		if t != "null" {
+			typ = asString(t)
+			break
+		}
+	}
+	if typ == "" && s.has("properties") {
+		typ = "object"
+	}
+
+	switch typ {
+	case "object":
+		obj := newOmap()
+		props := asMap(s.get("properties"))
+		if props == nil {
+			return obj
+		}
+		for _, name := range props.keys {
+			prop := o.resolve(props.get(name))
+			if prop.get("readOnly") == true {
+				continue
+			}
+			if ex := o.example(props.get(name), depth+1); ex != nil {
+				obj.set(name, ex)
+			}
+		}
+		return obj
+	case "array":
+		item := o.example(s.get("items"), depth+1)
+		if item == nil {
+			return []interface{}{}
+		}
+		return []interface{}{item}
+	case "integer", "number":
+		if min, ok := s.get("minimum").(int); ok {
+			return min
+		}
+		return 1
+	case "boolean":
+		return true
+	case "string", "file":
+		if sample, ok := sampleStrings[asString(s.get("format"))]; ok {
+			return sample
+		}
+		return "string"
+	}
+	return nil
+}
+
+// returns true if schema is a file upload
+func (o *openAPI) binary(schema interface{}) bool {
+	s := o.resolve(schema)
+	return s.get("type") == "file" || s.get("format") == "binary" ||
+		s.get("format") == "base64"
+}
+
+// returns the example value of parameter p
+func (o *openAPI) paramValue(p *omap) interface{} {
+	if p.has("example") {
+		return p.get("example")
+	}
+	if examples := asMap(p.get("examples")); examples != nil && len(examples.keys) > 0 {
+		if ex := o.resolve(examples.get(examples.keys[0])); ex.has("value") {
+			return ex.get("value")
+		}
+	}
+	// swagger 2.0 parameters are typed like schemas themselves
+	if p.has("schema") {
+		return o.example(p.get("schema"), 0)
+	}
+	return o.example(p, 0)
+}
+
+// returns the example of a request body media
+func (o *openAPI) mediaExample(media *omap) interface{} {
+	if media.has("example") {
+		return media.get("example")
+	}
+	if examples := asMap(media.get("examples")); examples != nil && len(examples.keys) > 0 {
+		if ex := o.resolve(examples.get(examples.keys[0])); ex.has("value") {
+			return ex.get("value")
+		}
+	}
+	return o.example(media.get("schema"), 0)
+}
+
+// returns the scheme, host and base path of the api
+func (o *openAPI) base() (string, string) {
+	if o.swagger {
+		scheme := "http"
+		if schemes := asList(o.doc.get("schemes")); len(schemes) > 0 {
+			scheme = asString(schemes[0])
+		}
+		host := asString(o.doc.get("host"))
+		if host == "" {
+			host = "localhost"
+		}
+		return scheme + "://" + host, strings.TrimSuffix(asString(o.doc.get("basePath")), "/")
+	}
+
+	servers := asList(o.doc.get("servers"))
+	if len(servers) == 0 {
+		return "http://localhost", ""
+	}
+	server := asMap(servers[0])
+	raw := asString(server.get("url"))
+	vars := asMap(server.get("variables"))
+	for _, name := range vars.keysOrNil() {
+		raw = strings.Replace(raw, "{"+name+"}",
+			stringify(asMap(vars.get(name)).get("default")), -1)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "http://localhost", strings.TrimSuffix(raw, "/")
+	}
+	return u.Scheme + "://" + u.Host, strings.TrimSuffix(u.Path, "/")
+}
+
+// returns the lowest documented success code of responses, 2XX ranges
+// are taken as 200
+func successCode(responses *omap) int {
+	code := 0
+	for _, key := range responses.keysOrNil() {
+		c, err := strconv.Atoi(key)
+		if strings.ToUpper(key) == "2XX" {
+			c, err = 200, nil
+		}
+		if err != nil || c < 200 || c > 299 {
+			continue
+		}
+		if code == 0 || c < code {
+			code = c
+		}
+	}
+	return code
+}
+
+var operationMethods = map[string]bool{
+	"get": true, "put": true, "post": true, "delete": true,
+	"options": true, "head": true, "patch": true, "trace": true,
+}
+
+// media types which request bodies are generated for, in preference order
+var bodyMediaTypes = []string{
+	"application/json",
+	"application/x-www-form-urlencoded",
+	"multipart/form-data",
+}
+
+// converts an OpenAPI 3 or Swagger 2 specification in json or yaml into a
+// script which performs every operation once. path and required
+// parameters, and bodies are filled with examples of the specification or
+// values which are generated from schemas.
+func OpenAPI(r io.Reader) (*Script, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	root := &yaml.Node{}
+	if err := yaml.Unmarshal(b, root); err != nil {
+		return nil, errors.New("Invalid OpenAPI specification: " + err.Error())
+	}
+	o := &openAPI{
+		doc:       asMap(decodeNode(root)),
+		expanding: map[string]bool{},
+	}
+	if o.doc == nil || !(o.doc.has("openapi") || o.doc.has("swagger")) {
+		return nil, errors.New("Invalid OpenAPI specification: openapi version is not set.")
+	}
+	o.swagger = o.doc.has("swagger")
+
+	s := &Script{}
+	host, prefix := o.base()
+	s.Host = host
+
+	sections := map[string]*Section{}
+	section := func(tag string) *Section {
+		if sec, ok := sections[tag]; ok {
+			return sec
+		}
+		sec := &Section{Title: tag}
+		sections[tag] = sec
+		s.Sections = append(s.Sections, sec)
+		return sec
+	}
+
+	paths := asMap(o.doc.get("paths"))
+	for _, p := range paths.keysOrNil() {
+		item := o.resolve(paths.get(p))
+		for _, method := range item.keysOrNil() {
+			if !operationMethods[method] {
+				continue
+			}
+			op := asMap(item.get(method))
+			req := o.operation(op, prefix+p, method,
+				asList(item.get("parameters")))
+
+			tag := ""
+			if tags := asList(op.get("tags")); len(tags) > 0 {
+				tag = asString(tags[0])
+			}
+			section(tag).Requests = append(section(tag).Requests, req)
+		}
+	}
+
+	if len(s.Sections) == 0 {
+		return nil, errors.New("OpenAPI specification has no operations.")
+	}
+	s.shareHeaders()
+	return s, nil
+}
+
+// builds the request of operation op on path p
+func (o *openAPI) operation(op *omap, p, method string,
+	shared []interface{}) *Request {
+
+	req := &Request{
+		Method:  strings.ToUpper(method),
+		Comment: asString(op.get("summary")),
+		Status:  successCode(asMap(op.get("responses"))),
+	}
+	if req.Comment == "" {
+		req.Comment = asString(op.get("operationId"))
+	}
+
+	// operation parameters override the ones of their path
+	params := []*omap{}
+	index := map[string]int{}
+	for _, v := range append(shared, asList(op.get("parameters"))...) {
+		param := o.resolve(v)
+		if param == nil {
+			continue
+		}
+		key := asString(param.get("in")) + ":" + asString(param.get("name"))
+		if i, ok := index[key]; ok {
+			params[i] = param
+			continue
+		}
+		index[key] = len(params)
+		params = append(params, param)
+	}
+
+	query := []string{}
+	var bodySchema interface{}
+	consumes := asList(op.get("consumes"))
+	if len(consumes) == 0 {
+		consumes = asList(o.doc.get("consumes"))
+	}
+	for _, param := range params {
+		name := asString(param.get("name"))
+		required := param.get("required") == true
+		switch asString(param.get("in")) {
+		case "path":
+			p = strings.Replace(p, "{"+name+"}",
+				url.PathEscape(stringify(o.paramValue(param))), -1)
+		case "query":
+			if required || param.has("example") || param.has("x-example") {
+				query = append(query, url.QueryEscape(name)+"="+
+					url.QueryEscape(stringify(o.paramValue(param))))
+			}
+		case "header":
+			if required {
+				req.Headers = append(req.Headers,
+					Pair{name, Literal(stringify(o.paramValue(param)))})
+			}
+		case "cookie":
+			if required {
+				req.Cookies = append(req.Cookies,
+					Pair{name, Literal(stringify(o.paramValue(param)))})
+			}
+		case "body":
+			bodySchema = param.get("schema")
+		case "formData":
+			if o.binary(param) {
+				req.Body = append(req.Body, Pair{name, Fetch("FromDisk", name)})
+				continue
+			}
+			req.Body = append(req.Body,
+				Pair{name, Literal(stringify(o.paramValue(param)))})
+		}
+	}
+	req.Path = p
+	if len(query) > 0 {
+		req.Path += "?" + strings.Join(query, "&")
+	}
+
+	if bodySchema != nil {
+		mediaType := "application/json"
+		for _, c := range consumes {
+			if strings.Contains(asString(c), "json") {
+				mediaType = asString(c)
+				break
+			}
+		}
+		o.body(req, mediaType, o.example(bodySchema, 0), bodySchema)
+	}
+
+	content := asMap(o.resolve(op.get("requestBody")).get("content"))
+	if content != nil && len(content.keys) > 0 {
+		mediaType := content.keys[0]
+		for _, preferred := range bodyMediaTypes {
+			if content.has(preferred) {
+				mediaType = preferred
+				break
+			}
+		}
+		media := asMap(content.get(mediaType))
+		o.body(req, mediaType, o.mediaExample(media), media.get("schema"))
+	}
+	return req
+}
+
+// sets the body of req from example, fields of forms are taken from it
+func (o *openAPI) body(req *Request, mediaType string, example,
+	schema interface{}) {
+
+	form := strings.HasPrefix(mediaType, "application/x-www-form-urlencoded")
+	multipart := strings.HasPrefix(mediaType, "multipart/form-data")
+	if !form && !multipart {
+		if str, ok := example.(string); ok {
+			req.Raw, req.RawType = str, mediaType
+			return
+		}
+		if example == nil {
+			return
+		}
+		b, err := marshal(example)
+		if err != nil {
+			return
+		}
+		req.Raw, req.RawType = string(b), mediaType
+		return
+	}
+
+	fields := asMap(example)
+	props := asMap(o.resolve(schema).get("properties"))
+	for _, name := range fields.keysOrNil() {
+		if multipart && o.binary(props.get(name)) {
+			req.Body = append(req.Body, Pair{name, Fetch("FromDisk", name)})
+			continue
+		}
+		req.Body = append(req.Body, Pair{name, Literal(stringify(fields.get(name)))})
+	}
+}
@@ -0,0 +1,229 @@
+package importer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestOpenAPIExample(t *testing.T) {
+	const components = `
+components:
+  schemas:
+    Pet:
+      type: object
+      properties:
+        id: {type: integer, readOnly: true}
+        name: {type: string, example: rex}
+        tags: {type: array, items: {type: string}}
+        owner: {$ref: "#/components/schemas/Owner"}
+    Owner:
+      properties:
+        email: {type: string, format: email}
+        pets: {type: array, items: {$ref: "#/components/schemas/Pet"}}
+    a/b: {type: boolean}
+`
+	tests := []struct {
+		schema, want string
+	}{
+		{`{type: string}`, `"string"`},
+		{`{type: string, format: date-time}`, `"2024-01-01T00:00:00Z"`},
+		{`{type: string, default: x, enum: [y]}`, `"x"`},
+		{`{type: string, enum: [b, a]}`, `"b"`},
+		{`{type: integer, minimum: 5}`, `5`},
+		{`{type: number}`, `1`},
+		{`{type: ["null", boolean]}`, `true`},
+		{`{type: string, examples: [first, second]}`, `"first"`},
+		{`{const: 3}`, `3`},
+		{`{type: array, items: {type: integer}}`, `[1]`},
+		{`{type: array, items: {$ref: "#/nowhere"}}`, `[]`},
+		{`{type: object}`, `{}`},
+		{`{oneOf: [{type: integer}, {type: string}]}`, `1`},
+		{`{allOf: [{properties: {a: {type: integer}}}, {properties: {b: {example: x}}}]}`,
+			`{"a":1,"b":"x"}`},
+		{`{$ref: "#/components/schemas/a~1b"}`, `true`},
+		{`{$ref: "#/components/schemas/Pet"}`,
+			`{"name":"rex","tags":["string"],"owner":{"email":"user@example.com","pets":[]}}`},
+		{`{type: object, properties: {b: {type: string}, a: {type: integer}}}`,
+			`{"b":"string","a":1}`},
+		{`{}`, `null`},
+	}
+
+	for _, tt := range tests {
+		root := &yaml.Node{}
+		if err := yaml.Unmarshal([]byte("schema: "+tt.schema+components), root); err != nil {
+			t.Fatalf("schema %s: %s", tt.schema, err)
+		}
+		doc := asMap(decodeNode(root))
+		o := &openAPI{doc: doc, expanding: map[string]bool{}}
+
+		b, err := marshal(o.example(doc.get("schema"), 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("example of %s = %s, want %s", tt.schema, b, tt.want)
+		}
+	}
+}
+
+const openAPIFixture = `
+openapi: 3.0.3
+servers:
+  - url: "{scheme}://api.local/v1/"
+    variables:
+      scheme: {default: https}
+paths:
+  /pets/{id}:
+    parameters:
+      - {name: id, in: path, required: true, schema: {type: integer}}
+      - {name: X-Trace, in: header, required: true, schema: {type: string}}
+    get:
+      tags: [pets]
+      summary: "returns pets of /pets/*/ */"
+      parameters:
+        - {name: id, in: path, required: true, example: 7}
+        - {name: fields, in: query, schema: {type: string}}
+        - {name: page, in: query, required: true, schema: {type: integer, default: 2}}
+        - {name: q, in: query, example: a b}
+      responses:
+        "404": {description: missing}
+        2XX: {description: ok}
+    delete:
+      operationId: deletePet
+      responses:
+        "204": {description: deleted}
+  /pets:
+    post:
+      tags: [pets]
+      requestBody:
+        content:
+          text/plain: {schema: {type: string}}
+          application/json:
+            examples:
+              rex: {value: {name: rex}}
+      responses:
+        "201": {description: created}
+  /photos:
+    put:
+      requestBody:
+        content:
+          multipart/form-data:
+            schema:
+              properties:
+                title: {type: string}
+                photo: {type: string, format: binary}
+`
+
+func TestOpenAPI(t *testing.T) {
+	s, err := OpenAPI(strings.NewReader(openAPIFixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Host != "https://api.local" {
+		t.Errorf("host = %s", s.Host)
+	}
+	want := []*Section{
+		{Title: "pets", Requests: []*Request{
+			{Method: "GET", Path: "/v1/pets/7?page=2&q=a+b", Status: 200,
+				Comment: "returns pets of /pets/*/ */",
+				Headers: []Pair{{"X-Trace", Literal("string")}}},
+			{Method: "POST", Path: "/v1/pets", Status: 201,
+				Raw: `{"name":"rex"}`, RawType: "application/json"},
+		}},
+		{Title: "", Requests: []*Request{
+			{Method: "DELETE", Path: "/v1/pets/1", Status: 204, Comment: "deletePet",
+				Headers: []Pair{{"X-Trace", Literal("string")}}},
+			{Method: "PUT", Path: "/v1/photos",
+				Body: []Pair{{"title", Literal("string")},
+					{"photo", Fetch("FromDisk", "photo")}}},
+		}},
+	}
+	if len(s.Sections) != len(want) {
+		t.Fatalf("%d sections, want %d", len(s.Sections), len(want))
+	}
+	for i, sec := range s.Sections {
+		if sec.Title != want[i].Title {
+			t.Errorf("section %d title = %q, want %q", i, sec.Title, want[i].Title)
+		}
+		if !reflect.DeepEqual(sec.Requests, want[i].Requests) {
+			for _, r := range sec.Requests {
+				t.Logf("%+v", r)
+			}
+			t.Errorf("requests of section %q differ", sec.Title)
+		}
+	}
+}
+
+const swaggerFixture = `{
+  "swagger": "2.0",
+  "host": "legacy.local",
+  "basePath": "/api/",
+  "schemes": ["https", "http"],
+  "consumes": ["application/vnd.api+json"],
+  "paths": {
+    "/orders": {
+      "post": {
+        "parameters": [
+          {"name": "order", "in": "body", "schema": {"$ref": "#/definitions/Order"}},
+          {"name": "limit", "in": "query", "required": true, "type": "integer", "x-example": 3}
+        ],
+        "responses": {"200": {"description": "ok"}}
+      }
+    },
+    "/files": {
+      "post": {
+        "consumes": ["multipart/form-data"],
+        "parameters": [
+          {"name": "file", "in": "formData", "type": "file"},
+          {"name": "note", "in": "formData", "type": "string", "default": "n"}
+        ]
+      }
+    }
+  },
+  "definitions": {
+    "Order": {"type": "object", "properties": {"qty": {"type": "integer"}}}
+  }
+}`
+
+func TestOpenAPISwagger(t *testing.T) {
+	s, err := OpenAPI(strings.NewReader(swaggerFixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Host != "https://legacy.local" {
+		t.Errorf("host = %s", s.Host)
+	}
+	want := []*Request{
+		{Method: "POST", Path: "/api/orders?limit=3", Status: 200,
+			Raw: `{"qty":1}`, RawType: "application/vnd.api+json"},
+		{Method: "POST", Path: "/api/files",
+			Body: []Pair{{"file", Fetch("FromDisk", "file")},
+				{"note", Literal("n")}}},
+	}
+	if len(s.Sections) != 1 || !reflect.DeepEqual(s.Sections[0].Requests, want) {
+		for _, sec := range s.Sections {
+			for _, r := range sec.Requests {
+				t.Logf("%+v", r)
+			}
+		}
+		t.Error("requests differ")
+	}
+}
+
+func TestOpenAPIFails(t *testing.T) {
+	for _, spec := range []string{
+		`{`,
+		`info: {title: a}`,
+		`openapi: 3.0.0`,
+		`{"openapi": "3.0.0", "paths": {"/a": {"parameters": []}}}`,
+	} {
+		if _, err := OpenAPI(strings.NewReader(spec)); err == nil {
+			t.Errorf("OpenAPI(%s) did not fail", spec)
+		}
+	}
+}
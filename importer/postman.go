@@ -0,0 +1,371 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// src of a file is either a path or a list of paths, form data fields
+// have an empty list if no file is chosen
+type postmanSrc []string
+
+func (s *postmanSrc) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '[' {
+		return json.Unmarshal(b, (*[]string)(s))
+	}
+	path := ""
+	if err := json.Unmarshal(b, &path); err != nil {
+		return err
+	}
+	*s = nil
+	if path != "" {
+		*s = postmanSrc{path}
+	}
+	return nil
+}
+
+type postmanValue struct {
+	Key      string     `json:"key"`
+	Value    string     `json:"value"`
+	Type     string     `json:"type"`
+	Src      postmanSrc `json:"src"`
+	Disabled bool       `json:"disabled"`
+	// environment values are disabled by this
+	Enabled *bool `json:"enabled"`
+}
+
+func (v *postmanValue) off() bool {
+	return v.Disabled || (v.Enabled != nil && !*v.Enabled)
+}
+
+type postmanAuth struct {
+	Type   string         `json:"type"`
+	Basic  []postmanValue `json:"basic"`
+	Bearer []postmanValue `json:"bearer"`
+	APIKey []postmanValue `json:"apikey"`
+}
+
+func (a *postmanAuth) param(params []postmanValue, key string) string {
+	for _, p := range params {
+		if p.Key == key {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+// url of a request is either a string or an object
+type postmanURL struct {
+	Raw string `json:"raw"`
+}
+
+func (u *postmanURL) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		return json.Unmarshal(b, &u.Raw)
+	}
+	obj := struct {
+		Raw string `json:"raw"`
+	}{}
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return err
+	}
+	u.Raw = obj.Raw
+	return nil
+}
+
+type postmanItem struct {
+	Name    string         `json:"name"`
+	Item    []*postmanItem `json:"item"`
+	Auth    *postmanAuth   `json:"auth"`
+	Request *struct {
+		Method string         `json:"method"`
+		URL    postmanURL     `json:"url"`
+		Header []postmanValue `json:"header"`
+		Auth   *postmanAuth   `json:"auth"`
+		Body   *struct {
+			Mode       string         `json:"mode"`
+			Raw        string         `json:"raw"`
+			URLEncoded []postmanValue `json:"urlencoded"`
+			FormData   []postmanValue `json:"formdata"`
+			File       *struct {
+				Src postmanSrc `json:"src"`
+			} `json:"file"`
+			GraphQL *struct {
+				Query     string `json:"query"`
+				Variables string `json:"variables"`
+			} `json:"graphql"`
+			Options struct {
+				Raw struct {
+					Language string `json:"language"`
+				} `json:"raw"`
+			} `json:"options"`
+		} `json:"body"`
+	} `json:"request"`
+}
+
+type postmanCollection struct {
+	Info struct {
+		Name   string `json:"name"`
+		Schema string `json:"schema"`
+	} `json:"info"`
+	Item     []*postmanItem `json:"item"`
+	Auth     *postmanAuth   `json:"auth"`
+	Variable []postmanValue `json:"variable"`
+}
+
+type postmanEnvironment struct {
+	Values []postmanValue `json:"values"`
+}
+
+var postmanVariable = regexp.MustCompile(`\{\{\s*([^{}\s]+)\s*\}\}`)
+
+// a postman collection which is being converted
+type postman struct {
+	s    *Script
+	vars map[string]string
+}
+
+// replaces known variables in text, unknown ones like dynamic variables
+// are left as they are
+func (p *postman) expand(text string) string {
+	return postmanVariable.ReplaceAllStringFunc(text, func(v string) string {
+		name := postmanVariable.FindStringSubmatch(v)[1]
+		if val, ok := p.vars[name]; ok {
+			return val
+		}
+		return v
+	})
+}
+
+var rawLanguageTypes = map[string]string{
+	"json":       "application/json",
+	"xml":        "application/xml",
+	"html":       "text/html",
+	"javascript": "application/javascript",
+	"text":       "text/plain",
+}
+
+// walks items in their order, requests of a folder are written under its
+// path
+func (p *postman) walk(items []*postmanItem, folder string,
+	auth *postmanAuth) error {
+
+	for _, it := range items {
+		itemAuth := auth
+		if it.Auth != nil {
+			itemAuth = it.Auth
+		}
+
+		if it.Request == nil {
+			name := it.Name
+			if folder != "" {
+				name = folder + " / " + it.Name
+			}
+			if err := p.walk(it.Item, name, itemAuth); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if it.Request.Auth != nil {
+			itemAuth = it.Request.Auth
+		}
+		req, err := p.request(it, itemAuth)
+		if err != nil {
+			return errors.New(it.Name + ": " + err.Error())
+		}
+
+		sections := p.s.Sections
+		if len(sections) == 0 || sections[len(sections)-1].Title != folder {
+			p.s.Sections = append(sections, &Section{Title: folder})
+		}
+		sec := p.s.Sections[len(p.s.Sections)-1]
+		sec.Requests = append(sec.Requests, req)
+	}
+	return nil
+}
+
+// builds the request of item it which auth authorizes
+func (p *postman) request(it *postmanItem, auth *postmanAuth) (*Request, error) {
+	r := it.Request
+	raw := strings.TrimSpace(p.expand(r.URL.Raw))
+	if raw == "" {
+		return nil, errors.New("request has no url")
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	// api keys which are sent in the query are added to the url
+	if auth != nil && auth.Type == "apikey" &&
+		auth.param(auth.APIKey, "in") == "query" {
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += url.QueryEscape(p.expand(auth.param(auth.APIKey, "key"))) +
+			"=" + url.QueryEscape(p.expand(auth.param(auth.APIKey, "value")))
+	}
+
+	method := strings.ToUpper(r.Method)
+	if method == "" {
+		method = "GET"
+	}
+	req := &Request{
+		Method:  method,
+		Path:    p.s.target(u),
+		Comment: it.Name,
+	}
+
+	contentType := ""
+	for _, h := range r.Header {
+		if h.off() {
+			continue
+		}
+		name, val := p.expand(h.Key), p.expand(h.Value)
+		switch strings.ToLower(name) {
+		case "content-type":
+			contentType = val
+		case "cookie":
+			for _, field := range strings.Split(val, ";") {
+				if i := strings.Index(field, "="); i > 0 {
+					req.Cookies = append(req.Cookies, Pair{
+						strings.TrimSpace(field[:i]),
+						Literal(strings.TrimSpace(field[i+1:]))})
+				}
+			}
+		case "host", "content-length":
+		default:
+			req.Headers = append(req.Headers, Pair{name, Literal(val)})
+		}
+	}
+
+	if auth != nil {
+		switch auth.Type {
+		case "basic":
+			req.Headers = append(req.Headers, Pair{"Authorization", Literal(
+				basicAuth(p.expand(auth.param(auth.Basic, "username")),
+					p.expand(auth.param(auth.Basic, "password"))))})
+		case "bearer":
+			req.Headers = append(req.Headers, Pair{"Authorization",
+				Literal("Bearer " + p.expand(auth.param(auth.Bearer, "token")))})
+		case "apikey":
+			if auth.param(auth.APIKey, "in") != "query" {
+				req.Headers = append(req.Headers, Pair{
+					p.expand(auth.param(auth.APIKey, "key")),
+					Literal(p.expand(auth.param(auth.APIKey, "value")))})
+			}
+		}
+	}
+
+	body := r.Body
+	if body == nil {
+		return req, nil
+	}
+	switch body.Mode {
+	case "raw":
+		if body.Raw == "" {
+			break
+		}
+		if contentType == "" {
+			contentType = rawLanguageTypes[body.Options.Raw.Language]
+		}
+		req.Raw, req.RawType = p.expand(body.Raw), contentType
+	case "urlencoded":
+		for _, f := range body.URLEncoded {
+			if !f.off() {
+				req.Body = append(req.Body,
+					Pair{p.expand(f.Key), Literal(p.expand(f.Value))})
+			}
+		}
+	case "formdata":
+		for _, f := range body.FormData {
+			if f.off() {
+				continue
+			}
+			if f.Type == "file" {
+				key := p.expand(f.Key)
+				switch len(f.Src) {
+				case 0:
+					req.Comment += "\nno file is chosen for " + key +
+						" in postman, it is not imported."
+					continue
+				case 1:
+				default:
+					req.Comment += "\nonly the first file of " + key +
+						" is imported."
+				}
+				req.Body = append(req.Body, Pair{key, Fetch("FromDisk", f.Src[0])})
+				continue
+			}
+			req.Body = append(req.Body,
+				Pair{p.expand(f.Key), Literal(p.expand(f.Value))})
+		}
+	case "file":
+		if body.File != nil && len(body.File.Src) > 0 {
+			req.Comment += "\nbody is read from " + body.File.Src[0] +
+				" by postman, it is not imported."
+		}
+	case "graphql":
+		if body.GraphQL == nil {
+			break
+		}
+		gql := map[string]interface{}{"query": p.expand(body.GraphQL.Query)}
+		if vars := strings.TrimSpace(p.expand(body.GraphQL.Variables)); vars != "" {
+			gql["variables"] = json.RawMessage(vars)
+		}
+		b, err := marshal(gql)
+		if err != nil {
+			return nil, err
+		}
+		req.Raw, req.RawType = string(b), "application/json"
+	}
+	return req, nil
+}
+
+// converts a Postman v2.1 collection into a script which performs its
+// requests in order, folders are written as sections. variables are
+// replaced by the values of env, then of the collection.
+func Postman(r io.Reader, env io.Reader) (*Script, error) {
+	c := &postmanCollection{}
+	if err := json.NewDecoder(r).Decode(c); err != nil {
+		return nil, errors.New("Invalid Postman collection: " + err.Error())
+	}
+	if c.Info.Schema != "" && !strings.Contains(c.Info.Schema, "v2.") {
+		return nil, errors.New("Only Postman v2 collections are supported.")
+	}
+
+	p := &postman{s: &Script{}, vars: map[string]string{}}
+	for _, v := range c.Variable {
+		if !v.off() {
+			p.vars[v.Key] = v.Value
+		}
+	}
+	if env != nil {
+		e := &postmanEnvironment{}
+		if err := json.NewDecoder(env).Decode(e); err != nil {
+			return nil, errors.New("Invalid Postman environment: " + err.Error())
+		}
+		for _, v := range e.Values {
+			if !v.off() {
+				p.vars[v.Key] = v.Value
+			}
+		}
+	}
+
+	if err := p.walk(c.Item, "", c.Auth); err != nil {
+		return nil, err
+	}
+	if p.s.Host == "" {
+		return nil, errors.New("Postman collection has no requests.")
+	}
+	p.s.shareHeaders()
+	return p.s, nil
+}
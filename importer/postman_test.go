@@ -0,0 +1,99 @@
+package importer
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPostmanSrc(t *testing.T) {
+	tests := []struct {
+		json string
+		want postmanSrc
+	}{
+		{`"a.png"`, postmanSrc{"a.png"}},
+		{`""`, nil},
+		{`null`, nil},
+		{`[]`, postmanSrc{}},
+		{`["a.png", "b.png"]`, postmanSrc{"a.png", "b.png"}},
+	}
+
+	for _, tt := range tests {
+		var src postmanSrc
+		if err := src.UnmarshalJSON([]byte(tt.json)); err != nil {
+			t.Errorf("src %s: %s", tt.json, err)
+			continue
+		}
+		if !reflect.DeepEqual(src, tt.want) {
+			t.Errorf("src %s = %q, want %q", tt.json, src, tt.want)
+		}
+	}
+}
+
+func TestPostman(t *testing.T) {
+	f, err := os.Open("testdata/collection.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	s, err := Postman(f, strings.NewReader(
+		`{"values": [{"key": "token", "value": "env", "enabled": true}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Host != "https://shop.local" {
+		t.Errorf("host = %s", s.Host)
+	}
+	want := []*Section{
+		{Title: "products", Requests: []*Request{
+			{Method: "GET", Path: "/products?page=1&api_key=env",
+				Comment: "list products",
+				Headers: []Pair{{"Accept", Literal("application/json")}}},
+			{Method: "POST", Path: "/products/1/photos?api_key=env",
+				Comment: "upload photos" +
+					"\nno file is chosen for none in postman, it is not imported." +
+					"\nno file is chosen for unset in postman, it is not imported." +
+					"\nonly the first file of gallery is imported.",
+				Body: []Pair{{"title", Literal("front")},
+					{"photo", Fetch("FromDisk", "front.png")},
+					{"gallery", Fetch("FromDisk", "a.png")}}},
+		}},
+		{Title: "", Requests: []*Request{
+			{Method: "PUT", Path: "/catalog",
+				Comment: "import catalog" +
+					"\nbody is read from catalog.csv by postman, it is not imported.",
+				Headers: []Pair{{"X-Api-Key", Literal("env")}}},
+		}},
+	}
+	if len(s.Sections) != len(want) {
+		t.Fatalf("%d sections, want %d", len(s.Sections), len(want))
+	}
+	for i, sec := range s.Sections {
+		if sec.Title != want[i].Title {
+			t.Errorf("section %d title = %q, want %q", i, sec.Title, want[i].Title)
+		}
+		if !reflect.DeepEqual(sec.Requests, want[i].Requests) {
+			for _, r := range sec.Requests {
+				t.Logf("%+v", r)
+			}
+			t.Errorf("requests of section %q differ", sec.Title)
+		}
+	}
+}
+
+func TestPostmanFails(t *testing.T) {
+	tests := []string{
+		`{`,
+		`{"info": {"schema": "https://schema.getpostman.com/json/collection/v1.0.0/"}}`,
+		`{"item": []}`,
+		`{"item": [{"name": "a", "request": {"url": ""}}]}`,
+	}
+	for _, c := range tests {
+		if _, err := Postman(strings.NewReader(c), nil); err == nil {
+			t.Errorf("Postman(%s) did not fail", c)
+		}
+	}
+}
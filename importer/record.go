@@ -0,0 +1,314 @@
+package importer
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// larger request bodies are not recorded
+	maxRecordedBody = 1 << 20
+	// recorded requests are saved at most this often
+	recordSaveInterval = time.Second
+)
+
+// a proxy which records the requests passing through into a script. it
+// is a reverse proxy of its target, or a forward proxy when it has none.
+// https requests of a forward proxy are tunneled without being recorded.
+type Recorder struct {
+	target     *url.URL
+	dropStatic bool
+	proxy      *httputil.ReverseProxy
+	// called with the script of recorded requests, at most once in
+	// recordSaveInterval and by Flush
+	save func(*Script) error
+	// called for every recorded request and save errors
+	Log func(format string, args ...interface{})
+
+	m       sync.Mutex
+	entries []*harEntry
+	// entries which are saved, and the pending save of the rest
+	saved int
+	timer *time.Timer
+	// saves are written one by one
+	saveM sync.Mutex
+}
+
+func NewRecorder(target *url.URL, dropStatic bool,
+	save func(*Script) error) *Recorder {
+
+	rec := &Recorder{
+		target:     target,
+		dropStatic: dropStatic,
+		save:       save,
+		Log:        func(string, ...interface{}) {},
+	}
+
+	rec.proxy = &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			if target == nil {
+				return
+			}
+			*req.URL = rec.upstream(*req.URL)
+			req.Host = target.Host
+		},
+		ModifyResponse: rec.rewrite,
+	}
+	return rec
+}
+
+func singleJoin(a, b string) string {
+	if a == "" || a == "/" {
+		return b
+	}
+	return strings.TrimSuffix(a, "/") + "/" + strings.TrimPrefix(b, "/")
+}
+
+// returns the url of the target which u of the proxy is forwarded to
+func (rec *Recorder) upstream(u url.URL) url.URL {
+	if rec.target != nil {
+		u.Scheme, u.Host = rec.target.Scheme, rec.target.Host
+		u.Path = singleJoin(rec.target.Path, u.Path)
+	}
+	return u
+}
+
+// makes cookies and redirects of the target work on the proxy address
+func (rec *Recorder) rewrite(res *http.Response) error {
+	if rec.target == nil {
+		return nil
+	}
+
+	cookies := res.Header["Set-Cookie"]
+	for i, c := range cookies {
+		attrs := strings.Split(c, ";")
+		kept := attrs[:1]
+		for _, attr := range attrs[1:] {
+			name := strings.ToLower(strings.TrimSpace(attr))
+			if strings.HasPrefix(name, "domain=") || name == "secure" {
+				continue
+			}
+			kept = append(kept, attr)
+		}
+		cookies[i] = strings.Join(kept, ";")
+	}
+
+	// the proxy forwards its paths under the path of target
+	loc, err := url.Parse(res.Header.Get("Location"))
+	if err != nil || loc.String() == "" ||
+		(loc.Host != "" && loc.Host != rec.target.Host) {
+		return nil
+	}
+	base := strings.TrimSuffix(rec.target.Path, "/")
+	if loc.Path != "" && (loc.Path == base || strings.HasPrefix(loc.Path, base+"/")) {
+		loc.Path = "/" + strings.TrimPrefix(loc.Path[len(base):], "/")
+	}
+	loc.Scheme, loc.Host = "", ""
+	res.Header.Set("Location", loc.String())
+	return nil
+}
+
+// keeps the status of the response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *Recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodConnect {
+		rec.tunnel(w, req)
+		return
+	}
+	if rec.target == nil && !req.URL.IsAbs() {
+		http.Error(w, "conquest record: request is not for a proxy",
+			http.StatusBadRequest)
+		return
+	}
+
+	started := time.Now()
+	body, _ := ioutil.ReadAll(io.LimitReader(req.Body, maxRecordedBody+1))
+	req.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), req.Body))
+
+	u := rec.upstream(*req.URL)
+	e := &harEntry{StartedDateTime: started.UTC().Format(
+		"2006-01-02T15:04:05.000000000Z")}
+	e.Request.Method = req.Method
+	e.Request.URL = u.String()
+	e.Request.Headers = harHeaders(req.Header)
+	if len(body) > maxRecordedBody {
+		e.Comment = "body is larger than 1MB, it is not recorded."
+	} else if len(body) > 0 {
+		e.Request.PostData = postData(req.Header.Get("Content-Type"), body)
+	}
+
+	sw := &statusWriter{ResponseWriter: w}
+	rec.proxy.ServeHTTP(sw, req)
+
+	e.Response.Status = sw.status
+	e.Response.Headers = harHeaders(w.Header())
+	e.Response.Content.MimeType = w.Header().Get("Content-Type")
+	// the request which follows a redirect is recorded by its url of
+	// the target
+	if loc := w.Header().Get("Location"); loc != "" {
+		if to, err := req.URL.Parse(loc); err == nil {
+			if to.Host == "" {
+				*to = rec.upstream(*to)
+			}
+			e.Response.RedirectURL = to.String()
+		}
+	}
+	rec.Log("%s %s %d", req.Method, u.String(), sw.status)
+
+	rec.m.Lock()
+	defer rec.m.Unlock()
+	rec.entries = append(rec.entries, e)
+	if rec.timer == nil {
+		rec.timer = time.AfterFunc(recordSaveInterval, func() {
+			if err := rec.Flush(); err != nil {
+				rec.Log("%s", err)
+			}
+		})
+	}
+}
+
+// saves the requests which are recorded since the last save, it is
+// called on shutdown for the ones which are not saved yet
+func (rec *Recorder) Flush() error {
+	rec.saveM.Lock()
+	defer rec.saveM.Unlock()
+
+	rec.m.Lock()
+	if rec.timer != nil {
+		rec.timer.Stop()
+		rec.timer = nil
+	}
+	pending := rec.saved < len(rec.entries)
+	rec.saved = len(rec.entries)
+	s, err := rec.script()
+	rec.m.Unlock()
+
+	// nothing is recorded which a script can be made of yet
+	if !pending || err != nil {
+		return nil
+	}
+	return rec.save(s)
+}
+
+// converts the recorded requests into a script, rec.m must be held
+func (rec *Recorder) script() (*Script, error) {
+	// conversion sorts and reads the entries only
+	entries := make([]*harEntry, len(rec.entries))
+	copy(entries, rec.entries)
+	return harScript(nil, entries, rec.dropStatic)
+}
+
+// returns the script of requests recorded so far
+func (rec *Recorder) Script() (*Script, error) {
+	rec.m.Lock()
+	defer rec.m.Unlock()
+	return rec.script()
+}
+
+// connects the client to the requested host, tunneled requests are not
+// recorded
+func (rec *Recorder) tunnel(w http.ResponseWriter, req *http.Request) {
+	hj, ok := w.(http.Hijacker)
+	if !ok || rec.target != nil {
+		http.Error(w, "conquest record: tunneling is not supported",
+			http.StatusMethodNotAllowed)
+		return
+	}
+
+	upstream, err := net.DialTimeout("tcp", req.Host, 10*time.Second)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	rec.Log("CONNECT %s is tunneled without recording", req.Host)
+
+	go func() {
+		io.Copy(upstream, conn)
+		upstream.Close()
+	}()
+	io.Copy(conn, upstream)
+	conn.Close()
+}
+
+// returns h as pairs which are sorted by their names
+func harHeaders(h http.Header) []harPair {
+	pairs := []harPair{}
+	for name, values := range h {
+		for _, v := range values {
+			pairs = append(pairs, harPair{name, v})
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i].Name < pairs[j].Name
+	})
+	return pairs
+}
+
+// returns body as HAR post data, fields of multipart forms are taken as
+// params
+func postData(contentType string, body []byte) *harPostData {
+	post := &harPostData{MimeType: contentType, Text: string(body)}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" {
+		return post
+	}
+
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		if part.FileName() != "" {
+			post.Params = append(post.Params, harParam{
+				Name: part.FormName(), FileName: part.FileName()})
+			continue
+		}
+		value, _ := ioutil.ReadAll(part)
+		post.Params = append(post.Params, harParam{
+			Name: part.FormName(), Value: string(value)})
+	}
+	return post
+}
@@ -0,0 +1,117 @@
+package importer
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	// the target answers with the length of the body it gets
+	target := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			body, _ := ioutil.ReadAll(req.Body)
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: "s3ss10n-id",
+				Domain: "target.local", Secure: true})
+			w.Write([]byte(strconv.Itoa(len(body))))
+		}))
+	defer target.Close()
+
+	u, _ := url.Parse(target.URL + "/api")
+	saves := []*Script{}
+	rec := NewRecorder(u, true, func(s *Script) error {
+		saves = append(saves, s)
+		return nil
+	})
+	proxy := httptest.NewServer(rec)
+	defer proxy.Close()
+
+	big := strings.Repeat("a", maxRecordedBody+1)
+	for _, body := range []string{"user=a", big} {
+		res, err := http.Post(proxy.URL+"/login", "application/x-www-form-urlencoded",
+			strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if string(got) != strconv.Itoa(len(body)) {
+			t.Errorf("target got %s bytes of %d", got, len(body))
+		}
+		if c := res.Header.Get("Set-Cookie"); c != "sid=s3ss10n-id" {
+			t.Errorf("cookie of the proxy = %s", c)
+		}
+	}
+
+	if err := rec.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(saves) != 1 {
+		t.Fatalf("%d saves, want 1", len(saves))
+	}
+
+	s := saves[0]
+	if s.Host != target.URL {
+		t.Errorf("host = %s, want %s", s.Host, target.URL)
+	}
+	reqs := s.Sections[0].Requests
+	if len(reqs) != 2 {
+		t.Fatalf("%d requests, want 2", len(reqs))
+	}
+	if reqs[0].Path != "/api/login" || !reflect.DeepEqual(reqs[0].Body,
+		[]Pair{{"user", Literal("a")}}) {
+		t.Errorf("first request = %+v", reqs[0])
+	}
+	if reqs[1].Body != nil || reqs[1].Raw != "" ||
+		reqs[1].Comment != "body is larger than 1MB, it is not recorded." {
+		t.Errorf("large request = %+v", reqs[1])
+	}
+}
+
+func TestRecorderRedirects(t *testing.T) {
+	var target *httptest.Server
+	target = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			if req.URL.Path == "/api/login" {
+				http.Redirect(w, req, target.URL+"/api/home", http.StatusSeeOther)
+				return
+			}
+			w.Write([]byte(req.URL.Path))
+		}))
+	defer target.Close()
+
+	u, _ := url.Parse(target.URL + "/api")
+	rec := NewRecorder(u, true, func(*Script) error { return nil })
+	proxy := httptest.NewServer(rec)
+	defer proxy.Close()
+
+	res, err := http.Post(proxy.URL+"/login", "text/plain", strings.NewReader("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.Request.URL.Path != "/home" || string(got) != "/api/home" {
+		t.Errorf("client is redirected to %s of %s", res.Request.URL.Path, got)
+	}
+
+	s, err := rec.Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqs := s.Sections[0].Requests
+	if len(reqs) != 1 || reqs[0].Path != "/api/login" || reqs[0].Status != 200 {
+		for _, r := range reqs {
+			t.Logf("%+v", r)
+		}
+		t.Error("the redirect is recorded as a request")
+	}
+}
@@ -0,0 +1,314 @@
+// Package importer converts recordings and api descriptions into
+// conquest.js scenarios.
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// a literal value, or a fetch call like FromCookie which gives the value
+// while the request is built
+type Value struct {
+	Literal string
+	// name of the fetch function, empty for literals
+	Fetch string
+	Args  []string
+}
+
+func Literal(s string) Value {
+	return Value{Literal: s}
+}
+
+func Fetch(fn string, args ...string) Value {
+	return Value{Fetch: fn, Args: args}
+}
+
+type Pair struct {
+	Name  string
+	Value Value
+}
+
+// authentication of a request, Type is the Auth method which is written
+// with Args, like Basic with user and password
+type Auth struct {
+	Type string
+	Args []string
+}
+
+// a transaction of the scenario
+type Request struct {
+	Method, Path string
+	// header, cookie and body values in their order
+	Headers, Cookies, Body []Pair
+	Auth                   *Auth
+	// literal body and its content type, Body is ignored when it is set
+	Raw, RawType string
+	// expected status code, zero for none
+	Status int
+	// written above the transaction
+	Comment string
+}
+
+// requests which are written together under a title
+type Section struct {
+	Title    string
+	Requests []*Request
+}
+
+// a conquest.js scenario, requests are performed in order by one user
+type Script struct {
+	// written at the top of the script
+	Source string
+	Host   string
+	// headers of every request
+	Headers  []Pair
+	Sections []*Section
+}
+
+// returns the path of u if it is on the host of s, otherwise u itself.
+// the first url sets the host.
+func (s *Script) target(u *url.URL) string {
+	origin := u.Scheme + "://" + u.Host
+	if s.Host == "" {
+		s.Host = origin
+	}
+	if origin != s.Host {
+		return origin + u.RequestURI()
+	}
+	return u.RequestURI()
+}
+
+// returns fields of an urlencoded form in their order
+func formFields(text string) []Pair {
+	fields := []Pair{}
+	for _, field := range strings.Split(text, "&") {
+		if field == "" {
+			continue
+		}
+		k, v := field, ""
+		if i := strings.Index(field, "="); i >= 0 {
+			k, v = field[:i], field[i+1:]
+		}
+		k, _ = url.QueryUnescape(k)
+		v, _ = url.QueryUnescape(v)
+		fields = append(fields, Pair{k, Literal(v)})
+	}
+	return fields
+}
+
+// returns the value of a basic authorization header
+func basicAuth(user, pass string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+}
+
+// moves headers which every request sends with the same value to the
+// conquest headers
+func (s *Script) shareHeaders() {
+	var first *Request
+	count := 0
+	for _, sec := range s.Sections {
+		for _, r := range sec.Requests {
+			if first == nil {
+				first = r
+			}
+			count++
+		}
+	}
+	if count < 2 {
+		return
+	}
+
+	for _, h := range first.Headers {
+		if h.Value.Fetch != "" {
+			continue
+		}
+		shared := true
+		for _, sec := range s.Sections {
+			for _, r := range sec.Requests {
+				if v, ok := find(r.Headers, h.Name); !ok || v != h.Value.Literal {
+					shared = false
+				}
+			}
+		}
+		if !shared {
+			continue
+		}
+
+		s.Headers = append(s.Headers, h)
+		for _, sec := range s.Sections {
+			for _, r := range sec.Requests {
+				r.Headers = remove(r.Headers, h.Name)
+			}
+		}
+	}
+}
+
+// returns the literal value of name in pairs, names are case-insensitive
+func find(pairs []Pair, name string) (string, bool) {
+	for _, p := range pairs {
+		if strings.EqualFold(p.Name, name) && p.Value.Fetch == "" {
+			return p.Value.Literal, true
+		}
+	}
+	return "", false
+}
+
+func remove(pairs []Pair, name string) []Pair {
+	rest := pairs[:0]
+	for _, p := range pairs {
+		if !strings.EqualFold(p.Name, name) {
+			rest = append(rest, p)
+		}
+	}
+	return rest
+}
+
+// returns s as a javascript string literal
+func quote(s string) string {
+	b := &strings.Builder{}
+	enc := json.NewEncoder(b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func (v Value) js() string {
+	if v.Fetch == "" {
+		return quote(v.Literal)
+	}
+	args := make([]string, len(v.Args))
+	for i, a := range v.Args {
+		args[i] = quote(a)
+	}
+	return "function(fetch){ return fetch." + v.Fetch + "(" +
+		strings.Join(args, ", ") + "); }"
+}
+
+// writes pairs as the lines of an object literal
+func writeObject(w *bufio.Writer, indent string, pairs []Pair) {
+	w.WriteString("({\n")
+	for _, p := range pairs {
+		w.WriteString(indent + "  " + quote(p.Name) + ": " + p.Value.js() + ",\n")
+	}
+	w.WriteString(indent + "})\n")
+}
+
+// writes comment as a block comment, a "*/" in comment would end it early
+// so it is written apart
+func writeComment(w *bufio.Writer, indent, comment string) {
+	comment = strings.Replace(comment, "*/", "* /", -1)
+	w.WriteString(indent + "/*\n")
+	for _, line := range strings.Split(strings.TrimSpace(comment), "\n") {
+		w.WriteString(strings.TrimRight(indent+"* "+line, " ") + "\n")
+	}
+	w.WriteString(indent + "*/\n")
+}
+
+// returns a json body as an object literal which RawBody sends as json,
+// other bodies as strings
+func rawBody(raw, contentType, indent string) string {
+	trimmed := strings.TrimSpace(raw)
+	if !strings.Contains(contentType, "json") || !json.Valid([]byte(trimmed)) ||
+		(!strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[")) {
+		return quote(raw)
+	}
+
+	b := &bytes.Buffer{}
+	if err := json.Indent(b, []byte(trimmed), indent, "  "); err != nil {
+		return quote(raw)
+	}
+	return b.String()
+}
+
+func (r *Request) write(w *bufio.Writer) {
+	const indent = "        "
+	if r.Comment != "" {
+		writeComment(w, indent, r.Comment)
+	}
+
+	w.WriteString(indent + "user\n")
+	w.WriteString(indent + "  .Do(" + quote(r.Method) + ", " + quote(r.Path) + ")\n")
+	for _, h := range r.Headers {
+		w.WriteString(indent + "  .SetHeader(" + quote(h.Name) + ", " +
+			h.Value.js() + ")\n")
+	}
+	for _, c := range r.Cookies {
+		w.WriteString(indent + "  .SetCookie(" + quote(c.Name) + ", " +
+			c.Value.js() + ")\n")
+	}
+	if r.Auth != nil {
+		args := []string{}
+		for _, a := range r.Auth.Args {
+			args = append(args, quote(a))
+		}
+		w.WriteString(indent + "  .Auth." + r.Auth.Type + "(" +
+			strings.Join(args, ", ") + ")\n")
+	}
+	if r.Raw != "" {
+		w.WriteString(indent + "  .RawBody(" + rawBody(r.Raw, r.RawType, indent+"  "))
+		if r.RawType != "" && r.RawType != "application/json" {
+			w.WriteString(", " + quote(r.RawType))
+		}
+		w.WriteString(")\n")
+	} else if len(r.Body) > 0 {
+		w.WriteString(indent + "  .Body")
+		writeObject(w, indent+"  ", r.Body)
+	}
+	if r.Status != 0 {
+		w.WriteString(indent + "  .Response\n")
+		w.WriteString(indent + "    .StatusCode(" + strconv.Itoa(r.Status) + ")\n")
+	}
+	w.WriteString(indent + ";\n")
+}
+
+// writes s as a conquest.js which performs its requests in order
+func (s *Script) Write(out io.Writer) error {
+	w := bufio.NewWriter(out)
+
+	if s.Source != "" {
+		writeComment(w, "", s.Source)
+	}
+	w.WriteString("conquest\n")
+	w.WriteString("  .Host(" + quote(s.Host) + ")\n")
+	if len(s.Headers) > 0 {
+		w.WriteString("  .Headers")
+		writeObject(w, "  ", s.Headers)
+	}
+	w.WriteString("  .Sequential()\n")
+	w.WriteString("  .Users(1, function(users){\n")
+	w.WriteString("    users\n")
+	w.WriteString("      .Then(function(user){\n")
+
+	first := true
+	for _, sec := range s.Sections {
+		if len(sec.Requests) == 0 {
+			continue
+		}
+		if !first {
+			w.WriteString("\n")
+		}
+		first = false
+
+		if sec.Title != "" {
+			writeComment(w, "        ", sec.Title)
+			w.WriteString("\n")
+		}
+		for i, r := range sec.Requests {
+			if i > 0 {
+				w.WriteString("\n")
+			}
+			r.write(w)
+		}
+	}
+
+	w.WriteString("      });\n")
+	w.WriteString("  });\n")
+	return w.Flush()
+}
@@ -0,0 +1,76 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/robertkrimen/otto/parser"
+)
+
+func TestWriteComment(t *testing.T) {
+	tests := []struct {
+		indent, comment, want string
+	}{
+		{"", "title", "/*\n* title\n*/\n"},
+		{"  ", "a\n\nb\n", "  /*\n  * a\n  *\n  * b\n  */\n"},
+		{"", "matches /api/*/items", "/*\n* matches /api/* /items\n*/\n"},
+		{"", "*/ ends */", "/*\n* * / ends * /\n*/\n"},
+	}
+
+	for _, tt := range tests {
+		b := &bytes.Buffer{}
+		w := bufio.NewWriter(b)
+		writeComment(w, tt.indent, tt.comment)
+		w.Flush()
+		if b.String() != tt.want {
+			t.Errorf("writeComment(%q) = %q, want %q", tt.comment, b.String(), tt.want)
+		}
+	}
+}
+
+// written scripts have to be valid javascript whatever the imported
+// texts are
+func TestScriptWrite(t *testing.T) {
+	s := &Script{
+		Source:  "imported from spec.yaml */ with globs /*",
+		Host:    "https://api.local",
+		Headers: []Pair{{"Accept", Literal("application/json")}},
+		Sections: []*Section{
+			{Title: "items */", Requests: []*Request{
+				{Method: "GET", Path: "/items/*/x", Status: 200,
+					Auth:    &Auth{"Basic", []string{"user", "p\"ass"}},
+					Comment: "lists items\npaths like /a/*/b */"},
+				{Method: "POST", Path: "/items",
+					Raw: "{\"name\": \"a \\\"b\\\" */\"}", RawType: "application/json",
+					Cookies: []Pair{{"session", Fetch("FromCookie", "session")}}},
+			}},
+			{Requests: []*Request{
+				{Method: "PUT", Path: "/upload",
+					Headers: []Pair{{"X-Quote", Literal("'\"\n</script>")}},
+					Body: []Pair{{"file", Fetch("FromDisk", "a.png", "image/png")},
+						{"name", Literal("a")}}},
+				{Method: "POST", Path: "/text", Raw: "a=*/", RawType: "text/plain"},
+			}},
+		},
+	}
+
+	b := &bytes.Buffer{}
+	if err := s.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseFile(nil, "conquest.js", b.String(), 0); err != nil {
+		t.Fatalf("%s\n%s", err, b.String())
+	}
+	for _, want := range []string{
+		`.RawBody("a=*/", "text/plain")`,
+		`.SetCookie("session", function(fetch){ return fetch.FromCookie("session"); })`,
+		`.StatusCode(200)`,
+		`.Auth.Basic("user", "p\"ass")`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("script does not contain %s\n%s", want, b.String())
+		}
+	}
+}
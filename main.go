@@ -4,82 +4,70 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"time"
-	
-	"github.com/brsyuksel/conquest/conquest"
+	"strings"
 )
 
-var (
-	users                       uint64
-	timeout, configfile, output string
-	sequential, verbose         bool
-)
+const usage = `usage: conquest [command] [flags] [conquest.js]
 
-func init() {
-	flag.Uint64Var(&users, "u", 10, "concurrent users.")
-	flag.StringVar(&timeout, "t", "30s",
-		"duration for performing transactions. Use s, m, h modifiers")
-	flag.StringVar(&output, "o", "", "output file for summary")
-	flag.StringVar(&configfile, "c", "conquest.js", "conquest js file path")
-	flag.BoolVar(&sequential, "s", false, "do transactions in sequential mode")
-	flag.BoolVar(&verbose, "v", false, "print failed requests")
-}
+commands:
+  run       performs a conquest.js, the default command
+  validate  reports problems of a conquest.js without sending requests
+  plan      prints what users of a conquest.js do in which order
+  report    prints the summary of results which run -save wrote
+  import    writes a conquest.js from a har, openapi, curl or postman file
+  export    writes a conquest.js as a curl script, k6 script or postman collection
+  record    writes a conquest.js from the traffic of a proxy
 
-func main() {
-	var err error
-	flag.Parse()
+run, validate, plan and export take the same flags, which override what
+the script sets. flags may come before or after the script file. flags of
+a command are listed by conquest <command> -h.`
 
-	fmt.Println("conquest", "v" + conquest.VERSION, "\n")
-
-	if _, err := os.Stat(configfile); os.IsNotExist(err) {
-		fmt.Println(configfile, "file not found")
-		os.Exit(1)
-	}
-
-	conq, err := conquest.RunScript(configfile)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
+var commands = map[string]func([]string) error{
+	"run":      run,
+	"validate": validateScript,
+	"plan":     planScript,
+	"report":   report,
+	"import":   importScript,
+	"export":   exportScript,
+	"record":   record,
+}
 
-	flag.Visit(func(f *flag.Flag) {
-		switch f.Name {
-		case "u":
-			conq.TotalUsers = users
-		case "t":
-			var duration time.Duration
-			duration, err = time.ParseDuration(timeout)
-			if err == nil {
-				conq.Duration = duration
-			}
-		case "s":
-			conq.Sequential = sequential
+// parses args into fs and returns its positional arguments. flags may
+// follow them too, like conquest run conquest.js -t 10s, arguments after
+// -- are positional.
+func parseFlags(fs *flag.FlagSet, args []string) []string {
+	positional := []string{}
+	for {
+		fs.Parse(args)
+		rest := fs.Args()
+		if len(rest) < len(args) && args[len(args)-len(rest)-1] == "--" {
+			return append(positional, rest...)
 		}
-	})
-
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		if len(rest) == 0 {
+			return positional
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
 	}
+}
 
-	fmt.Println("performing transactions...\n")
+func main() {
+	// flags without a command run the script like before
+	name, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
 
-	var fo *os.File
-	if output == "" {
-		fo = os.Stdout
-	} else {
-		fo, err = os.Create(output)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+	cmd, ok := commands[name]
+	if !ok {
+		if name != "help" {
+			fmt.Fprintln(os.Stderr, "unknown command:", name)
 		}
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
 	}
-	reporter := conquest.NewReporter(fo, verbose)
-
-	err = conquest.Perform(conq, reporter)
-	if err != nil {
-		fmt.Println(err)
+	if err := cmd(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	<-reporter.C.Done
 }
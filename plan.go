@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+
+	"github.com/brsyuksel/conquest/conquest"
+)
+
+// prints what users of a conquest.js do in which order without sending
+// any requests
+func planScript(args []string) error {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	script := newScriptFlags(fs)
+	asJSON := fs.Bool("json", false, "print the plan as json")
+	script.parse(args)
+
+	conq, err := script.load()
+	if err != nil {
+		return err
+	}
+
+	plan := conquest.NewPlan(conq)
+	if !*asJSON {
+		return plan.Write(os.Stdout)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(plan)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/brsyuksel/conquest/importer"
+)
+
+const recordUsage = "usage: conquest record [-listen :8080] [-target http://app] [-o conquest.js] [-drop-static]"
+
+// runs a recording proxy which rewrites the script file as requests are
+// recorded, the last ones are written when it is interrupted. it does not
+// start over an existing file.
+func record(args []string) error {
+	fs := flag.NewFlagSet("record", flag.ExitOnError)
+	listen := fs.String("listen", ":8080", "address of the proxy")
+	target := fs.String("target", "",
+		"url of the app to reverse proxy, a forward proxy runs without it")
+	out := fs.String("o", "conquest.js",
+		"script file to write, it must not exist")
+	dropStatic := fs.Bool("drop-static", false,
+		"leave out images, styles, scripts, fonts and media")
+	if len(parseFlags(fs, args)) != 0 {
+		return errors.New(recordUsage)
+	}
+
+	// the script is rewritten while recording, an existing one would be lost
+	if _, err := os.Stat(*out); err == nil {
+		return errors.New(*out + " exists already, give another file with -o")
+	}
+
+	var targetUrl *url.URL
+	if *target != "" {
+		var err error
+		targetUrl, err = url.Parse(*target)
+		if err != nil {
+			return err
+		}
+		if targetUrl.Scheme == "" || targetUrl.Host == "" {
+			return errors.New("Target must be an absolute url: " + *target)
+		}
+	}
+
+	save := func(script *importer.Script) error {
+		script.Source = "recorded by conquest record"
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		if err := script.Write(f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+
+	rec := importer.NewRecorder(targetUrl, *dropStatic, save)
+	rec.Log = func(format string, args ...interface{}) {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+	}
+
+	mode := "forward proxy"
+	if targetUrl != nil {
+		mode = "reverse proxy of " + targetUrl.String()
+	}
+	fmt.Fprintln(os.Stderr, "recording on", *listen, "as", mode+",",
+		"writing", *out)
+
+	srv := &http.Server{Addr: *listen, Handler: rec}
+	errC := make(chan error, 1)
+	go func() {
+		errC <- srv.ListenAndServe()
+	}()
+
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-errC:
+		rec.Flush()
+		return err
+	case <-sigC:
+		signal.Stop(sigC)
+	}
+
+	srv.Close()
+	if err := rec.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "recording is stopped, written", *out)
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/brsyuksel/conquest/conquest"
+)
+
+const reportUsage = "usage: conquest report [-v] [-o file] results.json"
+
+// writes the summary of results which conquest run -save wrote
+func report(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	output := fs.String("o", "", "output file for summary")
+	verbose := fs.Bool("v", false, "print failed requests")
+	args = parseFlags(fs, args)
+	if len(args) != 1 {
+		return errors.New(reportUsage)
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	results, err := conquest.LoadReport(f)
+	if err != nil {
+		return err
+	}
+
+	fo := os.Stdout
+	if *output != "" {
+		if fo, err = os.Create(*output); err != nil {
+			return err
+		}
+		defer fo.Close()
+	}
+	results.Summary(fo, *verbose)
+	return nil
+}
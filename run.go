@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/brsyuksel/conquest/conquest"
+)
+
+// performs a conquest.js and writes its summary
+func run(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	script := newScriptFlags(fs)
+	output := fs.String("o", "", "output file for summary")
+	save := fs.String("save", "", "write results as json for conquest report")
+	verbose := fs.Bool("v", false, "print failed requests")
+	script.parse(args)
+
+	fmt.Print("conquest v", conquest.VERSION, "\n\n")
+
+	conq, err := script.load()
+	if err != nil {
+		return err
+	}
+
+	fmt.Print("performing transactions...\n\n")
+
+	fo := os.Stdout
+	if *output != "" {
+		if fo, err = os.Create(*output); err != nil {
+			return err
+		}
+		defer fo.Close()
+	}
+	reporter := conquest.NewReporter(fo, *verbose)
+
+	// first SIGINT/SIGTERM stops the run gracefully, a second one kills it
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigC
+		signal.Stop(sigC)
+		fmt.Fprintln(os.Stderr, "interrupted, cancelling in-flight transactions and writing report...")
+		cancel()
+	}()
+
+	if err := conquest.Perform(ctx, conq, reporter); err != nil {
+		return err
+	}
+	<-reporter.C.Done
+
+	if *save == "" {
+		return nil
+	}
+	f, err := os.Create(*save)
+	if err != nil {
+		return err
+	}
+	if err := reporter.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
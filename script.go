@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brsyuksel/conquest/conquest"
+)
+
+// collects repeated name and value flags which are separated by sep
+type pairsFlag struct {
+	sep   string
+	pairs [][2]string
+}
+
+func (p *pairsFlag) String() string {
+	values := []string{}
+	for _, pair := range p.pairs {
+		values = append(values, pair[0]+p.sep+pair[1])
+	}
+	return strings.Join(values, ", ")
+}
+
+func (p *pairsFlag) Set(s string) error {
+	i := strings.Index(s, p.sep)
+	if i <= 0 {
+		return errors.New(s + " must be in name" + p.sep + "value form")
+	}
+	p.pairs = append(p.pairs, [2]string{
+		strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+len(p.sep):])})
+	return nil
+}
+
+// -u flag, either a total of users which groups share by their proportions
+// or users of a group as name=n, which can be repeated
+type usersFlag struct {
+	total  uint64
+	groups []groupUsers
+}
+
+type groupUsers struct {
+	name string
+	n    uint64
+}
+
+func (u *usersFlag) String() string {
+	values := []string{}
+	if u.total > 0 {
+		values = append(values, strconv.FormatUint(u.total, 10))
+	}
+	for _, g := range u.groups {
+		values = append(values, g.name+"="+strconv.FormatUint(g.n, 10))
+	}
+	return strings.Join(values, ", ")
+}
+
+func (u *usersFlag) Set(s string) error {
+	name, count := "", s
+	if i := strings.Index(s, "="); i >= 0 {
+		name, count = strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+		if name == "" {
+			return errors.New(s + " must be in name=number form")
+		}
+	}
+
+	n, err := strconv.ParseUint(count, 10, 64)
+	if err != nil {
+		return errors.New(s + " must be a number or in name=number form")
+	}
+	if n == 0 {
+		return errors.New("Total users can not be equal zero.")
+	}
+
+	if name == "" {
+		u.total = n
+		return nil
+	}
+	u.groups = append(u.groups, groupUsers{name: name, n: n})
+	return nil
+}
+
+// flags of the commands which load a conquest.js. flags which are given
+// override what the script sets, so one script can be used against
+// different environments.
+type scriptFlags struct {
+	fs *flag.FlagSet
+	// positional arguments, the script file
+	args                      []string
+	file, host, proto         string
+	duration, thinkTime       string
+	rampUp                    string
+	iterations, total         uint64
+	sequential                bool
+	users                     *usersFlag
+	headers, cookies, aliases *pairsFlag
+}
+
+func newScriptFlags(fs *flag.FlagSet) *scriptFlags {
+	f := &scriptFlags{
+		fs:      fs,
+		users:   &usersFlag{},
+		headers: &pairsFlag{sep: ":"},
+		cookies: &pairsFlag{sep: "="},
+		aliases: &pairsFlag{sep: "="},
+	}
+	fs.StringVar(&f.file, "c", "conquest.js",
+		"conquest js file path, a file argument is used instead if it is given")
+	fs.StringVar(&f.host, "host", "", "host of transaction paths, like https://staging.local")
+	fs.Var(f.aliases, "alias", "named origin as name=url, can be repeated")
+	fs.Var(f.headers, "H", "initial header as \"Name: value\", can be repeated")
+	fs.Var(f.cookies, "cookie", "initial cookie as name=value, can be repeated")
+	fs.StringVar(&f.proto, "proto", "", "HTTP/1.1 or HTTP/1.0")
+	fs.Var(f.users, "u", "total users which groups share by their proportions, "+
+		"or users of a group as name=n which can be repeated")
+	fs.StringVar(&f.duration, "t", "",
+		"duration for performing transactions. Use s, m, h modifiers")
+	fs.Uint64Var(&f.iterations, "n", 0,
+		"journeys per user. Duration is ignored when it is set")
+	fs.Uint64Var(&f.total, "N", 0, "stop after total transactions")
+	fs.BoolVar(&f.sequential, "s", false, "do transactions in sequential mode")
+	fs.StringVar(&f.thinkTime, "think-time", "",
+		"pause of users of every group after each transaction")
+	fs.StringVar(&f.rampUp, "ramp-up", "",
+		"duration which users of every group are started over")
+	return f
+}
+
+// parses args of the command, flags of the command are defined on fs
+// beforehand
+func (f *scriptFlags) parse(args []string) {
+	f.args = parseFlags(f.fs, args)
+}
+
+// runs the script which the parsed flags point to, then applies the
+// flags which are given over it
+func (f *scriptFlags) load() (*conquest.Conquest, error) {
+	file := f.file
+	switch len(f.args) {
+	case 0:
+	case 1:
+		file = f.args[0]
+	default:
+		return nil, errors.New("only one conquest js file can be given")
+	}
+
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return nil, errors.New(file + " file not found")
+	}
+	conq, err := conquest.RunScript(file)
+	if err != nil {
+		return nil, err
+	}
+
+	f.fs.Visit(func(fl *flag.Flag) {
+		if err != nil {
+			return
+		}
+		switch fl.Name {
+		case "host":
+			err = conq.SetHost(f.host)
+		case "alias":
+			for _, a := range f.aliases.pairs {
+				if err = conq.SetAlias(a[0], a[1]); err != nil {
+					return
+				}
+			}
+		case "H":
+			for _, h := range f.headers.pairs {
+				conq.SetInitial("Headers", h[0], h[1])
+			}
+		case "cookie":
+			for _, c := range f.cookies.pairs {
+				conq.SetInitial("Cookies", c[0], c[1])
+			}
+		case "proto":
+			err = conq.SetProto(f.proto)
+		case "u":
+			if f.users.total > 0 {
+				if err = conq.ScaleUsers(f.users.total); err != nil {
+					return
+				}
+			}
+			for _, g := range f.users.groups {
+				if err = conq.SetUsers(g.name, g.n); err != nil {
+					return
+				}
+			}
+		case "t":
+			conq.Duration, err = time.ParseDuration(f.duration)
+		case "n":
+			conq.Iterations = f.iterations
+		case "N":
+			conq.TotalTransactions = f.total
+		case "s":
+			conq.Sequential = f.sequential
+		case "think-time":
+			var d time.Duration
+			if d, err = time.ParseDuration(f.thinkTime); err == nil {
+				for _, g := range conq.Groups {
+					g.ThinkTime = d
+				}
+			}
+		case "ramp-up":
+			var d time.Duration
+			if d, err = time.ParseDuration(f.rampUp); err == nil {
+				for _, g := range conq.Groups {
+					g.RampUp = d
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conq, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/brsyuksel/conquest/conquest"
+)
+
+// reports problems of a conquest.js without sending any requests, fails
+// if there are any but warnings
+func validateScript(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	script := newScriptFlags(fs)
+	script.parse(args)
+
+	conq, err := script.load()
+	if err != nil {
+		return err
+	}
+
+	errs := 0
+	for _, p := range conquest.Validate(conq) {
+		fmt.Println(p)
+		if !p.Warning {
+			errs++
+		}
+	}
+	if errs > 0 {
+		return fmt.Errorf("%d problems found", errs)
+	}
+	return nil
+}